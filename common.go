@@ -11,12 +11,28 @@ import (
 	"log/slog"
 	"math"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 )
 
 const Sha512Name string = "Sha-512"
 
+// Header containing the number of seconds the server would like clients to
+// wait between update checks.
+const PollIntervalName string = "Poll-Interval"
+
+// Headers which clients send with update checks so that the server can count
+// clients by version. The client ID is random and identifies an installation
+// without identifying the user or machine.
+const ClientVersionName string = "Client-Version"
+const ClientPlatformName string = "Client-Platform"
+const ClientIdName string = "Client-Id"
+
+// Header containing the release channel a client follows such as "beta".
+// Clients which don't send it follow the default channel.
+const ClientChannelName string = "Client-Channel"
+
 func IsPosix() bool {
 	switch runtime.GOOS {
 	case "linux", "darwin", "freebsd", "netbsd", "openbsd", "solaris":
@@ -128,22 +144,21 @@ func (a SemVers) Len() int {
 
 func (a SemVers) Less(i, j int) bool {
 
-	if a[i].Major < a[j].Major {
-		return true
-	}
+	return a[i].Less(a[j])
+}
 
-	if a[i].Major == a[j].Minor &&
-		a[i].Minor < a[j].Minor {
-		return true
+// Returns true if this version precedes the other version
+func (v SemVer) Less(other SemVer) bool {
+
+	if v.Major != other.Major {
+		return v.Major < other.Major
 	}
 
-	if a[i].Major == a[j].Minor &&
-		a[i].Minor == a[j].Minor &&
-		a[i].Patch < a[j].Patch {
-		return true
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
 	}
 
-	return false
+	return v.Patch < other.Patch
 }
 
 func (a SemVers) Swap(i, j int) {
@@ -180,6 +195,54 @@ func (versions SemanticVersions) String() string {
 	return builder.String()
 }
 
+// Suffix of the optional detached signature file stored next to each pokemon
+// binary such as 1.0.0/pokemon.sig
+const SignatureSuffix string = ".sig"
+
+// Header containing the base64 encoded detached signature of an uploaded
+// executable.
+const SignatureName string = "Signature"
+
+// Metadata describing a single version of the CLI executable
+type Metadata struct {
+	Version string `json:"version"`
+	// The Sha-512 hash of the executable as a hexadecimal string
+	Sha512 string `json:"sha512"`
+	// The size of the executable in bytes
+	Size int64 `json:"size"`
+	// The base64 encoded detached signature of the executable or empty if the
+	// version is unsigned
+	Signature string `json:"signature,omitempty"`
+}
+
+// Stages of an update reported by clients.
+const (
+	// The client switched from one version to another.
+	ReportStageUpdated string = "updated"
+	// Downloading or verifying the version failed.
+	ReportStageDownload string = "download"
+	// Starting the version failed.
+	ReportStageStart string = "start"
+	// The client fell back from a failed version to a working version.
+	ReportStageRollback string = "rollback"
+)
+
+// The outcome of an update on a client.
+type Report struct {
+	// The anonymous client ID or empty if the client has none
+	ClientId string `json:"clientId,omitempty"`
+	Platform string `json:"platform"`
+	// The version the client was running or empty if it wasn't running one.
+	// For rollbacks, the version which failed.
+	From string `json:"from"`
+	// The version the client updated to. For rollbacks, the version the
+	// client fell back to.
+	To    string `json:"to"`
+	Stage string `json:"stage"`
+	// The failure or empty if the stage succeeded
+	Error string `json:"error,omitempty"`
+}
+
 type CliFlag struct {
 	// Long flag for the CLI arg such as "--switch"
 	Name string
@@ -209,7 +272,7 @@ func ToHexHash(hasher *hash.Hash) string {
 }
 
 // Obtain the Sha-512 hash of a file as a hexedecimal string
-func Sha512Hash(file *os.File) (string, error) {
+func Sha512Hash(file io.Reader) (string, error) {
 
 	hasher := sha512.New()
 
@@ -223,3 +286,30 @@ func Sha512Hash(file *os.File) (string, error) {
 func NewSha512Error(path string, expectedSha512 string, sha512 string) error {
 	return fmt.Errorf("expected file %s to have Sha-512 %s, but found %s", path, expectedSha512, sha512)
 }
+
+// Copies the content to a temp file and moves it into place so that the file
+// is never partially written even if the process is killed.
+func CopyFileAtomic(path string, content io.Reader, permissions os.FileMode) error {
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	if _, err = io.Copy(temp, content); err == nil {
+		err = temp.Chmod(permissions)
+	}
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
@@ -259,6 +259,20 @@ func TestSemVersSort(t *testing.T) {
 				SemVerMustParse("300.0.0", t),
 			},
 		},
+		{
+			expected: []SemVer{
+				SemVerMustParse("2.0.5", t),
+				SemVerMustParse("2.1.0", t),
+				SemVerMustParse("2.1.1", t),
+				SemVerMustParse("3.0.0", t),
+			},
+			unsorted: []SemVer{
+				SemVerMustParse("2.1.1", t),
+				SemVerMustParse("3.0.0", t),
+				SemVerMustParse("2.1.0", t),
+				SemVerMustParse("2.0.5", t),
+			},
+		},
 	} {
 		sort.Sort(testCase.unsorted)
 
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The env variable name that is used to determine if the process is a
+// detached helper which checks for updates in the background. If the value of
+// this env variable is "TRUE", the process downloads the latest version and
+// exits without printing greetings.
+const POKEMON_BACKGROUND_UPDATER string = "POKEMON_BACKGROUND_UPDATER"
+
+// The name of the file in the cache dir whose modification time records the
+// last background update check.
+const LAST_CHECK_FILE = ".last-update-check"
+
+// Returns true if the last background update check was longer ago than the
+// interval and records the current time as the last check. Recording the
+// check before it happens avoids starting several helpers at once.
+func shouldCheckForUpdates(cacheDir string, interval time.Duration) bool {
+
+	stampPath := filepath.Join(cacheDir, LAST_CHECK_FILE)
+
+	if info, err := os.Stat(stampPath); err == nil && time.Since(info.ModTime()) < interval {
+		return false
+	}
+
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return false
+	}
+
+	return os.WriteFile(stampPath, []byte(time.Now().UTC().Format(time.RFC3339)), 0o644) == nil
+}
+
+// Starts a detached helper process which checks for updates after this
+// process exits. The helper is the installed executable run with the same
+// args so that it uses the same update settings.
+func startBackgroundUpdate(exe string) error {
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=TRUE", POKEMON_BACKGROUND_UPDATER))
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// Gets the downloaded version which should run instead of the installed
+// version or the installed version if none should. The installed version runs
+// if it's newer, such as when it was installed over an older cache, unless
+// the downloaded version is pinned.
+func downloadedVersion(cacheDir string, installedVersion string) string {
+
+	version := currentVersion(cacheDir, installedVersion, false)
+
+	if version == installedVersion || version == pinnedVersion(cacheDir) {
+		return version
+	}
+
+	downloaded, downloadedErr := common.ParseSemVer(version)
+	installed, installedErr := common.ParseSemVer(installedVersion)
+
+	if downloadedErr != nil || installedErr != nil || !installed.Less(downloaded) {
+		return installedVersion
+	}
+
+	return version
+}
+
+// Runs the version most recently downloaded by the background helper if it
+// should run instead of the installed version, then exits with its exit code.
+// Otherwise this returns so that the installed version can run directly.
+func runDownloadedVersion(cacheDir string, installedVersion string) error {
+
+	version := downloadedVersion(cacheDir, installedVersion)
+
+	if version == installedVersion {
+		return nil
+	}
+
+	if err := os.Setenv(POKEMON_CLI, "TRUE"); err != nil {
+		return err
+	}
+
+	cmd, err := upgradeChildProcess(Cmd{}, cachedVersionPath(cacheDir, version), version)
+
+	if err != nil {
+		return err
+	}
+
+	cmd.Cmd.Wait()
+	os.Exit(cmd.Cmd.ProcessState.ExitCode())
+	return nil
+}
+
+// Downloads the latest version so that the next invocation uses it. If
+// selfReplaceRun is true, the installed executable is also replaced with the
+// latest version.
+func backgroundUpdate(exe string, cacheDir string, cachePolicy CachePolicy, exePermissions fs.FileMode, installedVersion string, updateUrl string, channel string, selfReplaceRun bool) error {
+
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+
+	current := currentVersion(cacheDir, installedVersion, selfReplaceRun)
+	version, err := getLatestVersion(newUpdateServer(updateUrl, channel, cacheDir, current))
+
+	if err != nil {
+		return err
+	}
+
+	// The current version was chosen with `pokemon update apply VERSION` or
+	// `pokemon update rollback`.
+	if version == current || pinnedVersion(cacheDir) != "" {
+		return nil
+	}
+
+	updateFilePath, err := downloadUpdateVersion(cacheDir, updateUrl, version, exePermissions)
+
+	if err != nil {
+		return err
+	}
+
+	if selfReplaceRun {
+		if err = replaceExecutable(exe, updateFilePath, exePermissions); err != nil {
+			return err
+		}
+	}
+
+	updateCache(cacheDir, cachePolicy, Cmd{Version: version}, Cmd{Version: current}, false)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShouldCheckForUpdatesRateLimitsChecks(t *testing.T) {
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	if !shouldCheckForUpdates(cacheDir, time.Hour) {
+		t.Errorf("Expected first check to be allowed")
+	}
+
+	if shouldCheckForUpdates(cacheDir, time.Hour) {
+		t.Errorf("Expected second check within the interval to be skipped")
+	}
+
+	lastCheck := time.Now().Add(-2 * time.Hour)
+
+	if err := os.Chtimes(filepath.Join(cacheDir, LAST_CHECK_FILE), lastCheck, lastCheck); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !shouldCheckForUpdates(cacheDir, time.Hour) {
+		t.Errorf("Expected check after the interval to be allowed")
+	}
+}
+
+func TestDownloadedVersionPrefersNewerInstalledVersion(t *testing.T) {
+
+	cacheDir := t.TempDir()
+
+	for _, version := range []string{"2.0.0", "3.0.0"} {
+		if err := os.WriteFile(cachedVersionPath(cacheDir, version), []byte(version), 0o755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	testCases := []struct {
+		state     CacheState
+		installed string
+		expected  string
+	}{
+		{CacheState{}, "2.0.0", "2.0.0"},
+		{CacheState{Current: "3.0.0"}, "2.0.0", "3.0.0"},
+		// A newer version was installed over the cache.
+		{CacheState{Current: "2.0.0"}, "3.0.0", "3.0.0"},
+		{CacheState{Current: "2.0.0", Pinned: true}, "3.0.0", "2.0.0"},
+		{CacheState{Current: "4.0.0"}, "2.0.0", "2.0.0"},
+	}
+
+	for _, testCase := range testCases {
+
+		if err := writeCacheState(cacheDir, testCase.state); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if version := downloadedVersion(cacheDir, testCase.installed); version != testCase.expected {
+			t.Errorf("Expected %s for %+v with %s installed but found %s", testCase.expected, testCase.state, testCase.installed, version)
+		}
+	}
+}
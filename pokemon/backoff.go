@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The maximum time to wait between update checks after repeated failures.
+const MAX_BACKOFF_SECS = 15 * 60
+
+// Exponential backoff with jitter used to space out update checks and
+// downloads after failures. The jitter spreads a fleet of clients out so they
+// don't all retry at the same moment when the server recovers.
+type Backoff struct {
+	// The wait after the first failure.
+	Base time.Duration
+	// The upper bound for any wait regardless of the number of failures.
+	Max      time.Duration
+	failures uint
+	random   *rand.Rand
+}
+
+func NewBackoff(base time.Duration, max time.Duration) *Backoff {
+	return &Backoff{
+		Base:   base,
+		Max:    max,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Records a failure and returns how long to wait before trying again. The
+// wait doubles with each consecutive failure (up to Max) and is randomized
+// between half and all of that value. If the server requested a longer wait
+// via Retry-After, the server's value is used instead up to Max so that a
+// misconfigured server can't stop clients from checking for updates.
+func (b *Backoff) Next(retryAfter time.Duration) time.Duration {
+
+	wait := b.Base
+
+	for i := uint(0); i < b.failures && wait < b.Max; i += 1 {
+		wait *= 2
+	}
+
+	if wait > b.Max {
+		wait = b.Max
+	}
+
+	b.failures += 1
+
+	if half := int64(wait / 2); half > 0 {
+		wait = time.Duration(half + b.random.Int63n(half))
+	}
+
+	if retryAfter > wait {
+		return min(retryAfter, b.Max)
+	}
+
+	return wait
+}
+
+// Clears all previously recorded failures.
+func (b *Backoff) Reset() {
+	b.failures = 0
+}
+
+// Returns true if the last attempt failed.
+func (b *Backoff) Failing() bool {
+	return b.failures > 0
+}
+
+// Returns a random duration in [0, interval) used to offset the first
+// periodic update check so clients started together don't poll in lockstep.
+func (b *Backoff) Splay(interval time.Duration) time.Duration {
+
+	if interval <= 0 {
+		return 0
+	}
+
+	return time.Duration(b.random.Int63n(int64(interval)))
+}
+
+// Error returned when the update server responds with an unexpected status.
+type HttpStatusError struct {
+	Url        string
+	StatusCode int
+	// The wait requested by the server via the Retry-After header or 0.
+	RetryAfter time.Duration
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("request to %s failed with status %d", e.Url, e.StatusCode)
+}
+
+func newHttpStatusError(resp *http.Response) *HttpStatusError {
+	return &HttpStatusError{
+		Url:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// Returns the Retry-After wait from an error or 0 if the error does not
+// contain one.
+func retryAfter(err error) time.Duration {
+
+	var statusErr *HttpStatusError
+
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+
+	return 0
+}
+
+// Parses a Retry-After header value which may either be a number of seconds or
+// an HTTP date. Returns 0 if the value is missing, invalid, or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+
+	if err != nil || !date.After(now) {
+		return 0
+	}
+
+	return date.Sub(now)
+}
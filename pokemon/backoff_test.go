@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsExponentiallyWithJitter(t *testing.T) {
+
+	backoff := NewBackoff(10*time.Second, 100*time.Second)
+
+	for _, expectedMax := range []time.Duration{
+		10 * time.Second,
+		20 * time.Second,
+		40 * time.Second,
+		80 * time.Second,
+		100 * time.Second,
+		100 * time.Second,
+	} {
+		wait := backoff.Next(0)
+
+		if wait < expectedMax/2 || wait >= expectedMax {
+			t.Errorf("Expected wait in [%s, %s) but found %s", expectedMax/2, expectedMax, wait)
+		}
+	}
+
+	if !backoff.Failing() {
+		t.Errorf("Expected backoff to be failing")
+	}
+
+	backoff.Reset()
+
+	if backoff.Failing() {
+		t.Errorf("Expected backoff to be reset")
+	}
+
+	if wait := backoff.Next(0); wait >= 10*time.Second {
+		t.Errorf("Expected wait less than %s after reset but found %s", 10*time.Second, wait)
+	}
+}
+
+func TestBackoffHonoursRetryAfter(t *testing.T) {
+
+	backoff := NewBackoff(time.Second, 10*time.Minute)
+
+	if wait := backoff.Next(5 * time.Minute); wait != 5*time.Minute {
+		t.Errorf("Expected Retry-After of %s but found %s", 5*time.Minute, wait)
+	}
+
+	// Retry-After is capped at the maximum backoff.
+	if wait := backoff.Next(time.Hour); wait != 10*time.Minute {
+		t.Errorf("Expected the maximum of %s but found %s", 10*time.Minute, wait)
+	}
+}
+
+func TestBackoffSplay(t *testing.T) {
+
+	backoff := NewBackoff(time.Second, time.Minute)
+
+	for range 100 {
+		if splay := backoff.Splay(time.Second); splay < 0 || splay >= time.Second {
+			t.Errorf("Expected splay in [0, %s) but found %s", time.Second, splay)
+		}
+	}
+
+	if splay := backoff.Splay(0); splay != 0 {
+		t.Errorf("Expected no splay but found %s", splay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type TestCase struct {
+		value    string
+		expected time.Duration
+	}
+
+	for _, testCase := range []TestCase{
+		{value: "", expected: 0},
+		{value: "120", expected: 2 * time.Minute},
+		{value: "-1", expected: 0},
+		{value: "soon", expected: 0},
+		{value: now.Add(time.Hour).Format(http.TimeFormat), expected: time.Hour},
+		{value: now.Add(-time.Hour).Format(http.TimeFormat), expected: 0},
+	} {
+		if actual := parseRetryAfter(testCase.value, now); actual != testCase.expected {
+			t.Errorf("Expected %s for \"%s\" but found %s", testCase.expected, testCase.value, actual)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The name of the file in the cache dir which records the current and previous
+// versions.
+const CACHE_STATE_FILE = "state.json"
+
+// Temp files older than this were abandoned by a crashed download.
+const STALE_TEMP_FILE_AGE = 24 * time.Hour
+
+// Limits on the versions kept in the download cache.
+type CachePolicy struct {
+	// The number of most recent versions to keep in addition to the current
+	// and previous versions
+	Keep int
+	// The maximum total size in bytes of cached versions or 0 for no limit.
+	// The current and previous versions are kept even if they exceed the
+	// limit.
+	MaxSize int64
+}
+
+// The versions the updater is running and would roll back to. These versions
+// are never pruned from the cache.
+type CacheState struct {
+	Current  string `json:"current"`
+	Previous string `json:"previous"`
+	// Keeps automatic updates on the current version. Set by `pokemon update
+	// apply VERSION` and `pokemon update rollback` and cleared by `pokemon
+	// update apply` without a version.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// A downloaded version of the CLI in the cache dir.
+type CachedVersion struct {
+	Version common.SemVer
+	Path    string
+	Size    int64
+}
+
+// Gets the default cache dir which is $XDG_CACHE_HOME/pokemon on Linux or the
+// platform equivalent. Falls back to the executable's dir if the platform has
+// no cache dir.
+func defaultCacheDir(exeDir string) string {
+
+	cacheDir, err := os.UserCacheDir()
+
+	if err != nil {
+		return exeDir
+	}
+
+	return filepath.Join(cacheDir, POKEMON)
+}
+
+// Gets the path of a version in the cache dir.
+func cachedVersionPath(cacheDir string, version string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%s-%s%s", POKEMON, version, exeSuffix()))
+}
+
+// Gets the version that runs by default. When replacing the executable with
+// updates, this is the installed version. Otherwise this is the current
+// version recorded in the cache state if it was downloaded or the installed
+// version if not.
+func currentVersion(cacheDir string, installedVersion string, selfReplace bool) string {
+
+	if selfReplace {
+		return installedVersion
+	}
+
+	state, err := readCacheState(cacheDir)
+
+	if err != nil || state.Current == "" {
+		return installedVersion
+	}
+
+	if _, err = os.Stat(cachedVersionPath(cacheDir, state.Current)); err != nil {
+		return installedVersion
+	}
+
+	return state.Current
+}
+
+// Gets the version automatic updates are pinned to or an empty string if they
+// aren't pinned.
+func pinnedVersion(cacheDir string) string {
+
+	state, err := readCacheState(cacheDir)
+
+	if err != nil || !state.Pinned {
+		return ""
+	}
+
+	return state.Current
+}
+
+func readCacheState(cacheDir string) (CacheState, error) {
+
+	var state CacheState
+	stateJson, err := os.ReadFile(filepath.Join(cacheDir, CACHE_STATE_FILE))
+
+	if err != nil && os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(stateJson, &state)
+	return state, err
+}
+
+// Writes the cache state to a temp file and moves it into place so readers
+// never see a partially written file.
+func writeCacheState(cacheDir string, state CacheState) error {
+
+	stateJson, err := json.Marshal(&state)
+
+	if err != nil {
+		return err
+	}
+
+	statePath := filepath.Join(cacheDir, CACHE_STATE_FILE)
+	stateTempPath := fmt.Sprintf("%s.%d.tmp", statePath, time.Now().UnixNano())
+
+	if err = os.WriteFile(stateTempPath, stateJson, 0o644); err != nil {
+		return err
+	}
+
+	if err = os.Rename(stateTempPath, statePath); err != nil {
+		os.Remove(stateTempPath)
+		return err
+	}
+
+	return nil
+}
+
+// Lists the versions in the cache dir in ascending order.
+func listCachedVersions(cacheDir string) ([]CachedVersion, error) {
+
+	entries, err := os.ReadDir(cacheDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := POKEMON + "-"
+	suffix := exeSuffix()
+	cachedVersions := make([]CachedVersion, 0, len(entries))
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+
+		version, err := common.ParseSemVer(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			continue
+		}
+
+		cachedVersions = append(cachedVersions, CachedVersion{
+			Version: version,
+			Path:    filepath.Join(cacheDir, name),
+			Size:    info.Size(),
+		})
+	}
+
+	slices.SortFunc(cachedVersions, func(a CachedVersion, b CachedVersion) int {
+		if a.Version.Less(b.Version) {
+			return -1
+		} else if b.Version.Less(a.Version) {
+			return 1
+		}
+
+		return 0
+	})
+
+	return cachedVersions, nil
+}
+
+// Removes temp files abandoned by crashed downloads which are older than the
+// specified age. Only call this while holding the cache lock. Returns the paths
+// of the removed files.
+func removeTempFiles(cacheDir string, olderThan time.Duration) ([]string, error) {
+
+	tempFiles, err := filepath.Glob(filepath.Join(cacheDir, fmt.Sprintf(".%s-*.tmp", POKEMON)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0, len(tempFiles))
+
+	for _, tempFile := range tempFiles {
+		info, err := os.Stat(tempFile)
+
+		if err != nil || time.Since(info.ModTime()) < olderThan {
+			continue
+		}
+
+		if err := os.Remove(tempFile); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+
+		removed = append(removed, tempFile)
+	}
+
+	return removed, nil
+}
+
+// Removes cached versions according to the policy along with abandoned temp
+// files. The current and previous versions from the cache state and any
+// additional protected versions are never removed. Returns the paths of the
+// removed files.
+func pruneCache(cacheDir string, policy CachePolicy, protected ...string) ([]string, error) {
+
+	// Avoid removing files while another updater is downloading them.
+	lock, _, err := acquireLock(filepath.Join(cacheDir, LOCK_FILE), LOCK_TIMEOUT_SECS*time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer lock.Release()
+
+	state, err := readCacheState(cacheDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	protected = append(protected, state.Current, state.Previous)
+
+	cachedVersions, err := listCachedVersions(cacheDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	remove := func(path string) error {
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		removed = append(removed, path)
+		return nil
+	}
+
+	// Walk from newest to oldest keeping protected and recent versions.
+	kept := make([]CachedVersion, 0, len(cachedVersions))
+	recent := 0
+
+	for i := len(cachedVersions) - 1; i >= 0; i -= 1 {
+		cachedVersion := cachedVersions[i]
+
+		if slices.Contains(protected, cachedVersion.Version.String) {
+			kept = append(kept, cachedVersion)
+		} else if recent < policy.Keep {
+			recent += 1
+			kept = append(kept, cachedVersion)
+		} else if err := remove(cachedVersion.Path); err != nil {
+			return removed, err
+		}
+	}
+
+	if policy.MaxSize > 0 {
+
+		var size int64
+
+		for _, cachedVersion := range kept {
+			size += cachedVersion.Size
+		}
+
+		// Remove the oldest unprotected versions until the cache fits.
+		for i := len(kept) - 1; i >= 0 && size > policy.MaxSize; i -= 1 {
+
+			if slices.Contains(protected, kept[i].Version.String) {
+				continue
+			}
+
+			if err := remove(kept[i].Path); err != nil {
+				return removed, err
+			}
+
+			size -= kept[i].Size
+		}
+	}
+
+	removedTempFiles, err := removeTempFiles(cacheDir, STALE_TEMP_FILE_AGE)
+	return append(removed, removedTempFiles...), err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeCachedVersions(t *testing.T, cacheDir string, size int, versions ...string) {
+	for _, version := range versions {
+		if err := os.WriteFile(cachedVersionPath(cacheDir, version), make([]byte, size), 0o755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+}
+
+func cachedVersionStrings(t *testing.T, cacheDir string) []string {
+
+	cachedVersions, err := listCachedVersions(cacheDir)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	versions := make([]string, 0, len(cachedVersions))
+
+	for _, cachedVersion := range cachedVersions {
+		versions = append(versions, cachedVersion.Version.String)
+	}
+
+	return versions
+}
+
+func TestListCachedVersionsIgnoresOtherFiles(t *testing.T) {
+
+	cacheDir := t.TempDir()
+	writeCachedVersions(t, cacheDir, 1, "10.0.0", "2.0.0", "2.1.0")
+
+	for _, name := range []string{CACHE_STATE_FILE, "pokemon-latest", ".pokemon-3.0.0.1.tmp"} {
+		if err := os.WriteFile(filepath.Join(cacheDir, name), []byte{}, 0o644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	expected := []string{"2.0.0", "2.1.0", "10.0.0"}
+
+	if actual := cachedVersionStrings(t, cacheDir); !slices.Equal(expected, actual) {
+		t.Errorf("Expected %v but found %v", expected, actual)
+	}
+}
+
+func TestPruneCacheKeepsProtectedAndRecentVersions(t *testing.T) {
+
+	cacheDir := t.TempDir()
+	writeCachedVersions(t, cacheDir, 1, "1.0.0", "2.0.0", "3.0.0", "4.0.0", "5.0.0", "6.0.0")
+
+	if err := writeCacheState(cacheDir, CacheState{Current: "2.0.0", Previous: "1.0.0"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	removed, err := pruneCache(cacheDir, CachePolicy{Keep: 2}, "3.0.0")
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(removed) != 1 || removed[0] != cachedVersionPath(cacheDir, "4.0.0") {
+		t.Errorf("Expected only 4.0.0 to be removed but found %v", removed)
+	}
+
+	expected := []string{"1.0.0", "2.0.0", "3.0.0", "5.0.0", "6.0.0"}
+
+	if actual := cachedVersionStrings(t, cacheDir); !slices.Equal(expected, actual) {
+		t.Errorf("Expected %v but found %v", expected, actual)
+	}
+}
+
+func TestPruneCacheEnforcesMaxSize(t *testing.T) {
+
+	cacheDir := t.TempDir()
+	writeCachedVersions(t, cacheDir, 10, "1.0.0", "2.0.0", "3.0.0", "4.0.0")
+
+	if err := writeCacheState(cacheDir, CacheState{Current: "1.0.0"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The protected version is kept even though it is the oldest.
+	if _, err := pruneCache(cacheDir, CachePolicy{Keep: 10, MaxSize: 25}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []string{"1.0.0", "4.0.0"}
+
+	if actual := cachedVersionStrings(t, cacheDir); !slices.Equal(expected, actual) {
+		t.Errorf("Expected %v but found %v", expected, actual)
+	}
+}
+
+func TestPruneCacheRemovesStaleTempFiles(t *testing.T) {
+
+	cacheDir := t.TempDir()
+	staleTempFile := filepath.Join(cacheDir, ".pokemon-2.0.0.1.tmp")
+	recentTempFile := filepath.Join(cacheDir, ".pokemon-2.0.0.2.tmp")
+
+	for _, path := range []string{staleTempFile, recentTempFile} {
+		if err := os.WriteFile(path, []byte{}, 0o644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	staleTime := time.Now().Add(-2 * STALE_TEMP_FILE_AGE)
+
+	if err := os.Chtimes(staleTempFile, staleTime, staleTime); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	removed, err := pruneCache(cacheDir, CachePolicy{})
+
+	if err != nil || !slices.Equal([]string{staleTempFile}, removed) {
+		t.Errorf("Expected only %s to be removed but found %v: %v", staleTempFile, removed, err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The file in the cache dir containing the anonymous client ID sent with
+// update checks.
+const CLIENT_ID_FILE = "client-id"
+
+// Gets the platform sent with update checks such as "linux/amd64".
+func clientPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+func readClientId(path string) (string, error) {
+
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	id := strings.TrimSpace(string(content))
+
+	if _, err = hex.DecodeString(id); err != nil || len(id) != 32 {
+		return "", fmt.Errorf("invalid client ID \"%s\" in %s", id, path)
+	}
+
+	return id, nil
+}
+
+// Gets the random ID which identifies this installation to the update server
+// without identifying the user or machine. The ID is created on first use.
+// Returns an empty string if the ID can't be read or created since update
+// checks work without it.
+func loadClientId(cacheDir string) string {
+
+	path := filepath.Join(cacheDir, CLIENT_ID_FILE)
+
+	if id, err := readClientId(path); err == nil {
+		return id
+	}
+
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return ""
+	}
+
+	random := make([]byte, 16)
+
+	if _, err := rand.Read(random); err != nil {
+		return ""
+	}
+
+	// Write the ID to a temp file and move it into place so that readers
+	// never see a partially written ID. Concurrent updaters may each create
+	// an ID, but the last one moved into place is used from then on.
+	tempPath := fmt.Sprintf("%s.%d.tmp", path, time.Now().UnixNano())
+
+	if err := os.WriteFile(tempPath, []byte(hex.EncodeToString(random)+"\n"), 0o644); err != nil {
+		return ""
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return ""
+	}
+
+	id, _ := readClientId(path)
+	return id
+}
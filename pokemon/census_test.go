@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func TestLoadClientIdIsStable(t *testing.T) {
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	id := loadClientId(cacheDir)
+
+	if len(id) != 32 {
+		t.Fatalf("Expected a 32 character client ID but found \"%s\"", id)
+	}
+
+	if again := loadClientId(cacheDir); again != id {
+		t.Errorf("Expected %s but found %s", id, again)
+	}
+
+	if other := loadClientId(t.TempDir()); other == id {
+		t.Errorf("Expected a different client ID for another cache dir but found %s", other)
+	}
+
+	// Corrupt IDs are replaced.
+	if err := os.WriteFile(filepath.Join(cacheDir, CLIENT_ID_FILE), []byte("not an id"), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if replaced := loadClientId(cacheDir); len(replaced) != 32 || replaced == id {
+		t.Errorf("Expected a new client ID but found \"%s\"", replaced)
+	}
+}
+
+func TestGetVersionsSendsCensusHeaders(t *testing.T) {
+
+	var headers http.Header
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.Write([]byte("{\"versions\":[\"1.0.0\"]}"))
+	}))
+	defer httpServer.Close()
+
+	cacheDir := t.TempDir()
+	server := newUpdateServer(httpServer.URL, "beta", cacheDir, "1.0.0")
+
+	if _, err := getVersions(server); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]string{
+		common.ClientVersionName:  "1.0.0",
+		common.ClientPlatformName: clientPlatform(),
+		common.ClientIdName:       loadClientId(cacheDir),
+		common.ClientChannelName:  "beta",
+	}
+
+	for name, value := range expected {
+		if actual := headers.Get(name); value != actual {
+			t.Errorf("Expected %s to be %s but found %s", name, value, actual)
+		}
+	}
+
+	// Without an ID, the client isn't counted but still checks for updates.
+	// Without a channel, the client follows the server's default channel.
+	server.ClientId = ""
+	server.ClientChannel = ""
+
+	if _, err := getVersions(server); err != nil || headers.Get(common.ClientIdName) != "" || headers.Get(common.ClientChannelName) != "" {
+		t.Errorf("Expected no %s or %s but found \"%s\" and \"%s\": %v", common.ClientIdName, common.ClientChannelName,
+			headers.Get(common.ClientIdName), headers.Get(common.ClientChannelName), err)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// Subcommand for managing the download cache.
+const CACHE_COMMAND string = "cache"
+
+// Subcommand for manually checking for and applying updates.
+const UPDATE_COMMAND string = "update"
+
+// Subcommand for inspecting settings.
+const CONFIG_COMMAND string = "config"
+
+var COMMANDS = []string{CACHE_COMMAND, UPDATE_COMMAND, CONFIG_COMMAND}
+
+// Exit code for invalid CLI usage.
+const EXIT_USAGE = 64
+
+// Exit code from `pokemon update check` when an update is available.
+const EXIT_UPDATE_AVAILABLE = 10
+
+// Settings used by subcommands.
+type CommandSettings struct {
+	Exe              string
+	ExePermissions   fs.FileMode
+	CacheDir         string
+	CachePolicy      CachePolicy
+	InstalledVersion string
+	UpdateUrl        string
+	// The release channel sent with update checks
+	Channel string
+	// True if updates replace the installed executable.
+	SelfReplace bool
+	// True if results should be printed as JSON rather than text.
+	Json bool
+	// Effective settings and their sources.
+	Config RawConfig
+}
+
+// Result of `pokemon update check`.
+type UpdateCheck struct {
+	Current         string `json:"current"`
+	Latest          string `json:"latest"`
+	UpdateAvailable bool   `json:"updateAvailable"`
+}
+
+// Result of `pokemon update apply` and `pokemon update rollback`.
+type UpdateResult struct {
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+// A version available from the server listed by `pokemon update list`.
+type ListedVersion struct {
+	Version   string `json:"version"`
+	Installed bool   `json:"installed"`
+	Current   bool   `json:"current"`
+}
+
+type ListedVersions struct {
+	All []ListedVersion `json:"versions"`
+}
+
+// Result of `pokemon cache prune`.
+type PruneResult struct {
+	Removed []string `json:"removed"`
+}
+
+// Prints the result as JSON if requested or as text otherwise.
+func printResult(settings CommandSettings, result any, text string) {
+
+	if !settings.Json {
+		fmt.Print(text)
+		return
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write JSON:\n%v\n", err)
+	}
+}
+
+// Runs a subcommand such as `pokemon update check` and returns the exit code.
+func runCommand(command []string, settings CommandSettings) int {
+
+	subcommand := strings.Join(command, " ")
+
+	switch {
+	case subcommand == CACHE_COMMAND+" prune":
+		return pruneCommand(settings)
+	case subcommand == UPDATE_COMMAND+" check":
+		return checkCommand(settings)
+	case subcommand == UPDATE_COMMAND+" apply":
+		return applyCommand(settings, "")
+	case len(command) == 3 && command[0] == UPDATE_COMMAND && command[1] == "apply":
+		return applyCommand(settings, command[2])
+	case subcommand == UPDATE_COMMAND+" rollback":
+		return rollbackCommand(settings)
+	case subcommand == UPDATE_COMMAND+" list":
+		return listCommand(settings)
+	case subcommand == CONFIG_COMMAND+" show":
+		return configShowCommand(settings)
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid command: \"%s\"\n", subcommand)
+		return EXIT_USAGE
+	}
+}
+
+func pruneCommand(settings CommandSettings) int {
+
+	removed, err := pruneCache(settings.CacheDir, settings.CachePolicy, settings.InstalledVersion)
+
+	var text strings.Builder
+
+	for _, path := range removed {
+		fmt.Fprintf(&text, "Removed \"%s\".\n", path)
+	}
+
+	printResult(settings, PruneResult{Removed: removed}, text.String())
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to prune \"%s\":\n%v\n", settings.CacheDir, err)
+		return 1
+	}
+
+	return 0
+}
+
+func checkCommand(settings CommandSettings) int {
+
+	current := currentVersion(settings.CacheDir, settings.InstalledVersion, settings.SelfReplace)
+	latest, err := getLatestVersion(newUpdateServer(settings.UpdateUrl, settings.Channel, settings.CacheDir, current))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to determine the latest version:\n%v\n", err)
+		return 1
+	}
+
+	check := UpdateCheck{
+		Current: current,
+		Latest:  latest,
+	}
+
+	// Versions newer than the latest such as locally built versions aren't
+	// downgraded.
+	currentSemVer, currentErr := common.ParseSemVer(check.Current)
+	latestSemVer, latestErr := common.ParseSemVer(check.Latest)
+	check.UpdateAvailable = currentErr == nil && latestErr == nil && currentSemVer.Less(latestSemVer)
+
+	if !check.UpdateAvailable {
+		printResult(settings, check, fmt.Sprintf("%s is the latest version.\n", check.Current))
+		return 0
+	}
+
+	printResult(settings, check, fmt.Sprintf("Current version: %s\nLatest version: %s\n", check.Current, check.Latest))
+	return EXIT_UPDATE_AVAILABLE
+}
+
+// Downloads the version (or the latest version if empty) and makes it the
+// current version. Automatic updates are pinned to a version which is
+// specified and unpinned otherwise.
+func applyCommand(settings CommandSettings, version string) int {
+
+	pinned := version != ""
+
+	current := currentVersion(settings.CacheDir, settings.InstalledVersion, settings.SelfReplace)
+	versions, err := getVersions(newUpdateServer(settings.UpdateUrl, settings.Channel, settings.CacheDir, current))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to determine available versions:\n%v\n", err)
+		return 1
+	}
+
+	if version == "" && len(versions) > 0 {
+		version = versions[len(versions)-1]
+	}
+
+	if !slices.Contains(versions, version) {
+		fmt.Fprintf(os.Stderr, "Version \"%s\" is not available. Available versions: %v\n", version, versions)
+		return 1
+	}
+
+	result := UpdateResult{Previous: current, Current: version}
+
+	if version == current {
+
+		// Only pin or unpin the current version.
+		state, err := readCacheState(settings.CacheDir)
+
+		if err == nil && state.Pinned != pinned {
+			if err = os.MkdirAll(settings.CacheDir, 0o755); err == nil {
+				err = writeCacheState(settings.CacheDir, CacheState{Current: current, Previous: state.Previous, Pinned: pinned})
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to update cache state in \"%s\":\n%v\n", settings.CacheDir, err)
+			return 1
+		}
+
+		printResult(settings, result, fmt.Sprintf("%s is already the current version.\n", version))
+		return 0
+	}
+
+	if err = os.MkdirAll(settings.CacheDir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create cache dir \"%s\":\n%v\n", settings.CacheDir, err)
+		return 1
+	}
+
+	updateFilePath, err := downloadUpdateVersion(settings.CacheDir, settings.UpdateUrl, version, settings.ExePermissions)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to download version %s:\n%v\n", version, err)
+		return 1
+	}
+
+	if settings.SelfReplace {
+		if err = replaceExecutable(settings.Exe, updateFilePath, settings.ExePermissions); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to replace \"%s\":\n%v\n", settings.Exe, err)
+			return 1
+		}
+	}
+
+	updateCache(settings.CacheDir, settings.CachePolicy, Cmd{Version: version}, Cmd{Version: current}, pinned)
+	printResult(settings, result, fmt.Sprintf("Successfully updated from %s to %s.\n", current, version))
+	return 0
+}
+
+// Makes the previous version the current version.
+func rollbackCommand(settings CommandSettings) int {
+
+	state, err := readCacheState(settings.CacheDir)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read cache state from \"%s\":\n%v\n", settings.CacheDir, err)
+		return 1
+	}
+
+	if state.Previous == "" {
+		fmt.Fprintf(os.Stderr, "No previous version to roll back to.\n")
+		return 1
+	}
+
+	current := currentVersion(settings.CacheDir, settings.InstalledVersion, settings.SelfReplace)
+
+	if settings.SelfReplace {
+		err = restoreExecutable(settings.Exe)
+	} else if state.Previous != settings.InstalledVersion {
+		_, err = os.Stat(cachedVersionPath(settings.CacheDir, state.Previous))
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to roll back to %s:\n%v\n", state.Previous, err)
+		return 1
+	}
+
+	previous := current
+
+	// Restoring used up the only backup of the executable, so there's nothing
+	// left to roll back to.
+	if settings.SelfReplace {
+		previous = ""
+	}
+
+	if err = writeCacheState(settings.CacheDir, CacheState{Current: state.Previous, Previous: previous, Pinned: true}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update cache state in \"%s\":\n%v\n", settings.CacheDir, err)
+		return 1
+	}
+
+	result := UpdateResult{Previous: current, Current: state.Previous}
+	printResult(settings, result, fmt.Sprintf("Successfully rolled back from %s to %s.\n", current, state.Previous))
+	return 0
+}
+
+// Lists the versions available from the server marking the installed and
+// current versions.
+func listCommand(settings CommandSettings) int {
+
+	current := currentVersion(settings.CacheDir, settings.InstalledVersion, settings.SelfReplace)
+	versions, err := getVersions(newUpdateServer(settings.UpdateUrl, settings.Channel, settings.CacheDir, current))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to determine available versions:\n%v\n", err)
+		return 1
+	}
+
+	installed := []string{settings.InstalledVersion}
+
+	// The cache dir may not exist if nothing has been downloaded yet.
+	if cachedVersions, err := listCachedVersions(settings.CacheDir); err == nil {
+		for _, cachedVersion := range cachedVersions {
+			installed = append(installed, cachedVersion.Version.String)
+		}
+	}
+
+	listed := ListedVersions{All: make([]ListedVersion, 0, len(versions))}
+
+	var text strings.Builder
+
+	for _, version := range versions {
+		listedVersion := ListedVersion{
+			Version:   version,
+			Installed: slices.Contains(installed, version),
+			Current:   version == current,
+		}
+		listed.All = append(listed.All, listedVersion)
+
+		marker := " "
+
+		if listedVersion.Current {
+			marker = "*"
+		}
+
+		fmt.Fprintf(&text, "%s %s", marker, version)
+
+		if listedVersion.Installed {
+			text.WriteString(" (installed)")
+		}
+
+		text.WriteString("\n")
+	}
+
+	printResult(settings, listed, text.String())
+	return 0
+}
+
+// Prints each effective setting and where it came from.
+func configShowCommand(settings CommandSettings) int {
+
+	var text strings.Builder
+
+	for _, key := range CONFIG_KEYS {
+		value := settings.Config[key]
+		fmt.Fprintf(&text, "%s = %s (%s)\n", key, value.Value, value.Source)
+	}
+
+	printResult(settings, settings.Config, text.String())
+	return 0
+}
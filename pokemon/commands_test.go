@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCommandSettings(t *testing.T, versions map[string]string) CommandSettings {
+
+	dir := t.TempDir()
+
+	return CommandSettings{
+		Exe:              filepath.Join(dir, POKEMON),
+		ExePermissions:   0o755,
+		CacheDir:         filepath.Join(dir, "cache"),
+		CachePolicy:      CachePolicy{Keep: 3},
+		InstalledVersion: "1.0.0",
+		UpdateUrl:        newFakeUpdateServer(t, versions).URL,
+		Json:             true,
+	}
+}
+
+func TestUpdateCommands(t *testing.T) {
+
+	settings := newTestCommandSettings(t, map[string]string{
+		"1.0.0": "1.0.0",
+		"2.0.0": "2.0.0",
+		"3.0.0": "3.0.0",
+	})
+
+	type TestCase struct {
+		command          []string
+		expectedExitCode int
+		expectedCurrent  string
+		expectedPinned   string
+	}
+
+	for _, testCase := range []TestCase{
+		{command: []string{UPDATE_COMMAND, "check"}, expectedExitCode: EXIT_UPDATE_AVAILABLE, expectedCurrent: "1.0.0"},
+		{command: []string{UPDATE_COMMAND, "rollback"}, expectedExitCode: 1, expectedCurrent: "1.0.0"},
+		{command: []string{UPDATE_COMMAND, "apply", "2.0.0"}, expectedExitCode: 0, expectedCurrent: "2.0.0", expectedPinned: "2.0.0"},
+		{command: []string{UPDATE_COMMAND, "apply", "9.0.0"}, expectedExitCode: 1, expectedCurrent: "2.0.0", expectedPinned: "2.0.0"},
+		{command: []string{UPDATE_COMMAND, "apply"}, expectedExitCode: 0, expectedCurrent: "3.0.0"},
+		{command: []string{UPDATE_COMMAND, "check"}, expectedExitCode: 0, expectedCurrent: "3.0.0"},
+		{command: []string{UPDATE_COMMAND, "list"}, expectedExitCode: 0, expectedCurrent: "3.0.0"},
+		{command: []string{UPDATE_COMMAND, "rollback"}, expectedExitCode: 0, expectedCurrent: "2.0.0", expectedPinned: "2.0.0"},
+		{command: []string{UPDATE_COMMAND, "rollback"}, expectedExitCode: 0, expectedCurrent: "3.0.0", expectedPinned: "3.0.0"},
+		{command: []string{UPDATE_COMMAND, "apply"}, expectedExitCode: 0, expectedCurrent: "3.0.0"},
+		{command: []string{UPDATE_COMMAND, "apply", "3.0.0"}, expectedExitCode: 0, expectedCurrent: "3.0.0", expectedPinned: "3.0.0"},
+		{command: []string{CACHE_COMMAND, "prune"}, expectedExitCode: 0, expectedCurrent: "3.0.0", expectedPinned: "3.0.0"},
+		{command: []string{UPDATE_COMMAND, "bogus"}, expectedExitCode: EXIT_USAGE, expectedCurrent: "3.0.0", expectedPinned: "3.0.0"},
+	} {
+		if exitCode := runCommand(testCase.command, settings); exitCode != testCase.expectedExitCode {
+			t.Errorf("Expected %v to exit with %d but found %d", testCase.command, testCase.expectedExitCode, exitCode)
+		}
+
+		if current := currentVersion(settings.CacheDir, settings.InstalledVersion, false); current != testCase.expectedCurrent {
+			t.Errorf("Expected current version %s after %v but found %s", testCase.expectedCurrent, testCase.command, current)
+		}
+
+		if pinned := pinnedVersion(settings.CacheDir); pinned != testCase.expectedPinned {
+			t.Errorf("Expected pinned version \"%s\" after %v but found \"%s\"", testCase.expectedPinned, testCase.command, pinned)
+		}
+	}
+}
+
+func TestBackgroundUpdateHonorsPinnedVersion(t *testing.T) {
+
+	settings := newTestCommandSettings(t, map[string]string{
+		"1.0.0": "1.0.0",
+		"2.0.0": "2.0.0",
+		"3.0.0": "3.0.0",
+	})
+
+	if exitCode := runCommand([]string{UPDATE_COMMAND, "apply", "2.0.0"}, settings); exitCode != 0 {
+		t.Fatalf("Expected apply to succeed but found exit code %d", exitCode)
+	}
+
+	if err := backgroundUpdate(settings.Exe, settings.CacheDir, settings.CachePolicy, settings.ExePermissions, settings.InstalledVersion, settings.UpdateUrl, settings.Channel, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if current := currentVersion(settings.CacheDir, settings.InstalledVersion, false); current != "2.0.0" {
+		t.Errorf("Expected the pinned version 2.0.0 but found %s", current)
+	}
+
+	// Unpinning resumes automatic updates.
+	state, _ := readCacheState(settings.CacheDir)
+	state.Pinned = false
+
+	if err := writeCacheState(settings.CacheDir, state); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := backgroundUpdate(settings.Exe, settings.CacheDir, settings.CachePolicy, settings.ExePermissions, settings.InstalledVersion, settings.UpdateUrl, settings.Channel, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if current := currentVersion(settings.CacheDir, settings.InstalledVersion, false); current != "3.0.0" {
+		t.Errorf("Expected the latest version 3.0.0 but found %s", current)
+	}
+}
+
+func TestCheckCommandIgnoresOlderVersions(t *testing.T) {
+
+	settings := newTestCommandSettings(t, map[string]string{
+		"1.0.0": "1.0.0",
+		"2.0.0": "2.0.0",
+	})
+	settings.InstalledVersion = "10.0.0"
+
+	if exitCode := runCommand([]string{UPDATE_COMMAND, "check"}, settings); exitCode != 0 {
+		t.Errorf("Expected no update from 10.0.0 to 2.0.0 but found exit code %d", exitCode)
+	}
+}
+
+func TestUpdateCommandsReplaceExecutable(t *testing.T) {
+
+	settings := newTestCommandSettings(t, map[string]string{
+		"1.0.0": "1.0.0",
+		"2.0.0": "2.0.0",
+	})
+	settings.SelfReplace = true
+
+	if err := os.WriteFile(settings.Exe, []byte("1.0.0"), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if exitCode := runCommand([]string{UPDATE_COMMAND, "apply"}, settings); exitCode != 0 {
+		t.Fatalf("Expected apply to succeed but found exit code %d", exitCode)
+	}
+
+	assertFileContent(t, settings.Exe, "2.0.0")
+
+	if exitCode := runCommand([]string{UPDATE_COMMAND, "rollback"}, settings); exitCode != 0 {
+		t.Fatalf("Expected rollback to succeed but found exit code %d", exitCode)
+	}
+
+	assertFileContent(t, settings.Exe, "1.0.0")
+
+	// The backup was restored, so there's no previous version left.
+	if state, err := readCacheState(settings.CacheDir); err != nil || state.Previous != "" {
+		t.Errorf("Expected no previous version but found %+v: %v", state, err)
+	}
+
+	if exitCode := runCommand([]string{UPDATE_COMMAND, "rollback"}, settings); exitCode != 1 {
+		t.Errorf("Expected a second rollback to fail but found exit code %d", exitCode)
+	}
+
+	assertFileContent(t, settings.Exe, "1.0.0")
+}
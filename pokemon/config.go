@@ -0,0 +1,383 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// Default settings embedded in the executable.
+//
+//go:embed defaults.properties
+var defaultProperties string
+
+// The name of the system and user config files.
+const CONFIG_FILE = "config.properties"
+
+// The prefix of env variables which override settings. For example,
+// POKEMON_UPDATE_CHECK_INTERVAL overrides update-check-interval.
+const CONFIG_ENV_PREFIX = "POKEMON_"
+
+// Sources of settings other than files, env variables, and flags.
+const CONFIG_SOURCE_DEFAULT = "default"
+const CONFIG_SOURCE_BUILD = "build"
+
+// Setting keys used in config files. Env variables and flags use the same
+// names.
+const (
+	CONFIG_UPDATE_URL            = "update-url"
+	CONFIG_CHANNEL               = "channel"
+	CONFIG_AVAILABLE_POKEMON     = "available-pokemon"
+	CONFIG_DAEMON_INTERVAL       = "daemon-interval"
+	CONFIG_UPDATE_CHECK_INTERVAL = "update-check-interval"
+	CONFIG_CACHE_DIR             = "cache-dir"
+	CONFIG_CACHE_KEEP            = "cache-keep"
+	CONFIG_CACHE_MAX_SIZE        = "cache-max-size"
+	CONFIG_SELF_REPLACE          = "self-replace"
+	CONFIG_BACKGROUND_UPDATE     = "background-update"
+	CONFIG_OUTPUT                = "output"
+	CONFIG_LOG_LEVEL             = "log-level"
+	CONFIG_LOG_FILE              = "log-file"
+	CONFIG_GREETING_TEMPLATE     = "greeting-template"
+	CONFIG_GREETING_MODE         = "greeting-mode"
+	CONFIG_POKEMON_TYPES         = "pokemon-types"
+	CONFIG_POKEMON_GENERATIONS   = "pokemon-generations"
+	CONFIG_LANG                  = "lang"
+)
+
+// Every setting key in the order `pokemon config show` prints them.
+var CONFIG_KEYS = []string{
+	CONFIG_UPDATE_URL,
+	CONFIG_CHANNEL,
+	CONFIG_AVAILABLE_POKEMON,
+	CONFIG_DAEMON_INTERVAL,
+	CONFIG_UPDATE_CHECK_INTERVAL,
+	CONFIG_CACHE_DIR,
+	CONFIG_CACHE_KEEP,
+	CONFIG_CACHE_MAX_SIZE,
+	CONFIG_SELF_REPLACE,
+	CONFIG_BACKGROUND_UPDATE,
+	CONFIG_OUTPUT,
+	CONFIG_LOG_LEVEL,
+	CONFIG_LOG_FILE,
+	CONFIG_GREETING_TEMPLATE,
+	CONFIG_GREETING_MODE,
+	CONFIG_POKEMON_TYPES,
+	CONFIG_POKEMON_GENERATIONS,
+	CONFIG_LANG,
+}
+
+// A setting's effective value and where it came from: "default", "build", a
+// config file path, an env variable, or a flag.
+type ConfigValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Raw settings keyed by setting key.
+type RawConfig map[string]ConfigValue
+
+// Validated settings.
+type Config struct {
+	UpdateUrl string
+	// The release channel sent with update checks or empty for the server's
+	// default channel
+	Channel string
+	// The Pokemon allowed to greet or empty for every Pokemon in this version
+	AvailablePokemon        []string
+	DaemonIntervalSecs      uint64
+	UpdateCheckIntervalSecs uint64
+	CacheDir                string
+	CachePolicy             CachePolicy
+	SelfReplace             bool
+	BackgroundUpdate        bool
+	Json                    bool
+	LogLevel                slog.Level
+	LogFile                 string
+	GreetingTemplate        *template.Template
+	GreetingMode            string
+	PokemonFilter           PokemonFilter
+}
+
+// Channels the server accepts in the Client-Channel header.
+var channelPattern = regexp.MustCompile(`^[0-9a-z-]{0,32}$`)
+
+// Gets the env variable which overrides the setting.
+func configEnvName(key string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// Gets the system config file path which is /etc/pokemon/config.properties
+// or %ProgramData%\pokemon\config.properties on Windows.
+func systemConfigPath() string {
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), POKEMON, CONFIG_FILE)
+	}
+
+	return filepath.Join("/etc", POKEMON, CONFIG_FILE)
+}
+
+// Gets the user config file path which is
+// $XDG_CONFIG_HOME/pokemon/config.properties on Linux or the platform
+// equivalent. Returns an empty string if the platform has no config dir.
+func userConfigPath() string {
+
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(configDir, POKEMON, CONFIG_FILE)
+}
+
+// Reads `key = value` lines and sets each one. Blank lines and lines starting
+// with # are ignored.
+func readProperties(content string, source string, set func(key string, value string) error) error {
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+
+		if !found {
+			return fmt.Errorf("expected \"key = value\" at %s:%d", source, i+1)
+		}
+
+		if err := set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("%v at %s:%d", err, source, i+1)
+		}
+	}
+
+	return nil
+}
+
+// Parses `key = value` lines into the config.
+func parseProperties(content string, source string, config RawConfig) error {
+
+	return readProperties(content, source, func(key string, value string) error {
+
+		if _, ok := config[key]; !ok && source != CONFIG_SOURCE_DEFAULT {
+			return fmt.Errorf("unknown setting \"%s\"", key)
+		}
+
+		config[key] = ConfigValue{Value: value, Source: source}
+		return nil
+	})
+}
+
+// Loads the embedded defaults, then the build's values, then each config file
+// that exists, and then env variables with each layer overriding the previous
+// ones. Flags are applied by the caller.
+func loadConfig(build map[string]string, getenv func(string) string, paths ...string) (RawConfig, error) {
+
+	config := RawConfig{}
+
+	if err := parseProperties(defaultProperties, CONFIG_SOURCE_DEFAULT, config); err != nil {
+		return config, err
+	}
+
+	for key, value := range build {
+		if value != "" {
+			config[key] = ConfigValue{Value: value, Source: CONFIG_SOURCE_BUILD}
+		}
+	}
+
+	for _, path := range paths {
+
+		if path == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+
+		if err != nil && os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return config, err
+		}
+
+		if err = parseProperties(string(content), path, config); err != nil {
+			return config, err
+		}
+	}
+
+	for _, key := range CONFIG_KEYS {
+		name := configEnvName(key)
+
+		if value := getenv(name); value != "" {
+			config[key] = ConfigValue{Value: value, Source: name}
+		}
+	}
+
+	return config, nil
+}
+
+// Splits a comma-separated list into lowercase names.
+func splitNames(list string) []string {
+
+	names := make([]string, 0)
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Validates the settings. Errors name the source of the invalid value.
+func parseConfig(raw RawConfig) (Config, error) {
+
+	var config Config
+	var err error
+
+	invalid := func(key string, err error) error {
+		value := raw[key]
+		return fmt.Errorf("invalid %s \"%s\" from %s:\n%v", key, value.Value, value.Source, err)
+	}
+
+	updateUrl := raw[CONFIG_UPDATE_URL]
+	config.UpdateUrl = updateUrl.Value
+
+	if config.UpdateUrl == "" {
+		return config, invalid(CONFIG_UPDATE_URL, fmt.Errorf("must be set by the build, a config file, %s, or --%s", configEnvName(CONFIG_UPDATE_URL), CONFIG_UPDATE_URL))
+	}
+
+	// Require https for user supplied URLs.
+	// Allow non-https for the URL from the build for the sake of testing.
+	if updateUrl.Source != CONFIG_SOURCE_BUILD && !strings.HasPrefix(config.UpdateUrl, "https:") {
+		return config, invalid(CONFIG_UPDATE_URL, fmt.Errorf("must use https"))
+	}
+
+	config.Channel = raw[CONFIG_CHANNEL].Value
+
+	if !channelPattern.MatchString(config.Channel) {
+		return config, invalid(CONFIG_CHANNEL, fmt.Errorf("must be at most 32 lowercase letters, digits, or dashes"))
+	}
+
+	config.AvailablePokemon = splitNames(raw[CONFIG_AVAILABLE_POKEMON].Value)
+
+	positive := func(key string) (uint64, error) {
+
+		value, err := strconv.ParseUint(raw[key].Value, 10, 16)
+
+		if err == nil && value == 0 {
+			err = fmt.Errorf("must be positive")
+		}
+
+		if err != nil {
+			return 0, invalid(key, err)
+		}
+
+		return value, nil
+	}
+
+	if config.DaemonIntervalSecs, err = positive(CONFIG_DAEMON_INTERVAL); err != nil {
+		return config, err
+	}
+
+	if config.UpdateCheckIntervalSecs, err = positive(CONFIG_UPDATE_CHECK_INTERVAL); err != nil {
+		return config, err
+	}
+
+	if cacheDir := raw[CONFIG_CACHE_DIR].Value; cacheDir != "" {
+		if config.CacheDir, err = filepath.Abs(cacheDir); err != nil {
+			return config, invalid(CONFIG_CACHE_DIR, err)
+		}
+	}
+
+	keep, err := strconv.ParseUint(raw[CONFIG_CACHE_KEEP].Value, 10, 32)
+
+	if err != nil {
+		return config, invalid(CONFIG_CACHE_KEEP, err)
+	}
+
+	maxSize, err := strconv.ParseUint(raw[CONFIG_CACHE_MAX_SIZE].Value, 10, 32)
+
+	if err != nil {
+		return config, invalid(CONFIG_CACHE_MAX_SIZE, err)
+	}
+
+	config.CachePolicy = CachePolicy{Keep: int(keep), MaxSize: int64(maxSize) * MB}
+
+	if config.SelfReplace, err = strconv.ParseBool(raw[CONFIG_SELF_REPLACE].Value); err != nil {
+		return config, invalid(CONFIG_SELF_REPLACE, err)
+	}
+
+	if config.BackgroundUpdate, err = strconv.ParseBool(raw[CONFIG_BACKGROUND_UPDATE].Value); err != nil {
+		return config, invalid(CONFIG_BACKGROUND_UPDATE, err)
+	}
+
+	switch raw[CONFIG_OUTPUT].Value {
+	case OUTPUT_TEXT:
+		config.Json = false
+	case OUTPUT_JSON:
+		config.Json = true
+	default:
+		return config, invalid(CONFIG_OUTPUT, fmt.Errorf("must be %s or %s", OUTPUT_TEXT, OUTPUT_JSON))
+	}
+
+	if config.LogLevel, err = common.ToSlogLevel(raw[CONFIG_LOG_LEVEL].Value); err != nil {
+		return config, invalid(CONFIG_LOG_LEVEL, err)
+	}
+
+	if logFile := raw[CONFIG_LOG_FILE].Value; logFile != "" {
+		if config.LogFile, err = filepath.Abs(logFile); err != nil {
+			return config, invalid(CONFIG_LOG_FILE, err)
+		}
+	}
+
+	if config.GreetingTemplate, err = parseGreetingTemplate(raw[CONFIG_GREETING_TEMPLATE].Value); err != nil {
+		return config, invalid(CONFIG_GREETING_TEMPLATE, err)
+	}
+
+	config.GreetingMode = raw[CONFIG_GREETING_MODE].Value
+
+	if !slices.Contains(GREETING_MODES, config.GreetingMode) {
+		return config, invalid(CONFIG_GREETING_MODE, fmt.Errorf("must be one of %v", GREETING_MODES))
+	}
+
+	config.PokemonFilter.Types = splitNames(raw[CONFIG_POKEMON_TYPES].Value)
+
+	for _, generation := range splitNames(raw[CONFIG_POKEMON_GENERATIONS].Value) {
+
+		value, err := strconv.ParseUint(generation, 10, 16)
+
+		if err == nil && value == 0 {
+			err = fmt.Errorf("must be positive")
+		}
+
+		if err != nil {
+			return config, invalid(CONFIG_POKEMON_GENERATIONS, err)
+		}
+
+		config.PokemonFilter.Generations = append(config.PokemonFilter.Generations, int(value))
+	}
+
+	// The locale itself is chosen by main before flags are parsed so that
+	// usage is localized.
+	if lang := raw[CONFIG_LANG].Value; lang != "" && !slices.Contains(LOCALES, normalizeLocale(lang)) {
+		return config, invalid(CONFIG_LANG, fmt.Errorf("must be one of %v", LOCALES))
+	}
+
+	return config, nil
+}
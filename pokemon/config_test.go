@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+
+	path := filepath.Join(t.TempDir(), CONFIG_FILE)
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return path
+}
+
+func noEnv(name string) string {
+	return ""
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+
+	raw, err := loadConfig(nil, noEnv)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, key := range CONFIG_KEYS {
+		if value, ok := raw[key]; !ok || value.Source != CONFIG_SOURCE_DEFAULT {
+			t.Errorf("Expected a default for %s but found %v", key, value)
+		}
+	}
+
+	if len(raw) != len(CONFIG_KEYS) {
+		t.Errorf("Expected %d settings but found %d", len(CONFIG_KEYS), len(raw))
+	}
+
+	// There is no default update URL, so one must be configured.
+	if _, err = parseConfig(raw); err == nil || !strings.Contains(err.Error(), CONFIG_UPDATE_URL) {
+		t.Errorf("Expected an error for the missing %s but found %v", CONFIG_UPDATE_URL, err)
+	}
+
+	raw[CONFIG_UPDATE_URL] = ConfigValue{Value: "http://localhost:8080", Source: CONFIG_SOURCE_BUILD}
+
+	if _, err = parseConfig(raw); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+
+	systemConfig := writeConfigFile(t, "# System settings\ncache-keep = 5\nlog-level = WARN\noutput = json\navailable-pokemon = pikachu, Raichu\n")
+	userConfig := writeConfigFile(t, "log-level = DEBUG\nupdate-check-interval=30\n")
+	env := map[string]string{
+		"POKEMON_UPDATE_CHECK_INTERVAL": "45",
+	}
+
+	raw, err := loadConfig(map[string]string{
+		CONFIG_UPDATE_URL: "http://localhost:9090",
+	}, func(name string) string { return env[name] }, systemConfig, userConfig, filepath.Join(t.TempDir(), "missing"))
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]ConfigValue{
+		CONFIG_UPDATE_URL:            {Value: "http://localhost:9090", Source: CONFIG_SOURCE_BUILD},
+		CONFIG_AVAILABLE_POKEMON:     {Value: "pikachu, Raichu", Source: systemConfig},
+		CONFIG_DAEMON_INTERVAL:       {Value: "1", Source: CONFIG_SOURCE_DEFAULT},
+		CONFIG_CACHE_KEEP:            {Value: "5", Source: systemConfig},
+		CONFIG_OUTPUT:                {Value: "json", Source: systemConfig},
+		CONFIG_LOG_LEVEL:             {Value: "DEBUG", Source: userConfig},
+		CONFIG_UPDATE_CHECK_INTERVAL: {Value: "45", Source: "POKEMON_UPDATE_CHECK_INTERVAL"},
+	}
+
+	for key, value := range expected {
+		if raw[key] != value {
+			t.Errorf("Expected %s to be %v but found %v", key, value, raw[key])
+		}
+	}
+
+	config, err := parseConfig(raw)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !slices.Equal([]string{"pikachu", "raichu"}, config.AvailablePokemon) || config.CachePolicy.Keep != 5 ||
+		config.UpdateCheckIntervalSecs != 45 || !config.Json {
+		t.Errorf("Expected settings from every layer but found %+v", config)
+	}
+}
+
+func TestLoadConfigRejectsUnknownSettings(t *testing.T) {
+
+	path := writeConfigFile(t, "cache-keep = 5\ncache-size = 10\n")
+
+	if _, err := loadConfig(nil, noEnv, path); err == nil || !strings.Contains(err.Error(), path+":2") {
+		t.Errorf("Expected an error at %s:2 but found %v", path, err)
+	}
+}
+
+func TestParseConfigNamesSourceOfInvalidValues(t *testing.T) {
+
+	testCases := []struct {
+		key   string
+		value string
+	}{
+		{CONFIG_UPDATE_URL, "http://example.com"},
+		{CONFIG_UPDATE_URL, ""},
+		{CONFIG_CHANNEL, "Beta"},
+		{CONFIG_CHANNEL, "beta/1"},
+		{CONFIG_UPDATE_CHECK_INTERVAL, "0"},
+		{CONFIG_CACHE_KEEP, "-1"},
+		{CONFIG_SELF_REPLACE, "maybe"},
+		{CONFIG_OUTPUT, "xml"},
+		{CONFIG_LOG_LEVEL, "TRACE"},
+		{CONFIG_LANG, "es_ES.UTF-8"},
+	}
+
+	for _, testCase := range testCases {
+
+		raw, err := loadConfig(map[string]string{CONFIG_UPDATE_URL: "https://example.com"}, noEnv)
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		raw[testCase.key] = ConfigValue{Value: testCase.value, Source: "--" + testCase.key}
+
+		if _, err = parseConfig(raw); err == nil || !strings.Contains(err.Error(), "from --"+testCase.key) {
+			t.Errorf("Expected an error from --%s but found %v", testCase.key, err)
+		}
+	}
+}
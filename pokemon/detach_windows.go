@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const DETACHED_PROCESS = 0x00000008
+
+// Starts the command without a console so that it keeps running after this
+// process and its console exit.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | DETACHED_PROCESS}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// Greeting modes which choose the Pokemon for each greeting.
+const (
+	// Chooses a Pokemon at random.
+	GREETING_MODE_RANDOM = "random"
+	// Chooses a Pokemon at random favoring Pokemon with higher weights.
+	GREETING_MODE_WEIGHTED = "weighted"
+	// Walks the evolution chain of a Pokemon with each greeting.
+	GREETING_MODE_EVOLVE = "evolve"
+)
+
+var GREETING_MODES = []string{GREETING_MODE_RANDOM, GREETING_MODE_WEIGHTED, GREETING_MODE_EVOLVE}
+
+// The fields available to greeting templates, for example
+// `{{.Name}} says, "{{.Greeting}}".`
+type GreetingData struct {
+	// The localized name
+	Name       string
+	Greeting   string
+	Types      []string
+	Generation int
+	Evolution  []string
+	// The number of greetings before this one
+	Count int
+}
+
+// Limits the Pokemon chosen at random. Empty fields match every Pokemon.
+type PokemonFilter struct {
+	// Matches Pokemon with any of the types
+	Types       []string
+	Generations []int
+}
+
+func (f PokemonFilter) matches(pokemon Pokemon) bool {
+
+	if len(f.Types) > 0 && !slices.ContainsFunc(pokemon.Types, func(pokemonType string) bool {
+		return slices.Contains(f.Types, pokemonType)
+	}) {
+		return false
+	}
+
+	return len(f.Generations) == 0 || slices.Contains(f.Generations, pokemon.Generation)
+}
+
+// Chooses Pokemon and formats their greetings.
+type Greeter struct {
+	Mode     string
+	Template *template.Template
+	// The Pokemon to choose from
+	Candidates []Pokemon
+	// The Pokemon to greet from or nil to choose at random
+	Chosen *Pokemon
+	// Returns a random int in [0, n).
+	Intn func(n int) int
+	// Every available Pokemon used to walk evolution chains
+	available []Pokemon
+	previous  *Pokemon
+	count     int
+}
+
+// Creates a greeter which greets with the named Pokemon or with Pokemon chosen
+// at random from the available Pokemon matching the filter.
+func newGreeter(available []Pokemon, name string, mode string, filter PokemonFilter, greetingTemplate *template.Template) (*Greeter, error) {
+
+	greeter := &Greeter{
+		Mode:      mode,
+		Template:  greetingTemplate,
+		Intn:      rand.Intn,
+		available: available,
+	}
+
+	if name != "" {
+
+		pokemon, ok := findPokemon(available, name)
+
+		if !ok {
+			return nil, errors.New(messages.Get(MSG_GREETING_UNSUPPORTED_POKEMON, common.Capitalize(name)))
+		}
+
+		greeter.Chosen = &pokemon
+		return greeter, nil
+	}
+
+	for _, pokemon := range available {
+		if filter.matches(pokemon) {
+			greeter.Candidates = append(greeter.Candidates, pokemon)
+		}
+	}
+
+	if len(greeter.Candidates) == 0 {
+		return nil, errors.New(messages.Get(MSG_GREETING_NO_MATCH, filter.Types, filter.Generations))
+	}
+
+	return greeter, nil
+}
+
+func (g *Greeter) random() Pokemon {
+
+	if g.Mode != GREETING_MODE_WEIGHTED {
+		return g.Candidates[g.Intn(len(g.Candidates))]
+	}
+
+	total := 0
+
+	for _, pokemon := range g.Candidates {
+		total += pokemon.Weight
+	}
+
+	choice := g.Intn(total)
+
+	for _, pokemon := range g.Candidates {
+
+		if choice < pokemon.Weight {
+			return pokemon
+		}
+
+		choice -= pokemon.Weight
+	}
+
+	return g.Candidates[len(g.Candidates)-1]
+}
+
+// Gets the next available Pokemon in the evolution chain or false if the
+// Pokemon is fully evolved.
+func (g *Greeter) evolve(pokemon Pokemon) (Pokemon, bool) {
+
+	i := slices.Index(pokemon.Evolution, pokemon.Name)
+
+	for _, name := range pokemon.Evolution[i+1:] {
+		if next, ok := findPokemon(g.available, name); ok {
+			return next, true
+		}
+	}
+
+	return Pokemon{}, false
+}
+
+// Chooses the Pokemon for the next greeting. In evolve mode, each greeting
+// evolves the previous Pokemon. Once fully evolved, the chosen Pokemon or a new
+// random Pokemon starts over.
+func (g *Greeter) Next() Pokemon {
+
+	var next Pokemon
+
+	if g.Mode == GREETING_MODE_EVOLVE && g.previous != nil {
+
+		var evolved bool
+
+		if next, evolved = g.evolve(*g.previous); !evolved {
+			g.previous = nil
+		}
+	}
+
+	if g.previous == nil || g.Mode != GREETING_MODE_EVOLVE {
+		if g.Chosen != nil {
+			next = *g.Chosen
+		} else {
+			next = g.random()
+		}
+	}
+
+	g.previous = &next
+	return next
+}
+
+// Formats the Pokemon's greeting with the template.
+func (g *Greeter) Greet(pokemon Pokemon) (string, error) {
+
+	var greeting strings.Builder
+
+	err := g.Template.Execute(&greeting, GreetingData{
+		Name:       messages.PokemonName(pokemon.Name),
+		Greeting:   pokemon.Greeting,
+		Types:      pokemon.Types,
+		Generation: pokemon.Generation,
+		Evolution:  pokemon.Evolution,
+		Count:      g.count,
+	})
+
+	g.count += 1
+	return greeting.String(), err
+}
+
+// Parses a greeting template and checks that it only uses GreetingData
+// fields by formatting a sample greeting.
+func parseGreetingTemplate(text string) (*template.Template, error) {
+
+	greetingTemplate, err := template.New("greeting").Parse(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sample := GreetingData{
+		Name:       "Pikachu",
+		Greeting:   "Pika pika!",
+		Types:      []string{"electric"},
+		Generation: 1,
+		Evolution:  []string{"pikachu", "raichu"},
+	}
+
+	if err = greetingTemplate.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, err
+	}
+
+	return greetingTemplate, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestGreeter(t *testing.T, version string, name string, mode string, filter PokemonFilter) *Greeter {
+
+	pokedex, err := parsePokedex(pokedexJson)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	available, err := pokemonForVersion(pokedex, version, nil)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	greetingTemplate, err := parseGreetingTemplate(messages.Get(MSG_GREETING_TEMPLATE))
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	greeter, err := newGreeter(available, name, mode, filter, greetingTemplate)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return greeter
+}
+
+// Gets the names of the next count Pokemon chosen by the greeter.
+func nextNames(greeter *Greeter, count int) string {
+
+	names := make([]string, 0, count)
+
+	for range count {
+		names = append(names, greeter.Next().Name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestGreetWithTemplates(t *testing.T) {
+
+	greeter := newTestGreeter(t, "1.0.0", "charmander", GREETING_MODE_RANDOM, PokemonFilter{})
+
+	if greeting, err := greeter.Greet(greeter.Next()); err != nil || greeting != `Charmander says, "Char char!".` {
+		t.Errorf("Expected the default greeting but found %s: %v", greeting, err)
+	}
+
+	greetingTemplate, err := parseGreetingTemplate(`{{.Count}}: {{.Name}} ({{join .Types "/"}}) evolves into {{index .Evolution 1}}`)
+
+	if err == nil {
+		t.Errorf("Expected an error for an undefined function but found %v", greetingTemplate)
+	}
+
+	greeter.Template, err = parseGreetingTemplate(`{{.Count}}: {{.Name}} is a generation {{.Generation}} {{index .Types 0}} Pokemon`)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := "1: Charmander is a generation 1 fire Pokemon"
+
+	if greeting, err := greeter.Greet(greeter.Next()); err != nil || expected != greeting {
+		t.Errorf("Expected %s but found %s: %v", expected, greeting, err)
+	}
+
+	if _, err = parseGreetingTemplate(`{{.Nickname}} says hi`); err == nil {
+		t.Errorf("Expected an error for an unknown field")
+	}
+}
+
+func TestGreeterFiltersRandomPokemon(t *testing.T) {
+
+	greeter := newTestGreeter(t, "3.0.0", "", GREETING_MODE_RANDOM, PokemonFilter{Types: []string{"water", "flying"}})
+
+	if expected, actual := "charizard,squirtle,wartortle,blastoise", pokemonNames(greeter.Candidates); expected != actual {
+		t.Errorf("Expected %s but found %s", expected, actual)
+	}
+
+	pokedex, _ := parsePokedex(pokedexJson)
+
+	if _, err := newGreeter(pokedex, "", GREETING_MODE_RANDOM, PokemonFilter{Generations: []int{2}}, nil); err == nil {
+		t.Errorf("Expected an error since no Pokemon are from generation 2")
+	}
+}
+
+func TestGreeterWeightedMode(t *testing.T) {
+
+	// Pikachu has weight 6 and Raichu has weight 2.
+	greeter := newTestGreeter(t, "2.0.0", "", GREETING_MODE_WEIGHTED, PokemonFilter{Types: []string{"electric"}})
+	choices := []int{0, 5, 6, 7}
+	greeter.Intn = func(n int) int {
+
+		if n != 8 {
+			t.Errorf("Expected a total weight of 8 but found %d", n)
+		}
+
+		choice := choices[0]
+		choices = choices[1:]
+		return choice
+	}
+
+	if expected, actual := "pikachu,pikachu,raichu,raichu", nextNames(greeter, 4); expected != actual {
+		t.Errorf("Expected %s but found %s", expected, actual)
+	}
+}
+
+func TestGreeterEvolveMode(t *testing.T) {
+
+	greeter := newTestGreeter(t, "3.0.0", "charmander", GREETING_MODE_EVOLVE, PokemonFilter{})
+
+	if expected, actual := "charmander,charmeleon,charizard,charmander", nextNames(greeter, 4); expected != actual {
+		t.Errorf("Expected %s but found %s", expected, actual)
+	}
+
+	// Charizard isn't available in 2.0.0.
+	greeter = newTestGreeter(t, "2.0.0", "charmander", GREETING_MODE_EVOLVE, PokemonFilter{})
+
+	if expected, actual := "charmander,charmeleon,charmander", nextNames(greeter, 3); expected != actual {
+		t.Errorf("Expected %s but found %s", expected, actual)
+	}
+
+	// Random Pokemon evolve until fully evolved and then another is chosen.
+	greeter = newTestGreeter(t, "3.0.0", "", GREETING_MODE_EVOLVE, PokemonFilter{Types: []string{"grass"}})
+	choices := []int{1, 0}
+	greeter.Intn = func(n int) int {
+		choice := choices[0]
+		choices = choices[1:]
+		return choice
+	}
+
+	if expected, actual := "ivysaur,venusaur,bulbasaur,ivysaur", nextNames(greeter, 4); expected != actual {
+		t.Errorf("Expected %s but found %s", expected, actual)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// Message catalogs keyed by locale.
+//
+//go:embed locales/*.properties
+var localeFiles embed.FS
+
+// The locale used when no other supported locale is requested. Its catalog
+// defines every message key.
+const DEFAULT_LOCALE = "en"
+
+// Every locale with a catalog in locales/.
+var LOCALES = []string{DEFAULT_LOCALE, "de", "fr", "ja"}
+
+// Env variables which select the locale in order of precedence.
+var LOCALE_ENV_NAMES = []string{"LC_ALL", "LC_MESSAGES", "LANG"}
+
+// Message keys used by the CLI. See locales/en.properties for the English
+// messages.
+const (
+	MSG_USAGE_GREETING          = "usage.greeting"
+	MSG_USAGE_LABEL             = "usage.label"
+	MSG_USAGE_POKEMON_ARG       = "usage.pokemon-arg"
+	MSG_USAGE_UPDATE            = "usage.update"
+	MSG_USAGE_VERSION_ARG       = "usage.version-arg"
+	MSG_USAGE_UPDATE_CHECK      = "usage.update-check"
+	MSG_USAGE_CACHE             = "usage.cache"
+	MSG_USAGE_CONFIG            = "usage.config"
+	MSG_USAGE_SETTINGS          = "usage.settings"
+	MSG_USAGE_SUPPORTED_POKEMON = "usage.supported-pokemon"
+	MSG_USAGE_VERSION           = "usage.version"
+
+	MSG_FLAG_HELP                  = "flag.help"
+	MSG_FLAG_VERSION               = "flag.version"
+	MSG_FLAG_UPDATE_URL            = "flag.update-url"
+	MSG_FLAG_CHANNEL               = "flag.channel"
+	MSG_FLAG_DAEMON                = "flag.daemon"
+	MSG_FLAG_UPDATE_CHECK_INTERVAL = "flag.update-check-interval"
+	MSG_FLAG_CACHE_DIR             = "flag.cache-dir"
+	MSG_FLAG_CACHE_KEEP            = "flag.cache-keep"
+	MSG_FLAG_CACHE_MAX_SIZE        = "flag.cache-max-size"
+	MSG_FLAG_SELF_REPLACE          = "flag.self-replace"
+	MSG_FLAG_BACKGROUND_UPDATE     = "flag.background-update"
+	MSG_FLAG_JSON                  = "flag.json"
+	MSG_FLAG_OUTPUT                = "flag.output"
+	MSG_FLAG_LOG_LEVEL             = "flag.log-level"
+	MSG_FLAG_LOG_FILE              = "flag.log-file"
+	MSG_FLAG_GREETING_TEMPLATE     = "flag.greeting-template"
+	MSG_FLAG_GREETING_MODE         = "flag.greeting-mode"
+	MSG_FLAG_EVOLVE                = "flag.evolve"
+	MSG_FLAG_POKEMON_TYPES         = "flag.pokemon-types"
+	MSG_FLAG_POKEMON_GENERATIONS   = "flag.pokemon-generations"
+	MSG_FLAG_LANG                  = "flag.lang"
+
+	MSG_POKEMON_DESCRIPTION           = "pokemon.description"
+	MSG_POKEMON_EVOLVES_FROM          = "pokemon.evolves-from"
+	MSG_POKEMON_EVOLVES_INTO          = "pokemon.evolves-into"
+	MSG_POKEMON_EVOLVES_FROM_AND_INTO = "pokemon.evolves-from-and-into"
+	MSG_GREETING_TEMPLATE             = "greeting.template"
+	MSG_GREETING_UNSUPPORTED_POKEMON  = "greeting.unsupported-pokemon"
+	MSG_GREETING_NO_MATCH             = "greeting.no-match"
+
+	MSG_ERROR_REQUIRES_VALUE  = "error.requires-value"
+	MSG_ERROR_INVALID_FLAG    = "error.invalid-flag"
+	MSG_ERROR_LOAD_CONFIG     = "error.load-config"
+	MSG_ERROR_INVALID_CONFIG  = "error.invalid-config"
+	MSG_ERROR_INVALID_SETTING = "error.invalid-setting"
+	MSG_ERROR_OPEN_LOG_FILE   = "error.open-log-file"
+	MSG_ERROR_FORMAT_GREETING = "error.format-greeting"
+
+	MSG_UPDATER_CHECKING                  = "updater.checking"
+	MSG_UPDATER_UP_TO_DATE                = "updater.up-to-date"
+	MSG_UPDATER_PINNED                    = "updater.pinned"
+	MSG_UPDATER_UPDATED                   = "updater.updated"
+	MSG_UPDATER_REVERTED                  = "updater.reverted"
+	MSG_UPDATER_SHUTTING_DOWN             = "updater.shutting-down"
+	MSG_UPDATER_FALLBACK_PREVIOUS         = "updater.fallback-previous"
+	MSG_UPDATER_FALLBACK_INSTALLED        = "updater.fallback-installed"
+	MSG_UPDATER_LOCK_RECOVERED            = "updater.lock-recovered"
+	MSG_UPDATER_CHECK_FAILED              = "updater.check-failed"
+	MSG_UPDATER_DOWNLOAD_FAILED           = "updater.download-failed"
+	MSG_UPDATER_START_FAILED              = "updater.start-failed"
+	MSG_UPDATER_WAIT_FAILED               = "updater.wait-failed"
+	MSG_UPDATER_SHUTDOWN_FAILED           = "updater.shutdown-failed"
+	MSG_UPDATER_CACHE_STATE_FAILED        = "updater.cache-state-failed"
+	MSG_UPDATER_PRUNE_FAILED              = "updater.prune-failed"
+	MSG_UPDATER_RESTORE_FAILED            = "updater.restore-failed"
+	MSG_UPDATER_BACKGROUND_FAILED         = "updater.background-failed"
+	MSG_UPDATER_BACKGROUND_START_FAILED   = "updater.background-start-failed"
+	MSG_UPDATER_DOWNLOADED_VERSION_FAILED = "updater.downloaded-version-failed"
+	MSG_UPDATER_UPDATE_FAILED             = "updater.update-failed"
+	MSG_UPDATER_UPDATABLE_FAILED          = "updater.updatable-failed"
+	MSG_UPDATER_REPORT_FAILED             = "updater.report-failed"
+)
+
+// Localized messages and Pokemon names for a single locale.
+type Catalog struct {
+	Locale   string
+	messages map[string]string
+	// Localized names keyed by Pokemon name
+	names map[string]string
+}
+
+// The catalog used by the whole CLI, configured by main.
+var messages = mustLoadCatalog(DEFAULT_LOCALE, nil)
+
+// Gets the locale such as "de" from a POSIX locale such as "de_DE.UTF-8".
+func normalizeLocale(locale string) string {
+
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	locale, _, _ = strings.Cut(locale, "_")
+	locale, _, _ = strings.Cut(locale, "-")
+	return strings.ToLower(locale)
+}
+
+// Chooses the locale from the lang setting or else from the first set locale
+// env variable. Unsupported locales fall back to the default locale.
+func detectLocale(lang string, getenv func(string) string) string {
+
+	for i := 0; lang == "" && i < len(LOCALE_ENV_NAMES); i += 1 {
+		lang = getenv(LOCALE_ENV_NAMES[i])
+	}
+
+	if locale := normalizeLocale(lang); slices.Contains(LOCALES, locale) {
+		return locale
+	}
+
+	return DEFAULT_LOCALE
+}
+
+// Parses the locale's catalog file without falling back to the default
+// locale.
+func parseCatalog(locale string) (map[string]string, error) {
+
+	file := path.Join("locales", locale+".properties")
+	content, err := localeFiles.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := map[string]string{}
+
+	err = readProperties(string(content), file, func(key string, value string) error {
+		catalog[key] = value
+		return nil
+	})
+
+	return catalog, err
+}
+
+// Loads the locale's catalog with the default locale's messages for any
+// missing keys. Pokemon names come from the Pokedex for the locale or are
+// capitalized if the Pokedex has no name for the locale.
+func loadCatalog(locale string, pokedex []Pokemon) (*Catalog, error) {
+
+	catalog, err := parseCatalog(DEFAULT_LOCALE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if locale != DEFAULT_LOCALE {
+
+		localized, err := parseCatalog(locale)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for key, message := range localized {
+			catalog[key] = message
+		}
+	}
+
+	names := make(map[string]string, len(pokedex))
+
+	for _, pokemon := range pokedex {
+		if name := pokemon.LocalizedNames[locale]; name != "" {
+			names[pokemon.Name] = name
+		}
+	}
+
+	return &Catalog{Locale: locale, messages: catalog, names: names}, nil
+}
+
+func mustLoadCatalog(locale string, pokedex []Pokemon) *Catalog {
+
+	catalog, err := loadCatalog(locale, pokedex)
+
+	if err != nil {
+		panic(fmt.Sprintf("Invalid embedded %s messages:\n%v", locale, err))
+	}
+
+	return catalog
+}
+
+// Formats the message with the args. Unknown keys are returned as is so that
+// they're easy to spot.
+func (c *Catalog) Get(key string, args ...any) string {
+
+	message, ok := c.messages[key]
+
+	if !ok {
+		return key
+	}
+
+	return fmt.Sprintf(message, args...)
+}
+
+// Gets the Pokemon's localized name.
+func (c *Catalog) PokemonName(name string) string {
+
+	if localized, ok := c.names[name]; ok {
+		return localized
+	}
+
+	return common.Capitalize(name)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Matches fmt verbs ignoring explicit arg indexes such as %[2]s.
+var verbPattern = regexp.MustCompile(`%(?:\[\d+\])?([a-z])`)
+
+func formatVerbs(message string) string {
+
+	var verbs []string
+
+	for _, match := range verbPattern.FindAllStringSubmatch(message, -1) {
+		verbs = append(verbs, match[1])
+	}
+
+	return strings.Join(verbs, "")
+}
+
+// Guards the embedded catalogs so that a missing or broken translation fails
+// the build's tests rather than falling back to English at runtime.
+func TestCatalogsHaveEveryKey(t *testing.T) {
+
+	english, err := parseCatalog(DEFAULT_LOCALE)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	files, err := localeFiles.ReadDir("locales")
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, file := range files {
+		if locale := strings.TrimSuffix(file.Name(), path.Ext(file.Name())); !slices.Contains(LOCALES, locale) {
+			t.Errorf("Expected %s to be listed in LOCALES", locale)
+		}
+	}
+
+	for _, locale := range LOCALES {
+
+		catalog, err := parseCatalog(locale)
+
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+
+		for key, message := range english {
+
+			localized, ok := catalog[key]
+
+			if !ok || localized == "" {
+				t.Errorf("Expected the %s catalog to have %s", locale, key)
+				continue
+			}
+
+			if expected, actual := formatVerbs(message), formatVerbs(localized); expected != actual {
+				t.Errorf("Expected %s in %s to have the verbs %s but found %s", key, locale, expected, actual)
+			}
+		}
+
+		for key := range catalog {
+			if _, ok := english[key]; !ok {
+				t.Errorf("Expected the %s catalog not to have unknown key %s", locale, key)
+			}
+		}
+
+		if _, err = parseGreetingTemplate(catalog[MSG_GREETING_TEMPLATE]); err != nil {
+			t.Errorf("Expected a valid %s greeting template but found %v", locale, err)
+		}
+	}
+}
+
+func TestDetectLocale(t *testing.T) {
+
+	testCases := []struct {
+		lang     string
+		env      map[string]string
+		expected string
+	}{
+		{"", nil, DEFAULT_LOCALE},
+		{"", map[string]string{"LANG": "de_DE.UTF-8"}, "de"},
+		{"", map[string]string{"LANG": "de_DE.UTF-8", "LC_MESSAGES": "fr_FR"}, "fr"},
+		{"", map[string]string{"LC_ALL": "ja_JP.UTF-8", "LANG": "de_DE.UTF-8"}, "ja"},
+		{"", map[string]string{"LANG": "C"}, DEFAULT_LOCALE},
+		{"", map[string]string{"LANG": "es_ES.UTF-8"}, DEFAULT_LOCALE},
+		{"FR", map[string]string{"LC_ALL": "ja_JP.UTF-8"}, "fr"},
+		{"de-AT", nil, "de"},
+	}
+
+	for _, testCase := range testCases {
+		if actual := detectLocale(testCase.lang, func(name string) string { return testCase.env[name] }); testCase.expected != actual {
+			t.Errorf("Expected %s for %s and %v but found %s", testCase.expected, testCase.lang, testCase.env, actual)
+		}
+	}
+}
+
+func TestLocalizedGreetingsAndDescriptions(t *testing.T) {
+
+	pokedex, err := parsePokedex(pokedexJson)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	defaultMessages := messages
+	defer func() { messages = defaultMessages }()
+
+	testCases := []struct {
+		locale      string
+		greeting    string
+		description string
+	}{
+		{"en", `Pikachu says, "Pika pika!".`, "Charmeleon (fire, generation 1) evolves from Charmander and evolves into Charizard"},
+		{"de", `Pikachu sagt: „Pika pika!“`, "Glutexo (fire, Generation 1) entwickelt sich aus Glumanda und entwickelt sich zu Glurak"},
+		{"fr", `Pikachu dit : « Pika pika! »`, "Reptincel (fire, génération 1) évolue à partir de Salamèche et évolue en Dracaufeu"},
+		{"ja", `ピカチュウ「Pika pika!」`, "リザード (fire、第1世代)はヒトカゲから進化し、リザードンに進化します"},
+	}
+
+	for _, testCase := range testCases {
+
+		messages = mustLoadCatalog(testCase.locale, pokedex)
+		greetingTemplate, err := parseGreetingTemplate(messages.Get(MSG_GREETING_TEMPLATE))
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		greeter, err := newGreeter(pokedex, "pikachu", GREETING_MODE_RANDOM, PokemonFilter{}, greetingTemplate)
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if greeting, err := greeter.Greet(greeter.Next()); err != nil || testCase.greeting != greeting {
+			t.Errorf("Expected %s but found %s: %v", testCase.greeting, greeting, err)
+		}
+
+		charmeleon, _ := findPokemon(pokedex, "charmeleon")
+
+		if actual := describePokemon(charmeleon); testCase.description != actual {
+			t.Errorf("Expected %s but found %s", testCase.description, actual)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The name of the lock file in the cache dir which coordinates updaters
+// sharing the same cache dir.
+const LOCK_FILE = ".pokemon.lock"
+
+// The longest time to wait for another updater to finish downloading.
+const LOCK_TIMEOUT_SECS = 5 * 60
+
+const LOCK_RETRY_INTERVAL = 100 * time.Millisecond
+
+// Advisory lock on a file which is held by at most one process at a time. The
+// operating system releases the lock if the process crashes.
+type FileLock struct {
+	file *os.File
+}
+
+// Acquires the lock, waiting up to timeout for another process to release it.
+// The lock file contains the PID of the process holding the lock and is
+// cleared when the lock is released. If the lock file still contains a PID
+// when the lock is acquired, the previous holder crashed while holding the
+// lock and its PID is returned as stalePid.
+func acquireLock(path string, timeout time.Duration) (lock *FileLock, stalePid int, err error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		locked, err := tryLockFile(file)
+
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, 0, fmt.Errorf("timed out after %s waiting for lock \"%s\"", timeout, path)
+		}
+
+		time.Sleep(LOCK_RETRY_INTERVAL)
+	}
+
+	lock = &FileLock{file: file}
+	previousPid, err := io.ReadAll(file)
+
+	if err == nil {
+		stalePid, _ = strconv.Atoi(strings.TrimSpace(string(previousPid)))
+	}
+
+	if err = file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+
+	if err != nil {
+		lock.Release()
+		return nil, 0, err
+	}
+
+	return lock, stalePid, nil
+}
+
+// Clears the PID from the lock file and releases the lock.
+func (lock *FileLock) Release() error {
+
+	defer lock.file.Close()
+
+	if err := lock.file.Truncate(0); err != nil {
+		unlockFile(lock.file)
+		return err
+	}
+
+	return unlockFile(lock.file)
+}
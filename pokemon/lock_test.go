@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLockExcludesOtherHolders(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), LOCK_FILE)
+	lock, stalePid, err := acquireLock(path, time.Second)
+
+	if err != nil || stalePid != 0 {
+		t.Fatalf("Expected lock with no stale PID but found %d: %v", stalePid, err)
+	}
+
+	if _, _, err := acquireLock(path, 2*LOCK_RETRY_INTERVAL); err == nil {
+		t.Errorf("Expected timeout acquiring held lock")
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	lock, stalePid, err = acquireLock(path, time.Second)
+
+	if err != nil || stalePid != 0 {
+		t.Fatalf("Expected released lock with no stale PID but found %d: %v", stalePid, err)
+	}
+
+	lock.Release()
+}
+
+func TestAcquireLockDetectsStaleLock(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), LOCK_FILE)
+
+	// Simulate a process which crashed while holding the lock.
+	if err := os.WriteFile(path, []byte("12345"), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	lock, stalePid, err := acquireLock(path, time.Second)
+
+	if err != nil || stalePid != 12345 {
+		t.Fatalf("Expected stale PID %d but found %d: %v", 12345, stalePid, err)
+	}
+
+	lock.Release()
+}
@@ -0,0 +1,49 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const LOCKFILE_FAIL_IMMEDIATELY = 0x1
+const LOCKFILE_EXCLUSIVE_LOCK = 0x2
+const ERROR_LOCK_VIOLATION syscall.Errno = 33
+
+// Attempts to lock the file without blocking. Returns false if another process
+// holds the lock.
+func tryLockFile(file *os.File) (bool, error) {
+
+	var overlapped syscall.Overlapped
+	locked, _, err := procLockFileEx.Call(file.Fd(), LOCKFILE_EXCLUSIVE_LOCK|LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+
+	if locked != 0 {
+		return true, nil
+	}
+
+	if err == ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func unlockFile(file *os.File) error {
+
+	var overlapped syscall.Overlapped
+	unlocked, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+
+	if unlocked == 0 {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Output formats selected with --output.
+const OUTPUT_TEXT = "text"
+const OUTPUT_JSON = "json"
+
+// Event types emitted by the CLI. These are part of the JSON output schema, so
+// never rename them.
+const (
+	// A greeting from a Pokemon. The payload is a GreetingPayload.
+	EVENT_GREETING = "greeting"
+	// The updater is checking for updates. The payload is a VersionPayload
+	// with the running version or an empty version if nothing is running.
+	EVENT_UPDATE_CHECK = "update_check"
+	// The running version is the latest version. The payload is a
+	// VersionPayload.
+	EVENT_UP_TO_DATE = "up_to_date"
+	// The updater switched versions. The payload is an UpdatePayload.
+	EVENT_UPDATED = "updated"
+	// The updater fell back to a known working version after a failure. The
+	// payload is an UpdatePayload.
+	EVENT_FALLBACK = "fallback"
+	// The updater stopped a version. The payload is a VersionPayload.
+	EVENT_SHUTDOWN = "shutdown"
+	// The update check failed. The payload is an ErrorPayload.
+	EVENT_UPDATE_CHECK_FAILED = "update_check_failed"
+	// Downloading or verifying a version failed. The payload is an
+	// ErrorPayload.
+	EVENT_DOWNLOAD_FAILED = "download_failed"
+	// Starting a version failed. The payload is an ErrorPayload.
+	EVENT_START_FAILED = "start_failed"
+	// Reporting the outcome of an update to the server failed. The payload is
+	// an ErrorPayload.
+	EVENT_REPORT_FAILED = "report_failed"
+	// The updater took over the cache lock from a crashed process. The
+	// payload is a LockPayload.
+	EVENT_LOCK_RECOVERED = "lock_recovered"
+	// Any other failure. The payload is an ErrorPayload.
+	EVENT_ERROR = "error"
+)
+
+// A single line of JSON output.
+type Event struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	// The version of the CLI which emitted the event
+	Version string `json:"version"`
+	Payload any    `json:"payload"`
+}
+
+type GreetingPayload struct {
+	Pokemon string `json:"pokemon"`
+	Message string `json:"message"`
+}
+
+type VersionPayload struct {
+	Version string `json:"version"`
+}
+
+func (p VersionPayload) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("version", p.Version))
+}
+
+type UpdatePayload struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (p UpdatePayload) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("from", p.From), slog.String("to", p.To))
+}
+
+type ErrorPayload struct {
+	// The version the error relates to or empty if it doesn't relate to a
+	// specific version
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error"`
+}
+
+func (p ErrorPayload) LogValue() slog.Value {
+
+	if p.Version == "" {
+		return slog.GroupValue(slog.String("error", p.Error))
+	}
+
+	return slog.GroupValue(slog.String("version", p.Version), slog.String("error", p.Error))
+}
+
+type LockPayload struct {
+	Lock string `json:"lock"`
+	// The PID of the crashed process which held the lock
+	Pid int `json:"pid"`
+}
+
+func (p LockPayload) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("lock", p.Lock), slog.Int("pid", p.Pid))
+}
+
+func newErrorPayload(version string, err error) ErrorPayload {
+
+	payload := ErrorPayload{Version: version}
+
+	if err != nil {
+		payload.Error = err.Error()
+	}
+
+	return payload
+}
+
+// Creates the logger for updater diagnostics which writes text records to the
+// writer.
+func newLogger(w io.Writer, level slog.Level, version string) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+	})).With("pid", os.Getpid(), "cli_version", version)
+}
+
+// Opens the log file for appending since the updater and the child process
+// both write to it.
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+}
+
+// Writes greetings to stdout and updater events to the logger. With JSON
+// output, every event is also written to stdout as newline-delimited JSON.
+// Stdout is otherwise reserved for greetings so that updater diagnostics never
+// interleave with them.
+type Output struct {
+	Json    bool
+	Version string
+	Stdout  io.Writer
+	Logger  *slog.Logger
+	Now     func() time.Time
+	// Keeps events from different goroutines from interleaving.
+	lock sync.Mutex
+}
+
+// The output used by the whole CLI, configured by main.
+var output = &Output{
+	Stdout: os.Stdout,
+	Logger: newLogger(os.Stderr, slog.LevelInfo, ""),
+	Now:    time.Now,
+}
+
+func (o *Output) writeEvent(eventType string, payload any) {
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	event := Event{
+		Type:      eventType,
+		Timestamp: o.Now().UTC(),
+		Version:   o.Version,
+		Payload:   payload,
+	}
+
+	// Encode writes the event and its newline in a single write.
+	if err := json.NewEncoder(o.Stdout).Encode(&event); err != nil {
+		o.Logger.Error("Failed to write event", "event", eventType, "error", err)
+	}
+}
+
+// Prints a greeting to stdout.
+func (o *Output) Greeting(pokemon string, message string) {
+
+	if o.Json {
+		o.writeEvent(EVENT_GREETING, GreetingPayload{Pokemon: pokemon, Message: message})
+		return
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	fmt.Fprintln(o.Stdout, message)
+}
+
+// Logs an updater event with the payload's fields as attributes.
+func (o *Output) Log(level slog.Level, eventType string, payload any, message string) {
+
+	// An empty key inlines the payload's fields.
+	o.Logger.Log(context.Background(), level, message, "event", eventType, slog.Any("", payload))
+
+	if o.Json {
+		o.writeEvent(eventType, payload)
+	}
+}
+
+func (o *Output) Debug(eventType string, payload any, message string) {
+	o.Log(slog.LevelDebug, eventType, payload, message)
+}
+
+func (o *Output) Info(eventType string, payload any, message string) {
+	o.Log(slog.LevelInfo, eventType, payload, message)
+}
+
+func (o *Output) Warn(eventType string, payload any, message string) {
+	o.Log(slog.LevelWarn, eventType, payload, message)
+}
+
+func (o *Output) Error(eventType string, payload any, message string) {
+	o.Log(slog.LevelError, eventType, payload, message)
+}
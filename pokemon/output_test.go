@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func removeTime(groups []string, attr slog.Attr) slog.Attr {
+
+	if len(groups) == 0 && attr.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+
+	return attr
+}
+
+func newTestOutput(json bool) (*Output, *bytes.Buffer, *bytes.Buffer) {
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	return &Output{
+		Json:    json,
+		Version: "2.0.0",
+		Stdout:  stdout,
+		Logger:  slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: removeTime})),
+		Now: func() time.Time {
+			return time.Date(2024, time.March, 1, 12, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+		},
+	}, stdout, stderr
+}
+
+// The JSON output is consumed by scripts, so any change to these schemas is a
+// breaking change.
+func TestOutputJsonSchemas(t *testing.T) {
+
+	prefix := `{"type":"%s","timestamp":"2024-03-01T17:30:00Z","version":"2.0.0","payload":`
+	testCases := []struct {
+		eventType string
+		payload   any
+		expected  string
+	}{
+		{EVENT_GREETING, GreetingPayload{Pokemon: "pikachu", Message: `Pikachu says, "Hi!".`}, `{"pokemon":"pikachu","message":"Pikachu says, \"Hi!\"."}`},
+		{EVENT_UPDATE_CHECK, VersionPayload{Version: "2.0.0"}, `{"version":"2.0.0"}`},
+		{EVENT_UP_TO_DATE, VersionPayload{Version: "2.0.0"}, `{"version":"2.0.0"}`},
+		{EVENT_UPDATED, UpdatePayload{From: "2.0.0", To: "10.0.0"}, `{"from":"2.0.0","to":"10.0.0"}`},
+		{EVENT_FALLBACK, UpdatePayload{From: "10.0.0", To: "2.0.0"}, `{"from":"10.0.0","to":"2.0.0"}`},
+		{EVENT_SHUTDOWN, VersionPayload{Version: "2.0.0"}, `{"version":"2.0.0"}`},
+		{EVENT_UPDATE_CHECK_FAILED, newErrorPayload("", errors.New("connection refused")), `{"error":"connection refused"}`},
+		{EVENT_DOWNLOAD_FAILED, newErrorPayload("10.0.0", errors.New("hash mismatch")), `{"version":"10.0.0","error":"hash mismatch"}`},
+		{EVENT_START_FAILED, newErrorPayload("10.0.0", errors.New("exec format error")), `{"version":"10.0.0","error":"exec format error"}`},
+		{EVENT_LOCK_RECOVERED, LockPayload{Lock: LOCK_FILE, Pid: 123}, `{"lock":".pokemon.lock","pid":123}`},
+		{EVENT_ERROR, newErrorPayload("", errors.New("failed")), `{"error":"failed"}`},
+	}
+
+	for _, testCase := range testCases {
+
+		out, stdout, _ := newTestOutput(true)
+
+		if testCase.eventType == EVENT_GREETING {
+			out.Greeting("pikachu", `Pikachu says, "Hi!".`)
+		} else {
+			out.Error(testCase.eventType, testCase.payload, "Message")
+		}
+
+		expected := strings.Replace(prefix, "%s", testCase.eventType, 1) + testCase.expected + "}\n"
+
+		if actual := stdout.String(); expected != actual {
+			t.Errorf("Expected %s but found %s", expected, actual)
+		}
+	}
+}
+
+// Stdout is reserved for greetings in text mode.
+func TestOutputTextLogsEvents(t *testing.T) {
+
+	out, stdout, stderr := newTestOutput(false)
+	out.Greeting("pikachu", `Pikachu says, "Hi!".`)
+	out.Info(EVENT_UPDATED, UpdatePayload{From: "2.0.0", To: "10.0.0"}, "Successfully updated")
+	out.Error(EVENT_DOWNLOAD_FAILED, newErrorPayload("10.0.0", errors.New("hash mismatch")), "Failed to download update file")
+
+	if expected, actual := "Pikachu says, \"Hi!\".\n", stdout.String(); expected != actual {
+		t.Errorf("Expected %s but found %s", expected, actual)
+	}
+
+	expected := `level=INFO msg="Successfully updated" event=updated from=2.0.0 to=10.0.0
+level=ERROR msg="Failed to download update file" event=download_failed version=10.0.0 error="hash mismatch"
+`
+
+	if actual := stderr.String(); expected != actual {
+		t.Errorf("Expected %s but found %s", expected, actual)
+	}
+}
+
+func TestOutputLogLevel(t *testing.T) {
+
+	stderr := &bytes.Buffer{}
+	out := &Output{Stdout: &bytes.Buffer{}, Logger: newLogger(stderr, slog.LevelInfo, "2.0.0"), Now: time.Now}
+	out.Debug(EVENT_UPDATE_CHECK, VersionPayload{}, "Checking for updates")
+
+	if stderr.Len() != 0 {
+		t.Errorf("Expected debug events to be filtered but found %s", stderr.String())
+	}
+
+	out.Warn(EVENT_FALLBACK, UpdatePayload{From: "10.0.0", To: "2.0.0"}, "Falling back to the previous version")
+
+	if actual := stderr.String(); !strings.Contains(actual, "cli_version=2.0.0") || !strings.Contains(actual, "event=fallback") {
+		t.Errorf("Expected a fallback record but found %s", actual)
+	}
+}
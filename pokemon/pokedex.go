@@ -0,0 +1,184 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// Every Pokemon known to the CLI.
+//
+//go:embed pokemon.json
+var pokedexJson []byte
+
+type Pokemon struct {
+	// The lowercase name used on the command line
+	Name       string   `json:"name"`
+	Types      []string `json:"types"`
+	Generation int      `json:"generation"`
+	// Every Pokemon in the evolution chain in order including this one
+	Evolution []string `json:"evolution"`
+	// Names keyed by locale such as "fr"
+	LocalizedNames map[string]string `json:"localizedNames"`
+	// What the Pokemon says in greetings
+	Greeting string `json:"greeting"`
+	// How often the Pokemon greets relative to others in weighted mode
+	Weight int `json:"weight"`
+	// The first version of the CLI which includes the Pokemon
+	Since string `json:"since"`
+}
+
+// Parses and validates Pokemon data.
+func parsePokedex(data []byte) ([]Pokemon, error) {
+
+	var pokedex []Pokemon
+
+	if err := json.Unmarshal(data, &pokedex); err != nil {
+		return nil, err
+	}
+
+	if len(pokedex) == 0 {
+		return nil, fmt.Errorf("no Pokemon found")
+	}
+
+	byName := make(map[string]Pokemon, len(pokedex))
+
+	for i, pokemon := range pokedex {
+
+		if pokemon.Name == "" || pokemon.Name != strings.ToLower(strings.TrimSpace(pokemon.Name)) {
+			return nil, fmt.Errorf("Pokemon at %d must have a lowercase name but found \"%s\"", i, pokemon.Name)
+		}
+
+		if _, ok := byName[pokemon.Name]; ok {
+			return nil, fmt.Errorf("%s is listed more than once", pokemon.Name)
+		}
+
+		byName[pokemon.Name] = pokemon
+
+		if len(pokemon.Types) == 0 || slices.Contains(pokemon.Types, "") {
+			return nil, fmt.Errorf("%s must have at least one type", pokemon.Name)
+		}
+
+		if pokemon.Generation < 1 {
+			return nil, fmt.Errorf("%s must have a positive generation", pokemon.Name)
+		}
+
+		if pokemon.Greeting == "" {
+			return nil, fmt.Errorf("%s must have a greeting", pokemon.Name)
+		}
+
+		if pokemon.Weight < 1 {
+			return nil, fmt.Errorf("%s must have a positive weight", pokemon.Name)
+		}
+
+		if _, err := common.ParseSemVer(pokemon.Since); err != nil {
+			return nil, fmt.Errorf("%s has an invalid since version:\n%v", pokemon.Name, err)
+		}
+
+		if !slices.Contains(pokemon.Evolution, pokemon.Name) {
+			return nil, fmt.Errorf("%s must be in its own evolution chain %v", pokemon.Name, pokemon.Evolution)
+		}
+
+		for locale, name := range pokemon.LocalizedNames {
+			if locale == "" || name == "" {
+				return nil, fmt.Errorf("%s has a blank localized name", pokemon.Name)
+			}
+		}
+	}
+
+	// Every Pokemon in a chain must agree on the chain.
+	for _, pokemon := range pokedex {
+		for _, evolution := range pokemon.Evolution {
+
+			other, ok := byName[evolution]
+
+			if !ok {
+				return nil, fmt.Errorf("%s evolves with unknown Pokemon %s", pokemon.Name, evolution)
+			}
+
+			if !slices.Equal(pokemon.Evolution, other.Evolution) {
+				return nil, fmt.Errorf("%s and %s have different evolution chains %v and %v", pokemon.Name, other.Name, pokemon.Evolution, other.Evolution)
+			}
+		}
+	}
+
+	return pokedex, nil
+}
+
+// Gets the Pokemon included in the version of the CLI. If names are specified,
+// only those Pokemon are included.
+func pokemonForVersion(pokedex []Pokemon, version string, names []string) ([]Pokemon, error) {
+
+	cliVersion, err := common.ParseSemVer(version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	available := make([]Pokemon, 0, len(pokedex))
+
+	for _, pokemon := range pokedex {
+
+		// The since version was validated when parsing.
+		since, _ := common.ParseSemVer(pokemon.Since)
+
+		if !cliVersion.Less(since) {
+			available = append(available, pokemon)
+		}
+	}
+
+	if len(names) == 0 {
+		return available, nil
+	}
+
+	selected := make([]Pokemon, 0, len(names))
+
+	for _, name := range names {
+
+		pokemon, ok := findPokemon(available, name)
+
+		if !ok {
+			return nil, fmt.Errorf("%s is not a supported Pokemon", common.Capitalize(name))
+		}
+
+		selected = append(selected, pokemon)
+	}
+
+	return selected, nil
+}
+
+func findPokemon(pokedex []Pokemon, name string) (Pokemon, bool) {
+
+	for _, pokemon := range pokedex {
+		if pokemon.Name == name {
+			return pokemon, true
+		}
+	}
+
+	return Pokemon{}, false
+}
+
+// Describes the Pokemon for usage such as "Charmeleon (fire, generation 1)
+// evolves from Charmander and evolves into Charizard" in the CLI's language.
+func describePokemon(pokemon Pokemon) string {
+
+	description := messages.Get(MSG_POKEMON_DESCRIPTION, messages.PokemonName(pokemon.Name), strings.Join(pokemon.Types, "/"), pokemon.Generation)
+	i := slices.Index(pokemon.Evolution, pokemon.Name)
+	evolvesFrom := i > 0
+	evolvesInto := i < len(pokemon.Evolution)-1
+
+	switch {
+	case evolvesFrom && evolvesInto:
+		return messages.Get(MSG_POKEMON_EVOLVES_FROM_AND_INTO, description, messages.PokemonName(pokemon.Evolution[i-1]), messages.PokemonName(pokemon.Evolution[i+1]))
+	case evolvesFrom:
+		return messages.Get(MSG_POKEMON_EVOLVES_FROM, description, messages.PokemonName(pokemon.Evolution[i-1]))
+	case evolvesInto:
+		return messages.Get(MSG_POKEMON_EVOLVES_INTO, description, messages.PokemonName(pokemon.Evolution[i+1]))
+	default:
+		return description
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Guards the embedded data so that a bad edit fails the build's tests rather
+// than panicking at startup.
+func TestEmbeddedPokedexIsValid(t *testing.T) {
+
+	pokedex, err := parsePokedex(pokedexJson)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, pokemon := range pokedex {
+		for _, locale := range []string{"de", "fr", "ja"} {
+			if pokemon.LocalizedNames[locale] == "" {
+				t.Errorf("Expected a %s name for %s", locale, pokemon.Name)
+			}
+		}
+	}
+}
+
+func TestParsePokedexRejectsInvalidData(t *testing.T) {
+
+	valid := `{"name": "pikachu", "types": ["electric"], "generation": 1, "evolution": ["pikachu"], "greeting": "Pika!", "weight": 1, "since": "1.0.0"}`
+	testCases := []struct {
+		json     string
+		expected string
+	}{
+		{`[]`, "no Pokemon"},
+		{`[` + strings.Replace(valid, `"pikachu", "types"`, `"Pikachu", "types"`, 1) + `]`, "lowercase name"},
+		{`[` + valid + `, ` + valid + `]`, "more than once"},
+		{`[` + strings.Replace(valid, `["electric"]`, `[]`, 1) + `]`, "type"},
+		{`[` + strings.Replace(valid, `"generation": 1`, `"generation": 0`, 1) + `]`, "generation"},
+		{`[` + strings.Replace(valid, `"Pika!"`, `""`, 1) + `]`, "greeting"},
+		{`[` + strings.Replace(valid, `"weight": 1`, `"weight": 0`, 1) + `]`, "weight"},
+		{`[` + strings.Replace(valid, `"1.0.0"`, `"one"`, 1) + `]`, "since"},
+		{`[` + strings.Replace(valid, `["pikachu"]`, `["pichu"]`, 1) + `]`, "own evolution chain"},
+		{`[` + strings.Replace(valid, `["pikachu"]`, `["pikachu", "raichu"]`, 1) + `]`, "unknown Pokemon raichu"},
+	}
+
+	for _, testCase := range testCases {
+		if _, err := parsePokedex([]byte(testCase.json)); err == nil || !strings.Contains(err.Error(), testCase.expected) {
+			t.Errorf("Expected an error containing \"%s\" for %s but found %v", testCase.expected, testCase.json, err)
+		}
+	}
+}
+
+func pokemonNames(pokedex []Pokemon) string {
+
+	names := make([]string, 0, len(pokedex))
+
+	for _, pokemon := range pokedex {
+		names = append(names, pokemon.Name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestPokemonForVersion(t *testing.T) {
+
+	pokedex, err := parsePokedex(pokedexJson)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	testCases := []struct {
+		version  string
+		names    []string
+		expected string
+	}{
+		{"1.0.0", nil, "pikachu,charmander,squirtle,bulbasaur"},
+		{"2.1.0", nil, "pikachu,raichu,charmander,charmeleon,squirtle,wartortle,bulbasaur,ivysaur"},
+		{"10.0.0", []string{"venusaur", "pikachu"}, "venusaur,pikachu"},
+	}
+
+	for _, testCase := range testCases {
+
+		available, err := pokemonForVersion(pokedex, testCase.version, testCase.names)
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if actual := pokemonNames(available); testCase.expected != actual {
+			t.Errorf("Expected %s but found %s", testCase.expected, actual)
+		}
+	}
+
+	if _, err = pokemonForVersion(pokedex, "1.0.0", []string{"raichu"}); err == nil {
+		t.Errorf("Expected Raichu to be unsupported in 1.0.0")
+	}
+}
+
+func TestDescribePokemon(t *testing.T) {
+
+	pokedex, err := parsePokedex(pokedexJson)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	testCases := map[string]string{
+		"charmander": "Charmander (fire, generation 1) evolves into Charmeleon",
+		"charmeleon": "Charmeleon (fire, generation 1) evolves from Charmander and evolves into Charizard",
+		"charizard":  "Charizard (fire/flying, generation 1) evolves from Charmeleon",
+	}
+
+	for name, expected := range testCases {
+
+		pokemon, _ := findPokemon(pokedex, name)
+
+		if actual := describePokemon(pokemon); expected != actual {
+			t.Errorf("Expected %s but found %s", expected, actual)
+		}
+	}
+}
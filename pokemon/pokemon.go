@@ -3,13 +3,17 @@
 package main
 
 import (
+	"compress/gzip"
+	"crypto/ed25519"
 	"crypto/sha512"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,7 +21,9 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/stiemannkj1/auto-update-example/common"
 )
@@ -32,11 +38,16 @@ const POKEMON_CLI string = "POKEMON_CLI"
 
 const SHORT_TIMEOUT_SECS = 1
 
+const MB int64 = 1024 * 1024
+
+// The content coding requested for downloads since executables compress well.
+const GZIP_ENCODING string = "gzip"
+
 func exeSuffix() string {
 	if runtime.GOOS == "windows" {
 		return ".exe"
 	} else {
-		return " "
+		return ""
 	}
 }
 
@@ -47,26 +58,37 @@ var shutdownSignal = []byte{1}
 
 // Injected at build time:
 var Version string
-var UpdateUrl string
 
-// TODO maybe change to embedded properties file
-var AvailablePokemon string
+// Optionally injected at build time to override the embedded default:
+var UpdateUrl string
 
-// Prints CLI usage and available Pokemon.
-func printUsage(version string, flags []common.CliFlag, availablePokemon []string) {
-	fmt.Fprintf(os.Stderr, "Print a greeting from your favorite Pokemon.\nUsage: pokemon [(optional) Pokemon name]\n\n")
+// Optionally injected at build time as the base64 encoded ed25519 public key
+// which must have signed every update. Updates aren't signature checked
+// without it.
+var PublicKey string
+
+// Prints CLI usage and available Pokemon in the CLI's language.
+func printUsage(version string, flags []common.CliFlag, availablePokemon []Pokemon) {
+	usage := messages.Get(MSG_USAGE_LABEL)
+	indent := strings.Repeat(" ", utf8.RuneCountInString(usage))
+	fmt.Fprintf(os.Stderr, "%s\n%s pokemon [%s]\n\n", messages.Get(MSG_USAGE_GREETING), usage, messages.Get(MSG_USAGE_POKEMON_ARG))
+	fmt.Fprintf(os.Stderr, "%s\n%s pokemon %[3]s check\n%[4]s pokemon %[3]s apply [%[5]s]\n%[4]s pokemon %[3]s rollback\n%[4]s pokemon %[3]s list\n\n", messages.Get(MSG_USAGE_UPDATE), usage, UPDATE_COMMAND, indent, messages.Get(MSG_USAGE_VERSION_ARG))
+	fmt.Fprintf(os.Stderr, "%s\n\n", messages.Get(MSG_USAGE_UPDATE_CHECK, UPDATE_COMMAND, EXIT_UPDATE_AVAILABLE))
+	fmt.Fprintf(os.Stderr, "%s\n%s pokemon %s prune\n\n", messages.Get(MSG_USAGE_CACHE), usage, CACHE_COMMAND)
+	fmt.Fprintf(os.Stderr, "%s\n%s pokemon %s show\n\n", messages.Get(MSG_USAGE_CONFIG), usage, CONFIG_COMMAND)
+	fmt.Fprintf(os.Stderr, "%s\n\n", messages.Get(MSG_USAGE_SETTINGS, systemConfigPath(), userConfigPath(), CONFIG_ENV_PREFIX))
 
 	for _, flag := range flags {
 		fmt.Fprintf(os.Stderr, "%s, %s\n\t%s\n", flag.Name, flag.Short, flag.Description)
 	}
 
-	fmt.Fprintf(os.Stderr, "\nSupported Pokemon:\n\n")
+	fmt.Fprintf(os.Stderr, "\n%s\n\n", messages.Get(MSG_USAGE_SUPPORTED_POKEMON))
 
 	for _, pokemon := range availablePokemon {
-		fmt.Fprintf(os.Stderr, "\t- %s\n", common.Capitalize(pokemon))
+		fmt.Fprintf(os.Stderr, "\t- %s\n", describePokemon(pokemon))
 	}
 
-	fmt.Fprintf(os.Stderr, "\nVersion: %s\n", version)
+	fmt.Fprintf(os.Stderr, "\n%s\n", messages.Get(MSG_USAGE_VERSION, version))
 }
 
 func main() {
@@ -76,28 +98,6 @@ func main() {
 		panic("Version must be specified in the build via `-ldflags \"-X 'main.Version=1.0.0'\"`")
 	}
 
-	if UpdateUrl == "" {
-		panic("Default UpdateUrl must be specified via in the build via `-ldflags \"-X 'main.UpdateUrl=https://localhost:8080/'\"`")
-	}
-
-	if AvailablePokemon == "" {
-		panic("At least one Pokemon must be specified in the build via `-ldflags \"-X 'main.AvailablePokemon=pikachu,charmander,squirtle,bulbasaur'\"`")
-	}
-
-	AvailablePokemon := strings.Split(AvailablePokemon, ",")
-
-	for i := 0; i < len(AvailablePokemon); i += 1 {
-		AvailablePokemon[i] = strings.ToLower(strings.TrimSpace(AvailablePokemon[i]))
-
-		if AvailablePokemon[i] == "" {
-			panic(fmt.Sprintf("AvailablePokemon at %d was blank.", i))
-		}
-	}
-
-	if len(AvailablePokemon) == 0 {
-		panic("No AvailablePokemon found.")
-	}
-
 	// Get the current executable, its metadata, and its parent:
 	exe, err := os.Executable()
 
@@ -120,134 +120,376 @@ func main() {
 
 	exePermissions := exeStat.Mode().Perm()
 
+	// Load settings from every source other than flags. Errors are reported
+	// after parsing flags so that --help and --version still work.
+	rawConfig, configErr := loadConfig(map[string]string{
+		CONFIG_UPDATE_URL: UpdateUrl,
+	}, os.Getenv, systemConfigPath(), userConfigPath())
+
+	if rawConfig[CONFIG_CACHE_DIR].Value == "" {
+		rawConfig[CONFIG_CACHE_DIR] = ConfigValue{Value: defaultCacheDir(exeDir), Source: CONFIG_SOURCE_DEFAULT}
+	}
+
+	pokedex, err := parsePokedex(pokedexJson)
+
+	if err != nil {
+		panic(fmt.Sprintf("Invalid embedded Pokemon data:\n%v", err))
+	}
+
+	availablePokemon, err := pokemonForVersion(pokedex, Version, nil)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error finding Pokemon for version %s:\n%v", Version, err))
+	}
+
+	// Choose the language before creating flags so that usage is localized.
+	// The lang flag is found here for the same reason and then parsed with
+	// the other flags below.
+	langFlag := common.CliFlag{
+		Name:  "--" + CONFIG_LANG,
+		Short: "-L",
+	}
+	lang := rawConfig[CONFIG_LANG].Value
+
+	for i := 1; i+1 < len(os.Args); i += 1 {
+		if os.Args[i] == langFlag.Name || os.Args[i] == langFlag.Short {
+			lang = os.Args[i+1]
+		}
+	}
+
+	messages = mustLoadCatalog(detectLocale(lang, os.Getenv), pokedex)
+	langFlag.Description = messages.Get(MSG_FLAG_LANG, strings.Join(LOCALES, ", "), DEFAULT_LOCALE)
+
+	if rawConfig[CONFIG_GREETING_TEMPLATE].Value == "" {
+		rawConfig[CONFIG_GREETING_TEMPLATE] = ConfigValue{Value: messages.Get(MSG_GREETING_TEMPLATE), Source: CONFIG_SOURCE_DEFAULT}
+	}
+
 	helpFlag := common.CliFlag{
 		Name:        "--help",
 		Short:       "-h",
-		Description: "Print this help message",
+		Description: messages.Get(MSG_FLAG_HELP),
 	}
 	versionFlag := common.CliFlag{
 		Name:        "--version",
 		Short:       "-v",
-		Description: "Print the version of this cli tool",
+		Description: messages.Get(MSG_FLAG_VERSION),
 	}
 	updateUrlFlag := common.CliFlag{
-		Name:        "--update-url",
+		Name:        "--" + CONFIG_UPDATE_URL,
 		Short:       "-u",
-		Description: fmt.Sprintf("(optional) The url to obtain updates from (https is required). Defaults to %s", UpdateUrl),
+		Description: messages.Get(MSG_FLAG_UPDATE_URL, rawConfig[CONFIG_UPDATE_URL].Value),
+	}
+	channelFlag := common.CliFlag{
+		Name:        "--" + CONFIG_CHANNEL,
+		Short:       "-C",
+		Description: messages.Get(MSG_FLAG_CHANNEL),
 	}
-
-	var daemonIntervalSecs uint64 = 1
 	daemonFlag := common.CliFlag{
 		Name:        "--daemon",
 		Short:       "-d",
-		Description: fmt.Sprintf("(optional) Run this executable in daemon mode outputting a Pokemon greeting on an interval. Configure the interval in seconds by specifying an optional positive integer. Defaults to %d second(s) if interval is unspecified", daemonIntervalSecs),
+		Description: messages.Get(MSG_FLAG_DAEMON, rawConfig[CONFIG_DAEMON_INTERVAL].Value),
 	}
-
-	var updateCheckIntervalSecs uint64 = 15
 	updateIntervalFlag := common.CliFlag{
-		Name:        "--update-check-interval",
-		Short:       "-u",
-		Description: fmt.Sprintf("(optional) Interval to check for updates when running in daemon mode. Defaults to %d second(s)", updateCheckIntervalSecs),
+		Name:        "--" + CONFIG_UPDATE_CHECK_INTERVAL,
+		Short:       "-i",
+		Description: messages.Get(MSG_FLAG_UPDATE_CHECK_INTERVAL, rawConfig[CONFIG_UPDATE_CHECK_INTERVAL].Value),
+	}
+	cacheDirFlag := common.CliFlag{
+		Name:        "--" + CONFIG_CACHE_DIR,
+		Short:       "-c",
+		Description: messages.Get(MSG_FLAG_CACHE_DIR, rawConfig[CONFIG_CACHE_DIR].Value),
+	}
+	cacheKeepFlag := common.CliFlag{
+		Name:        "--" + CONFIG_CACHE_KEEP,
+		Short:       "-k",
+		Description: messages.Get(MSG_FLAG_CACHE_KEEP, rawConfig[CONFIG_CACHE_KEEP].Value),
+	}
+	cacheMaxSizeFlag := common.CliFlag{
+		Name:        "--" + CONFIG_CACHE_MAX_SIZE,
+		Short:       "-m",
+		Description: messages.Get(MSG_FLAG_CACHE_MAX_SIZE, rawConfig[CONFIG_CACHE_MAX_SIZE].Value),
+	}
+	selfReplaceFlag := common.CliFlag{
+		Name:        "--" + CONFIG_SELF_REPLACE,
+		Short:       "-r",
+		Description: messages.Get(MSG_FLAG_SELF_REPLACE, BACKUP_SUFFIX),
+	}
+	backgroundUpdateFlag := common.CliFlag{
+		Name:        "--" + CONFIG_BACKGROUND_UPDATE,
+		Short:       "-b",
+		Description: messages.Get(MSG_FLAG_BACKGROUND_UPDATE),
+	}
+	jsonFlag := common.CliFlag{
+		Name:        "--json",
+		Short:       "-j",
+		Description: messages.Get(MSG_FLAG_JSON, UPDATE_COMMAND, CACHE_COMMAND, OUTPUT_JSON),
+	}
+	outputFlag := common.CliFlag{
+		Name:        "--" + CONFIG_OUTPUT,
+		Short:       "-o",
+		Description: messages.Get(MSG_FLAG_OUTPUT, OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_JSON, rawConfig[CONFIG_OUTPUT].Value),
+	}
+	logLevelFlag := common.CliFlag{
+		Name:        "--" + CONFIG_LOG_LEVEL,
+		Short:       "-l",
+		Description: messages.Get(MSG_FLAG_LOG_LEVEL, rawConfig[CONFIG_LOG_LEVEL].Value),
+	}
+	logFileFlag := common.CliFlag{
+		Name:        "--" + CONFIG_LOG_FILE,
+		Short:       "-f",
+		Description: messages.Get(MSG_FLAG_LOG_FILE),
+	}
+
+	greetingTemplateFlag := common.CliFlag{
+		Name:        "--" + CONFIG_GREETING_TEMPLATE,
+		Short:       "-t",
+		Description: messages.Get(MSG_FLAG_GREETING_TEMPLATE, rawConfig[CONFIG_GREETING_TEMPLATE].Value),
+	}
+	greetingModeFlag := common.CliFlag{
+		Name:        "--" + CONFIG_GREETING_MODE,
+		Short:       "-g",
+		Description: messages.Get(MSG_FLAG_GREETING_MODE, strings.Join(GREETING_MODES, ", "), rawConfig[CONFIG_GREETING_MODE].Value),
+	}
+	evolveFlag := common.CliFlag{
+		Name:        "--evolve",
+		Short:       "-e",
+		Description: messages.Get(MSG_FLAG_EVOLVE, greetingModeFlag.Name, GREETING_MODE_EVOLVE),
+	}
+	pokemonTypesFlag := common.CliFlag{
+		Name:        "--" + CONFIG_POKEMON_TYPES,
+		Short:       "-y",
+		Description: messages.Get(MSG_FLAG_POKEMON_TYPES),
+	}
+	pokemonGenerationsFlag := common.CliFlag{
+		Name:        "--" + CONFIG_POKEMON_GENERATIONS,
+		Short:       "-n",
+		Description: messages.Get(MSG_FLAG_POKEMON_GENERATIONS),
 	}
 
-	flags := []common.CliFlag{helpFlag, versionFlag, updateUrlFlag, daemonFlag, updateIntervalFlag}
+	flags := []common.CliFlag{helpFlag, versionFlag, updateUrlFlag, channelFlag, daemonFlag, updateIntervalFlag, cacheDirFlag, cacheKeepFlag, cacheMaxSizeFlag, selfReplaceFlag, backgroundUpdateFlag, jsonFlag, outputFlag, logLevelFlag, logFileFlag, greetingTemplateFlag, greetingModeFlag, evolveFlag, pokemonTypesFlag, pokemonGenerationsFlag, langFlag}
 
 	var pokemon string
+	var command []string
 	args := os.Args
 
 	daemonRun := false
 
+	// Settings from flags override every other source.
+	flagConfig := RawConfig{}
+	setFromFlag := func(flag common.CliFlag, key string, value string) {
+		flagConfig[key] = ConfigValue{Value: value, Source: flag.Name}
+	}
+
+	// Sets the setting from the value following the flag at i and returns
+	// the index of the value.
+	setFromFlagValue := func(flag common.CliFlag, key string, i int) int {
+
+		if i+1 >= len(args) || args[i+1] == "" {
+			fmt.Fprintf(os.Stderr, "%s\n", messages.Get(MSG_ERROR_REQUIRES_VALUE, flag.Name))
+			printUsage(Version, flags, availablePokemon)
+			os.Exit(64)
+		}
+
+		setFromFlag(flag, key, args[i+1])
+		return i + 1
+	}
+
 	// Avoid using `flag` package here since we need to customize our arg parsing code.
 	// Parse CLI args:``
 	for i := 1; i < len(args); i += 1 {
 		switch args[i] {
 		case helpFlag.Name, helpFlag.Short:
-			printUsage(Version, flags, AvailablePokemon)
+			printUsage(Version, flags, availablePokemon)
 			return
 		case versionFlag.Name, versionFlag.Short:
 			fmt.Fprintf(os.Stderr, "%s\n", Version)
 			return
 		case updateUrlFlag.Name, updateUrlFlag.Short:
-			if i+1 < len(args) {
-				i += 1
-				UpdateUrl = args[i]
-			} else {
-				fmt.Fprint(os.Stderr, "No value provided for --update-url\n")
-				printUsage(Version, flags, AvailablePokemon)
-				os.Exit(64)
-			}
-
-			// Require https for user supplied URLs.
-			// Allow non-https for the default URL for the sake of testing.
-			if !strings.HasPrefix(UpdateUrl, "https:") {
-				fmt.Fprintf(os.Stderr, "--update-url must use https: %s\n", UpdateUrl)
-				printUsage(Version, flags, AvailablePokemon)
-				os.Exit(64)
-			}
+			i = setFromFlagValue(updateUrlFlag, CONFIG_UPDATE_URL, i)
+		case channelFlag.Name, channelFlag.Short:
+			i = setFromFlagValue(channelFlag, CONFIG_CHANNEL, i)
 		case daemonFlag.Name, daemonFlag.Short:
 			daemonRun = true
 
-			var err error
-
+			// The interval is optional, so the next argument might be a
+			// Pokemon or another argument.
 			if i+1 < len(args) {
-				daemonIntervalSecs, err = strconv.ParseUint(args[i+1], 10, 16)
-			}
-
-			if err != nil {
-				// Ignore, this might be a Pokemon or another argument.
-			} else {
-				i += 1
+				if _, err := strconv.ParseUint(args[i+1], 10, 16); err == nil {
+					i = setFromFlagValue(daemonFlag, CONFIG_DAEMON_INTERVAL, i)
+				}
 			}
 		case updateIntervalFlag.Name, updateIntervalFlag.Short:
-
-			var err error
-
-			hasValue := i+1 < len(args)
-
-			if hasValue {
-				i += 1
-				daemonIntervalSecs, err = strconv.ParseUint(args[i], 10, 16)
-			}
-
-			if !hasValue || err != nil {
-				fmt.Fprintf(os.Stderr, "%s requires a positive integer value.\n", updateIntervalFlag.Name)
-				printUsage(Version, flags, AvailablePokemon)
-				os.Exit(64)
-			}
+			i = setFromFlagValue(updateIntervalFlag, CONFIG_UPDATE_CHECK_INTERVAL, i)
+		case selfReplaceFlag.Name, selfReplaceFlag.Short:
+			setFromFlag(selfReplaceFlag, CONFIG_SELF_REPLACE, "true")
+		case backgroundUpdateFlag.Name, backgroundUpdateFlag.Short:
+			setFromFlag(backgroundUpdateFlag, CONFIG_BACKGROUND_UPDATE, "true")
+		case jsonFlag.Name, jsonFlag.Short:
+			setFromFlag(jsonFlag, CONFIG_OUTPUT, OUTPUT_JSON)
+		case outputFlag.Name, outputFlag.Short:
+			i = setFromFlagValue(outputFlag, CONFIG_OUTPUT, i)
+		case logLevelFlag.Name, logLevelFlag.Short:
+			i = setFromFlagValue(logLevelFlag, CONFIG_LOG_LEVEL, i)
+		case logFileFlag.Name, logFileFlag.Short:
+			i = setFromFlagValue(logFileFlag, CONFIG_LOG_FILE, i)
+		case cacheDirFlag.Name, cacheDirFlag.Short:
+			i = setFromFlagValue(cacheDirFlag, CONFIG_CACHE_DIR, i)
+		case cacheKeepFlag.Name, cacheKeepFlag.Short:
+			i = setFromFlagValue(cacheKeepFlag, CONFIG_CACHE_KEEP, i)
+		case cacheMaxSizeFlag.Name, cacheMaxSizeFlag.Short:
+			i = setFromFlagValue(cacheMaxSizeFlag, CONFIG_CACHE_MAX_SIZE, i)
+		case greetingTemplateFlag.Name, greetingTemplateFlag.Short:
+			i = setFromFlagValue(greetingTemplateFlag, CONFIG_GREETING_TEMPLATE, i)
+		case greetingModeFlag.Name, greetingModeFlag.Short:
+			i = setFromFlagValue(greetingModeFlag, CONFIG_GREETING_MODE, i)
+		case evolveFlag.Name, evolveFlag.Short:
+			setFromFlag(evolveFlag, CONFIG_GREETING_MODE, GREETING_MODE_EVOLVE)
+		case pokemonTypesFlag.Name, pokemonTypesFlag.Short:
+			i = setFromFlagValue(pokemonTypesFlag, CONFIG_POKEMON_TYPES, i)
+		case pokemonGenerationsFlag.Name, pokemonGenerationsFlag.Short:
+			i = setFromFlagValue(pokemonGenerationsFlag, CONFIG_POKEMON_GENERATIONS, i)
+		case langFlag.Name, langFlag.Short:
+			i = setFromFlagValue(langFlag, CONFIG_LANG, i)
 		default:
 			if len(args[i]) == 0 || args[i][0] == '-' {
-				fmt.Fprintf(os.Stderr, "Invalid flag: \"%s\"\n", args[i])
-				printUsage(Version, flags, AvailablePokemon)
+				fmt.Fprintf(os.Stderr, "%s\n", messages.Get(MSG_ERROR_INVALID_FLAG, args[i]))
+				printUsage(Version, flags, availablePokemon)
 				os.Exit(64)
+			} else if len(command) > 0 || (pokemon == "" && slices.Contains(COMMANDS, args[i])) {
+				command = append(command, args[i])
 			} else {
 				pokemon = strings.ToLower(args[i])
 			}
 		}
 	}
 
-	if strings.ToUpper(os.Getenv(POKEMON_CLI)) != "TRUE" {
+	if configErr != nil {
+		fmt.Fprintf(os.Stderr, "%s\n%v\n", messages.Get(MSG_ERROR_LOAD_CONFIG), configErr)
+		os.Exit(64)
+	}
+
+	for key, value := range flagConfig {
+		rawConfig[key] = value
+	}
+
+	config, err := parseConfig(rawConfig)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n%v\n", messages.Get(MSG_ERROR_INVALID_CONFIG), err)
+		printUsage(Version, flags, availablePokemon)
+		os.Exit(64)
+	}
+
+	availablePokemon, err = pokemonForVersion(pokedex, Version, config.AvailablePokemon)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n%v\n", messages.Get(MSG_ERROR_INVALID_SETTING, CONFIG_AVAILABLE_POKEMON, rawConfig[CONFIG_AVAILABLE_POKEMON].Source), err)
+		printUsage(Version, flags, availablePokemon)
+		os.Exit(64)
+	}
+
+	var logWriter io.Writer = os.Stderr
+
+	if config.LogFile != "" {
+		logFile, err := openLogFile(config.LogFile)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n%v\n", messages.Get(MSG_ERROR_OPEN_LOG_FILE, config.LogFile), err)
+			os.Exit(1)
+		}
+
+		defer logFile.Close()
+		logWriter = logFile
+	}
+
+	output.Json = config.Json
+	output.Version = Version
+	output.Logger = newLogger(logWriter, config.LogLevel, Version)
+
+	// Run subcommands without updating.
+	if len(command) > 0 {
+
+		exitCode := runCommand(command, CommandSettings{
+			Exe:              exe,
+			ExePermissions:   exePermissions,
+			CacheDir:         config.CacheDir,
+			CachePolicy:      config.CachePolicy,
+			InstalledVersion: Version,
+			UpdateUrl:        config.UpdateUrl,
+			Channel:          config.Channel,
+			SelfReplace:      config.SelfReplace,
+			Json:             config.Json,
+			Config:           rawConfig,
+		})
+
+		if exitCode == EXIT_USAGE {
+			printUsage(Version, flags, availablePokemon)
+		}
+
+		os.Exit(exitCode)
+	}
+
+	isChild := strings.ToUpper(os.Getenv(POKEMON_CLI)) == "TRUE"
+
+	// Detached helper started by a previous invocation to download updates.
+	if strings.ToUpper(os.Getenv(POKEMON_BACKGROUND_UPDATER)) == "TRUE" {
+		err = backgroundUpdate(exe, config.CacheDir, config.CachePolicy, exePermissions, Version, config.UpdateUrl, config.Channel, config.SelfReplace)
+
+		if err != nil {
+			output.Error(EVENT_ERROR, newErrorPayload("", err), messages.Get(MSG_UPDATER_BACKGROUND_FAILED))
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if !isChild && config.BackgroundUpdate && !daemonRun {
+
+		if shouldCheckForUpdates(config.CacheDir, time.Duration(config.UpdateCheckIntervalSecs)*time.Second) {
+			if err = startBackgroundUpdate(exe); err != nil {
+				output.Error(EVENT_START_FAILED, newErrorPayload("", err), messages.Get(MSG_UPDATER_BACKGROUND_START_FAILED))
+			}
+		}
+
+		// If a downloaded version exists, it runs and this process exits.
+		// Otherwise the installed version runs directly.
+		if !config.SelfReplace {
+			err = runDownloadedVersion(config.CacheDir, Version)
+
+			if err != nil {
+				output.Error(EVENT_START_FAILED, newErrorPayload("", err), messages.Get(MSG_UPDATER_DOWNLOADED_VERSION_FAILED, Version))
+			}
+		}
+	} else if !isChild && config.SelfReplace && !daemonRun {
+
+		// If the executable is replaced, the update runs and this process
+		// exits. Otherwise the installed version runs directly.
+		err = selfReplace(exe, config.CacheDir, config.CachePolicy, exePermissions, Version, config.UpdateUrl, config.Channel)
+
+		if err != nil {
+			output.Error(EVENT_ERROR, newErrorPayload("", err), messages.Get(MSG_UPDATER_UPDATE_FAILED, Version))
+		}
+	} else if !isChild {
 
 		// If the updater completely fails for some bizarre reason, we fall
 		// back to simply running the command directly without any update
 		// functionality. Barring errors, the update loop method should not
 		// exit.
-		err = updateLoop(exe, exeDir, exePermissions, daemonRun, Version, UpdateUrl, updateCheckIntervalSecs)
+		err = updateLoop(exe, config.CacheDir, config.CachePolicy, exePermissions, daemonRun, Version, config.UpdateUrl, config.Channel, config.UpdateCheckIntervalSecs)
 
 		if err == nil {
 			return
 		}
 
-		fmt.Fprintf(os.Stderr, "Failed to use updateable version:\n%v\nFalling back to non-updatable execution.\n", err)
+		output.Error(EVENT_ERROR, newErrorPayload("", err), messages.Get(MSG_UPDATER_UPDATABLE_FAILED))
 	}
 
 	// Update before validating pokemon in case the update supports a new
 	// pokemon.
-	randomPokemon := false
+	greeter, err := newGreeter(availablePokemon, pokemon, config.GreetingMode, config.PokemonFilter, config.GreetingTemplate)
 
-	if pokemon == "" {
-		randomPokemon = true
-	} else if !slices.Contains(AvailablePokemon, pokemon) {
-		fmt.Fprintf(os.Stderr, "%s is not a supported Pokemon.\n", common.Capitalize(pokemon))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(64)
 	}
 
@@ -261,16 +503,20 @@ func main() {
 			os.Exit(0)
 		}
 
-		if randomPokemon {
-			pokemon = AvailablePokemon[rand.Intn(len(AvailablePokemon))]
+		greeting := greeter.Next()
+		message, err := greeter.Greet(greeting)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n%v\n", messages.Get(MSG_ERROR_FORMAT_GREETING, messages.PokemonName(greeting.Name)), err)
+			os.Exit(1)
 		}
 
-		fmt.Printf("%s says, \"Hi!\".\n", common.Capitalize(pokemon))
+		output.Greeting(greeting.Name, message)
 
 		if !daemonRun {
 			return
 		} else {
-			time.Sleep(time.Duration(daemonIntervalSecs) * time.Second)
+			time.Sleep(time.Duration(config.DaemonIntervalSecs) * time.Second)
 		}
 	}
 }
@@ -282,6 +528,39 @@ type Cmd struct {
 	Stdin   io.WriteCloser
 }
 
+// Update server state remembered between update checks.
+type UpdateServer struct {
+	Url string
+	// The interval between update checks requested by the server or 0 if the
+	// server didn't request one.
+	PollInterval time.Duration
+	// True if the server doesn't support watching for new versions.
+	NoWatch bool
+	// The entity tag of the last versions response used to avoid downloading
+	// versions that haven't changed.
+	ETag string
+	// The versions from the last versions response in ascending order.
+	Versions []string
+	// The version of the CLI which is running, sent with update checks
+	ClientVersion string
+	// The anonymous client ID sent with update checks or empty to send none
+	ClientId string
+	// The release channel sent with update checks or empty for the server's
+	// default channel
+	ClientChannel string
+}
+
+// Creates the update server state for a client running the version on the
+// channel.
+func newUpdateServer(updateUrl string, channel string, cacheDir string, version string) *UpdateServer {
+	return &UpdateServer{
+		Url:           updateUrl,
+		ClientVersion: version,
+		ClientId:      loadClientId(cacheDir),
+		ClientChannel: channel,
+	}
+}
+
 func kill(cmd *exec.Cmd) {
 	if cmd != nil && cmd.Process != nil {
 		_ = cmd.Process.Kill()
@@ -296,7 +575,7 @@ func kill(cmd *exec.Cmd) {
 // 4. Starting the new version.
 // This function will also attempt to fall back to previous working versions if
 // there are problems.
-func updateLoop(exe string, exeDir string, exePermissions fs.FileMode, isDaemon bool, initialVersion string, updateUrl string, updateCheckIntervalSecs uint64) error {
+func updateLoop(exe string, cacheDir string, cachePolicy CachePolicy, exePermissions fs.FileMode, isDaemon bool, initialVersion string, updateUrl string, channel string, updateCheckIntervalSecs uint64) error {
 
 	// Propagate this value to child processes.
 	err := os.Setenv(POKEMON_CLI, "TRUE")
@@ -305,12 +584,47 @@ func updateLoop(exe string, exeDir string, exePermissions fs.FileMode, isDaemon
 		return fmt.Errorf("update failed to set %s", POKEMON_CLI)
 	}
 
+	if err = os.MkdirAll(cacheDir, 0o755); err != nil {
+		return fmt.Errorf("update failed to create cache dir \"%s\":\n%v", cacheDir, err)
+	}
+
 	var prevCmd Cmd
 	var currentCmd Cmd
 	updateFilePath := ""
 
+	server := newUpdateServer(updateUrl, channel, cacheDir, initialVersion)
+	interval := time.Duration(updateCheckIntervalSecs) * time.Second
+	backoff := NewBackoff(interval, MAX_BACKOFF_SECS*time.Second)
+
+	regularWait := func() time.Duration {
+		backoff.Reset()
+
+		if server.PollInterval > 0 {
+			return server.PollInterval
+		}
+
+		return interval
+	}
+
+	var wait time.Duration
+	latestVersion := ""
 	first := true
 
+	// Start the current version before checking for updates in daemon mode
+	// so that greetings begin immediately. Then offset the first check by a
+	// random splay so that a fleet of daemons started at the same moment
+	// doesn't poll in lockstep.
+	if isDaemon {
+		currentCmd, err = startCurrentVersion(exe, cacheDir, initialVersion)
+
+		if err != nil {
+			return err
+		}
+
+		first = false
+		wait = backoff.Splay(interval)
+	}
+
 	for {
 
 		// If this is a non-daemon process, it should execute and exit immediately.
@@ -318,7 +632,7 @@ func updateLoop(exe string, exeDir string, exePermissions fs.FileMode, isDaemon
 			err = currentCmd.Cmd.Wait()
 
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to wait for child process:\n%v\n", err)
+				output.Error(EVENT_ERROR, newErrorPayload(currentCmd.Version, err), messages.Get(MSG_UPDATER_WAIT_FAILED))
 				kill(currentCmd.Cmd)
 				os.Exit(1)
 			}
@@ -329,137 +643,506 @@ func updateLoop(exe string, exeDir string, exePermissions fs.FileMode, isDaemon
 
 		if first {
 			first = false
+		} else if backoff.Failing() || server.NoWatch || latestVersion == "" {
+			time.Sleep(wait)
 		} else {
-			time.Sleep(time.Duration(updateCheckIntervalSecs) * time.Second)
+			watchForNewVersion(server, latestVersion, wait)
 		}
 
-		fmt.Printf("Checking for updates...\n")
+		output.Debug(EVENT_UPDATE_CHECK, VersionPayload{Version: currentCmd.Version}, messages.Get(MSG_UPDATER_CHECKING))
+
+		if currentCmd.Version != "" {
+			server.ClientVersion = currentCmd.Version
+		}
 
 		// TODO configure limits on versions to update.
-		version, err := getLatestVersion(updateUrl)
+		version, err := getLatestVersion(server)
+		latestVersion = version
 
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed determine versions available for updates:\n%v\n", err)
-		} else if currentCmd.Version == version {
-			fmt.Printf("%s is the already latest version.\n", version)
-			continue
-		}
+		// Keep running the version chosen with `pokemon update apply VERSION`
+		// or `pokemon update rollback` instead of the latest.
+		pinned := pinnedVersion(cacheDir)
 
-		// TODO handle name collisions.
-		updateFilePath, err = downloadUpdateVersion(exeDir, updateUrl, version, exePermissions)
+		if err == nil && pinned != "" {
+			version = pinned
+		}
 
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to download update file:\n%v\n", err)
-		} else {
-			var newCmd Cmd
-			newCmd, err = upgradeChildProcess(currentCmd, updateFilePath, version)
+			output.Error(EVENT_UPDATE_CHECK_FAILED, newErrorPayload("", err), messages.Get(MSG_UPDATER_CHECK_FAILED))
+			wait = backoff.Next(retryAfter(err))
 
-			if err == nil {
-				prevCmd = currentCmd
-				currentCmd = newCmd
-				fmt.Printf("Successfully updated to version %s.\n", version)
+			// Keep the current version running until the server recovers.
+			if currentCmd.Cmd != nil {
 				continue
 			}
+		} else if currentCmd.Version == version && pinned != "" {
+			output.Debug(EVENT_UP_TO_DATE, VersionPayload{Version: version}, messages.Get(MSG_UPDATER_PINNED, version))
+			wait = regularWait()
+			continue
+		} else if currentCmd.Version == version {
+			output.Debug(EVENT_UP_TO_DATE, VersionPayload{Version: version}, messages.Get(MSG_UPDATER_UP_TO_DATE))
+			wait = regularWait()
+			continue
+		} else {
+
+			updateFilePath, err = downloadUpdateVersion(cacheDir, server.Url, version, exePermissions)
 
-			fmt.Fprintf(os.Stderr, "Failed to start process \"%s\":\n%v\n", updateFilePath, err)
+			if err != nil {
+				output.Error(EVENT_DOWNLOAD_FAILED, newErrorPayload(version, err), messages.Get(MSG_UPDATER_DOWNLOAD_FAILED))
+				sendReport(server, common.ReportStageDownload, currentCmd.Version, version, err)
+				wait = backoff.Next(retryAfter(err))
+
+				if currentCmd.Cmd != nil {
+					continue
+				}
+			} else {
+				var newCmd Cmd
+				newCmd, err = upgradeChildProcess(currentCmd, updateFilePath, version)
+				wait = regularWait()
+
+				if err == nil {
+					prevCmd = currentCmd
+					currentCmd = newCmd
+					output.Info(EVENT_UPDATED, UpdatePayload{From: prevCmd.Version, To: version}, messages.Get(MSG_UPDATER_UPDATED))
+					sendReport(server, common.ReportStageUpdated, prevCmd.Version, version, nil)
+					updateCache(cacheDir, cachePolicy, currentCmd, prevCmd, pinned != "")
+					continue
+				}
+
+				output.Error(EVENT_START_FAILED, newErrorPayload(version, err), messages.Get(MSG_UPDATER_START_FAILED, updateFilePath))
+				sendReport(server, common.ReportStageStart, currentCmd.Version, version, err)
+			}
 		}
 
 		// Attempt to fall back to the last known working version.
 		if prevCmd.Path != "" && prevCmd.Path != updateFilePath {
-			fmt.Fprintf(os.Stderr, "Falling back to \"%s\".\n", prevCmd.Version)
+			output.Warn(EVENT_FALLBACK, UpdatePayload{From: currentCmd.Version, To: prevCmd.Version}, messages.Get(MSG_UPDATER_FALLBACK_PREVIOUS))
 
+			fromVersion := currentCmd.Version
 			currentCmd, err = upgradeChildProcess(currentCmd, prevCmd.Path, prevCmd.Version)
 
 			if err == nil {
-				fmt.Printf("Successfully reverted to \"%s\".", prevCmd.Version)
+				output.Info(EVENT_UPDATED, UpdatePayload{From: fromVersion, To: prevCmd.Version}, messages.Get(MSG_UPDATER_REVERTED))
+
+				// Only report rollbacks caused by a failed update rather than
+				// by the server being unreachable.
+				if version != "" {
+					sendReport(server, common.ReportStageRollback, version, prevCmd.Version, nil)
+				}
+
 				continue
 			}
 
-			fmt.Fprintf(os.Stderr, "Failed to start process \"%s\":\n%v\n", prevCmd.Path, err)
+			output.Error(EVENT_START_FAILED, newErrorPayload(prevCmd.Version, err), messages.Get(MSG_UPDATER_START_FAILED, prevCmd.Path))
 		}
 
 		// Fall back to the current version since we at least know it was installed.
-		fmt.Fprintf(os.Stderr, "Falling back to \"%s\".\n", initialVersion)
+		output.Warn(EVENT_FALLBACK, UpdatePayload{From: currentCmd.Version, To: initialVersion}, messages.Get(MSG_UPDATER_FALLBACK_INSTALLED))
 
 		currentCmd, err = upgradeChildProcess(currentCmd, exe, initialVersion)
 
 		if err != nil {
 			return fmt.Errorf("failed to use default version")
 		}
+
+		if version != "" {
+			sendReport(server, common.ReportStageRollback, version, initialVersion, nil)
+		}
+	}
+}
+
+// Starts the downloaded version from the cache or the installed version if
+// it's newer or the downloaded version doesn't start.
+func startCurrentVersion(exe string, cacheDir string, installedVersion string) (Cmd, error) {
+
+	if version := downloadedVersion(cacheDir, installedVersion); version != installedVersion {
+
+		cmd, err := upgradeChildProcess(Cmd{}, cachedVersionPath(cacheDir, version), version)
+
+		if err == nil {
+			return cmd, nil
+		}
+
+		output.Error(EVENT_START_FAILED, newErrorPayload(version, err), messages.Get(MSG_UPDATER_START_FAILED, cachedVersionPath(cacheDir, version)))
+	}
+
+	cmd, err := upgradeChildProcess(Cmd{}, exe, installedVersion)
+
+	if err != nil {
+		return Cmd{}, fmt.Errorf("failed to use default version")
+	}
+
+	return cmd, nil
+}
+
+// Records the current and previous versions and whether automatic updates are
+// pinned to the current version and prunes old versions from the cache.
+// Failures are only reported since they don't affect the running version.
+func updateCache(cacheDir string, cachePolicy CachePolicy, currentCmd Cmd, prevCmd Cmd, pinned bool) {
+
+	err := writeCacheState(cacheDir, CacheState{
+		Current:  currentCmd.Version,
+		Previous: prevCmd.Version,
+		Pinned:   pinned,
+	})
+
+	if err != nil {
+		output.Error(EVENT_ERROR, newErrorPayload(currentCmd.Version, err), messages.Get(MSG_UPDATER_CACHE_STATE_FAILED, cacheDir))
+		return
+	}
+
+	if _, err = pruneCache(cacheDir, cachePolicy, currentCmd.Version, prevCmd.Version); err != nil {
+		output.Error(EVENT_ERROR, newErrorPayload("", err), messages.Get(MSG_UPDATER_PRUNE_FAILED, cacheDir))
 	}
 }
 
 // Gets the latest available version from the server.
-func getLatestVersion(updateUrl string) (string, error) {
+func getLatestVersion(server *UpdateServer) (string, error) {
 
-	resp, err := http.Get(fmt.Sprintf("%s/v1.0/versions/%s", updateUrl, POKEMON))
+	versions, err := getVersions(server)
 
 	if err != nil {
 		return "", err
 	}
 
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions available from %s", server.Url)
+	}
+
+	return versions[len(versions)-1], nil
+}
+
+// Gets all available versions from the server in ascending order.
+func getVersions(server *UpdateServer) ([]string, error) {
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1.0/versions/%s", server.Url, POKEMON), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if server.ETag != "" {
+		req.Header.Set("If-None-Match", server.ETag)
+	}
+
+	setClientHeaders(req, server)
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		return nil, newHttpStatusError(resp)
+	}
+
+	if pollIntervalSecs, err := strconv.ParseUint(resp.Header.Get(common.PollIntervalName), 10, 32); err == nil && pollIntervalSecs > 0 {
+		server.PollInterval = time.Duration(pollIntervalSecs) * time.Second
+	} else {
+		server.PollInterval = 0
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return server.Versions, nil
+	}
+
 	var versions common.Versions
 
-	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil || len(versions.All) == 0 {
-		return "", err
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return nil, err
 	}
 
-	return versions.All[len(versions.All)-1], nil
+	server.ETag = resp.Header.Get("ETag")
+	server.Versions = versions.All
+
+	return server.Versions, nil
 }
 
-// Downloads the specified version of the tool if it doesn't already exist on
-// the filesystem.
-func downloadUpdateVersion(exeDir string, updateUrl string, version string, permissions fs.FileMode) (string, error) {
+// Identifies the client to the server so that it's counted in the census and
+// offered the versions rolled out to it.
+func setClientHeaders(req *http.Request, server *UpdateServer) {
+
+	if server.ClientVersion != "" {
+		req.Header.Set(common.ClientVersionName, server.ClientVersion)
+	}
+
+	if server.ClientId != "" {
+		req.Header.Set(common.ClientIdName, server.ClientId)
+	}
+
+	if server.ClientChannel != "" {
+		req.Header.Set(common.ClientChannelName, server.ClientChannel)
+	}
+
+	req.Header.Set(common.ClientPlatformName, clientPlatform())
+}
+
+// Blocks until the server has a version other than latestVersion or the
+// timeout elapses. If the server doesn't support watching for new versions,
+// this falls back to sleeping for the remainder of the timeout.
+func watchForNewVersion(server *UpdateServer, latestVersion string, timeout time.Duration) {
+
+	start := time.Now()
+	timeoutSecs := max(uint64(timeout/time.Second), 1)
+
+	// Allow the server some extra time to respond after the timeout elapses.
+	client := http.Client{Timeout: timeout + time.Duration(SHORT_TIMEOUT_SECS)*time.Second}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1.0/watch/%s?version=%s&timeout=%d", server.Url, POKEMON, url.QueryEscape(latestVersion), timeoutSecs), nil)
+
+	if err != nil {
+		time.Sleep(timeout)
+		return
+	}
+
+	setClientHeaders(req, server)
+	resp, err := client.Do(req)
+
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified {
+			return
+		}
+
+		// Older servers don't support watching, so poll instead.
+		if resp.StatusCode == http.StatusNotFound {
+			server.NoWatch = true
+		}
+	}
+
+	time.Sleep(timeout - time.Since(start))
+}
+
+// Gets the hash, size, and signature of a version from the server without
+// downloading the version.
+func getVersionMetadata(updateUrl string, version string) (common.Metadata, error) {
+
+	resp, err := http.Get(fmt.Sprintf("%s/v1.0/metadata/%s?version=%s", updateUrl, POKEMON, url.QueryEscape(version)))
+
+	if err != nil {
+		return common.Metadata{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return common.Metadata{}, newHttpStatusError(resp)
+	}
+
+	var metadata common.Metadata
+
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return common.Metadata{}, err
+	}
+
+	return metadata, nil
+}
+
+// Checks that the file has the size and hash in the metadata.
+func verifyFile(path string, metadata common.Metadata) error {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	stat, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() != metadata.Size {
+		return fmt.Errorf("expected file %s to have size %d, but found %d", path, metadata.Size, stat.Size())
+	}
+
+	sha512, err := common.Sha512Hash(file)
+
+	if err != nil {
+		return err
+	}
+
+	if metadata.Sha512 != sha512 {
+		return common.NewSha512Error(path, metadata.Sha512, sha512)
+	}
+
+	return nil
+}
+
+// Checks that the file was signed with PublicKey. Does nothing if the build
+// has no public key.
+func verifySignature(path string, metadata common.Metadata) error {
+
+	if PublicKey == "" {
+		return nil
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(PublicKey)
+
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("the build's public key is not a base64 encoded ed25519 public key")
+	}
+
+	if metadata.Signature == "" {
+		return fmt.Errorf("version %s is unsigned", metadata.Version)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(metadata.Signature)
+
+	if err != nil {
+		return fmt.Errorf("version %s has an invalid signature: %w", metadata.Version, err)
+	}
+
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), content, signature) {
+		return fmt.Errorf("signature of file %s does not match version %s", path, metadata.Version)
+	}
+
+	return nil
+}
+
+// Finds another cached version with the executable in the metadata such as a
+// version which was republished without changes. Returns an empty string if
+// no cached version has the same hash.
+func findCachedExecutable(cacheDir string, metadata common.Metadata) string {
+
+	cachedVersions, err := listCachedVersions(cacheDir)
+
+	if err != nil {
+		return ""
+	}
+
+	for _, cached := range cachedVersions {
+		if cached.Size == metadata.Size && verifyFile(cached.Path, metadata) == nil {
+			return cached.Path
+		}
+	}
+
+	return ""
+}
+
+// Downloads the specified version of the tool by its hash if it doesn't
+// already exist on the filesystem. If another cached version has the same
+// hash, it's copied instead of downloaded. Either way, the file is verified
+// against the version's hash and, if the build has a public key, its
+// signature.
+func downloadUpdateVersion(cacheDir string, updateUrl string, version string, permissions fs.FileMode) (string, error) {
 
 	if version == "" {
 		return "", fmt.Errorf("version was empty")
 	}
 
-	// TODO maybe handle file name collisions with the temp files though
-	// they're extremely unlikely.
-	updateFilePath := filepath.Join(exeDir, fmt.Sprintf("%s-%s%s", POKEMON, version, exeSuffix()))
-	updateFile, err := os.Open(updateFilePath)
-	alreadyExists := err == nil
+	// Only one updater may download to the cache dir at a time. Others wait
+	// and then verify and reuse the downloaded file below.
+	lock, stalePid, err := acquireLock(filepath.Join(cacheDir, LOCK_FILE), LOCK_TIMEOUT_SECS*time.Second)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer lock.Release()
 
-	if alreadyExists {
-		defer updateFile.Close()
+	if stalePid != 0 {
+		output.Warn(EVENT_LOCK_RECOVERED, LockPayload{Lock: LOCK_FILE, Pid: stalePid}, messages.Get(MSG_UPDATER_LOCK_RECOVERED))
+
+		if _, err = removeTempFiles(cacheDir, 0); err != nil {
+			return "", err
+		}
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%s/v1.0/downloads/%s?version=%s", updateUrl, POKEMON, version))
+	// The metadata has the hash which verifies cached files and names the
+	// blob to download.
+	metadata, err := getVersionMetadata(updateUrl, version)
 
 	if err != nil {
 		return "", err
 	}
 
-	// Validate the file if it has already been downloaded.
-	if alreadyExists {
+	updateFilePath := cachedVersionPath(cacheDir, version)
 
-		sha512, err := common.Sha512Hash(updateFile)
+	// Validate the file if it has already been downloaded.
+	if _, err = os.Stat(updateFilePath); err == nil {
 
-		if err != nil {
+		if err = verifyFile(updateFilePath, metadata); err != nil {
 			return "", err
 		}
 
-		expectedSha512 := resp.Header.Get(common.Sha512Name)
-
-		if expectedSha512 != sha512 {
-			return "", common.NewSha512Error(updateFilePath, expectedSha512, sha512)
+		if err = verifySignature(updateFilePath, metadata); err != nil {
+			return "", err
 		}
 
 		// Update file already exists.
 		return updateFilePath, nil
 	}
 
-	defer resp.Body.Close()
+	var body io.Reader
+
+	if cachedPath := findCachedExecutable(cacheDir, metadata); cachedPath != "" {
+
+		cachedFile, err := os.Open(cachedPath)
+
+		if err != nil {
+			return "", err
+		}
+
+		defer cachedFile.Close()
+		body = cachedFile
+	} else {
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1.0/blobs/%s/%s", updateUrl, POKEMON, url.PathEscape(metadata.Sha512)), nil)
+
+		if err != nil {
+			return "", err
+		}
+
+		// Request gzip explicitly rather than relying on the transport so that
+		// the download is decompressed here while it's hashed.
+		req.Header.Set("Accept-Encoding", GZIP_ENCODING)
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			return "", err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", newHttpStatusError(resp)
+		}
+
+		body = resp.Body
+
+		if strings.EqualFold(resp.Header.Get("Content-Encoding"), GZIP_ENCODING) {
+
+			gzipReader, err := gzip.NewReader(resp.Body)
+
+			if err != nil {
+				return "", err
+			}
+
+			defer gzipReader.Close()
+			body = gzipReader
+		}
+	}
 
 	// Download to a temp file to attempt an atomic move on Unix systems.
 	// The temp file should be created in the same dir that the target file
 	// exists in. This prevents the file from being moved across
 	// filesystems.
-	updateFileTempPath := filepath.Join(exeDir, fmt.Sprintf(".%s-%s.%d.tmp", POKEMON, version, time.Now().UnixNano()))
-	if updateFile, err = os.OpenFile(updateFileTempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, permissions); err != nil {
+	updateFileTempPath := filepath.Join(cacheDir, fmt.Sprintf(".%s-%s.%d.tmp", POKEMON, version, time.Now().UnixNano()))
+	updateFile, err := os.OpenFile(updateFileTempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, permissions)
+
+	if err != nil {
 		return "", err
 	}
 
@@ -469,15 +1152,14 @@ func downloadUpdateVersion(exeDir string, updateUrl string, version string, perm
 
 	hasher := sha512.New()
 
-	if _, err = io.Copy(io.MultiWriter(hasher, updateFile), resp.Body); err != nil {
+	// The hash is of the decompressed executable, so it's verified no matter
+	// how the executable was transferred.
+	if _, err = io.Copy(io.MultiWriter(hasher, updateFile), body); err != nil {
 		return "", err
 	}
 
-	sha512 := common.ToHexHash(&hasher)
-	expectedSha512 := resp.Header.Get(common.Sha512Name)
-
-	if expectedSha512 != sha512 {
-		return "", common.NewSha512Error(updateFilePath, expectedSha512, sha512)
+	if sha512 := common.ToHexHash(&hasher); metadata.Sha512 != sha512 {
+		return "", common.NewSha512Error(updateFilePath, metadata.Sha512, sha512)
 	}
 
 	if err = updateFile.Sync(); err != nil {
@@ -490,6 +1172,12 @@ func downloadUpdateVersion(exeDir string, updateUrl string, version string, perm
 		return "", err
 	}
 
+	// The signature is checked before the move so that an unsigned executable
+	// is never cached.
+	if err = verifySignature(updateFileTempPath, metadata); err != nil {
+		return "", err
+	}
+
 	// Attempt atomic move.
 	if err = os.Rename(updateFileTempPath, updateFilePath); err != nil {
 		return "", err
@@ -525,29 +1213,44 @@ func upgradeChildProcess(previousChild Cmd, updateFilePath string, version strin
 		}
 
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to shutdown process gracefully:\n%v\n", err)
+			output.Warn(EVENT_ERROR, newErrorPayload(previousChild.Version, err), messages.Get(MSG_UPDATER_SHUTDOWN_FAILED))
 		}
 
-		fmt.Fprintf(os.Stderr, "Shutting down %s.\n", previousChild.Version)
+		output.Info(EVENT_SHUTDOWN, VersionPayload{Version: previousChild.Version}, messages.Get(MSG_UPDATER_SHUTTING_DOWN))
 
 		// If the previous process hasn't already shut down, force it to shut
 		// down.
 		kill(previousChild.Cmd)
 	}
 
-	// Create the new process.
-	cmd := exec.Command(updateFilePath, os.Args[1:]...)
+	var cmd *exec.Cmd
+	var stdin io.WriteCloser
+	var err error
 
-	stdin, err := cmd.StdinPipe()
+	for range 3 {
 
-	if err != nil {
-		return Cmd{}, err
-	}
+		// Create the new process.
+		cmd = exec.Command(updateFilePath, os.Args[1:]...)
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+		stdin, err = cmd.StdinPipe()
+
+		if err != nil {
+			return Cmd{}, err
+		}
 
-	err = cmd.Start()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		err = cmd.Start()
+
+		// Linux fails to start a file which was just written while another
+		// process still has it open for writing, so retry.
+		if !errors.Is(err, syscall.ETXTBSY) {
+			break
+		}
+
+		time.Sleep(time.Duration(SHORT_TIMEOUT_SECS) * time.Second)
+	}
 
 	if err != nil {
 		return Cmd{}, err
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// Starts a fake update server which serves the content of each version.
+func newFakeUpdateServer(t *testing.T, versions map[string]string) *httptest.Server {
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		version := r.URL.Query().Get("version")
+		content, exists := versions[version]
+		hash := sha512.Sum512([]byte(content))
+
+		switch r.URL.Path {
+		case "/v1.0/versions/pokemon":
+			all := slices.Collect(maps.Keys(versions))
+			sort.Strings(all)
+			json.NewEncoder(w).Encode(common.Versions{All: all})
+		case "/v1.0/metadata/pokemon":
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			json.NewEncoder(w).Encode(common.Metadata{
+				Version: version,
+				Sha512:  hex.EncodeToString(hash[:]),
+				Size:    int64(len(content)),
+			})
+		default:
+			blob, found := strings.CutPrefix(r.URL.Path, "/v1.0/blobs/pokemon/")
+
+			for _, content := range versions {
+				if hash := sha512.Sum512([]byte(content)); found && hex.EncodeToString(hash[:]) == blob {
+					w.Write([]byte(content))
+					return
+				}
+			}
+
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+func TestGetLatestVersionSendsConditionalRequests(t *testing.T) {
+
+	requests := 0
+	versionsJson := "{\"versions\":[\"1.0.0\",\"2.0.0\"]}"
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		w.Header().Set("ETag", "\"v1\"")
+
+		if r.Header.Get("If-None-Match") == "\"v1\"" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Write([]byte(versionsJson))
+	}))
+	defer httpServer.Close()
+
+	server := &UpdateServer{Url: httpServer.URL}
+
+	for range 2 {
+		version, err := getLatestVersion(server)
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if version != "2.0.0" {
+			t.Errorf("Expected %s but found %s", "2.0.0", version)
+		}
+	}
+
+	if requests != 2 || server.ETag != "\"v1\"" {
+		t.Errorf("Expected 2 requests with ETag \"v1\" but found %d with %s", requests, server.ETag)
+	}
+}
+
+func TestDownloadUpdateVersionVerifiesExistingFileWithMetadata(t *testing.T) {
+
+	content := []byte("pokemon 2.0.0")
+	hash := sha512.Sum512(content)
+	metadata := common.Metadata{
+		Version: "2.0.0",
+		Sha512:  hex.EncodeToString(hash[:]),
+		Size:    int64(len(content)),
+	}
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0/metadata/pokemon":
+			json.NewEncoder(w).Encode(metadata)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer httpServer.Close()
+
+	exeDir := t.TempDir()
+	existingPath := filepath.Join(exeDir, fmt.Sprintf("%s-%s%s", POKEMON, "2.0.0", exeSuffix()))
+
+	if err := os.WriteFile(existingPath, content, 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	path, err := downloadUpdateVersion(exeDir, httpServer.URL, "2.0.0", 0o755)
+
+	if err != nil || path != existingPath {
+		t.Errorf("Expected %s but found %s: %v", existingPath, path, err)
+	}
+
+	metadata.Size += 1
+
+	if _, err := downloadUpdateVersion(exeDir, httpServer.URL, "2.0.0", 0o755); err == nil {
+		t.Errorf("Expected size mismatch error")
+	}
+}
+
+func TestDownloadUpdateVersionDecompressesGzip(t *testing.T) {
+
+	content := []byte("pokemon 2.0.0 pokemon 2.0.0 pokemon 2.0.0")
+	hash := sha512.Sum512(content)
+	served := content
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path == "/v1.0/metadata/pokemon" {
+			json.NewEncoder(w).Encode(common.Metadata{Version: "2.0.0", Sha512: hex.EncodeToString(hash[:]), Size: int64(len(content))})
+			return
+		}
+
+		if r.URL.Path != "/v1.0/blobs/pokemon/"+hex.EncodeToString(hash[:]) {
+			t.Errorf("Unexpected request to %s", r.URL)
+		}
+
+		if r.Header.Get("Accept-Encoding") != GZIP_ENCODING {
+			t.Errorf("Expected Accept-Encoding %s but found %s", GZIP_ENCODING, r.Header.Get("Accept-Encoding"))
+		}
+
+		w.Header().Add("Content-Encoding", GZIP_ENCODING)
+		writer := gzip.NewWriter(w)
+		writer.Write(served)
+		writer.Close()
+	}))
+	defer httpServer.Close()
+
+	path, err := downloadUpdateVersion(t.TempDir(), httpServer.URL, "2.0.0", 0o755)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if downloaded, err := os.ReadFile(path); err != nil || !bytes.Equal(downloaded, content) {
+		t.Errorf("Expected the decompressed executable but found %q: %v", downloaded, err)
+	}
+
+	// The hash is verified after decompressing.
+	served = []byte("corrupt")
+
+	if _, err := downloadUpdateVersion(t.TempDir(), httpServer.URL, "2.0.0", 0o755); err == nil {
+		t.Errorf("Expected hash mismatch error")
+	}
+}
+
+func TestDownloadUpdateVersionReusesCachedExecutableWithHash(t *testing.T) {
+
+	// 3.0.0 was republished with the executable of 2.0.0.
+	httpServer := newFakeUpdateServer(t, map[string]string{"2.0.0": "2.0.0", "3.0.0": "2.0.0"})
+	cacheDir := t.TempDir()
+
+	if _, err := downloadUpdateVersion(cacheDir, httpServer.URL, "2.0.0", 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The server can't serve blobs anymore, so the executable must be copied
+	// from the cache.
+	httpServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.HasPrefix(r.URL.Path, "/v1.0/blobs/") {
+			t.Errorf("Unexpected download of %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		hash := sha512.Sum512([]byte("2.0.0"))
+		json.NewEncoder(w).Encode(common.Metadata{Version: "3.0.0", Sha512: hex.EncodeToString(hash[:]), Size: int64(len("2.0.0"))})
+	})
+
+	path, err := downloadUpdateVersion(cacheDir, httpServer.URL, "3.0.0", 0o755)
+
+	if err != nil || path != cachedVersionPath(cacheDir, "3.0.0") {
+		t.Fatalf("Expected %s but found %s: %v", cachedVersionPath(cacheDir, "3.0.0"), path, err)
+	}
+
+	if content, err := os.ReadFile(path); err != nil || string(content) != "2.0.0" {
+		t.Errorf("Expected the executable of 2.0.0 but found %s: %v", content, err)
+	}
+}
+
+func TestDownloadUpdateVersionVerifiesSignature(t *testing.T) {
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	previousPublicKey := PublicKey
+	PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	t.Cleanup(func() { PublicKey = previousPublicKey })
+
+	content := []byte("pokemon 2.0.0")
+	hash := sha512.Sum512(content)
+	metadata := common.Metadata{
+		Version:   "2.0.0",
+		Sha512:    hex.EncodeToString(hash[:]),
+		Size:      int64(len(content)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, content)),
+	}
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path == "/v1.0/metadata/pokemon" {
+			json.NewEncoder(w).Encode(metadata)
+			return
+		}
+
+		w.Write(content)
+	}))
+	defer httpServer.Close()
+
+	cacheDir := t.TempDir()
+
+	if _, err := downloadUpdateVersion(cacheDir, httpServer.URL, "2.0.0", 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Unsigned versions and versions signed by other keys aren't installed
+	// whether they're cached or downloaded.
+	for _, signature := range []string{"", base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, content))} {
+		metadata.Signature = signature
+
+		if _, err := downloadUpdateVersion(cacheDir, httpServer.URL, "2.0.0", 0o755); err == nil {
+			t.Errorf("Expected an error for the cached version with signature \"%s\"", signature)
+		}
+
+		downloadDir := t.TempDir()
+
+		if _, err := downloadUpdateVersion(downloadDir, httpServer.URL, "2.0.0", 0o755); err == nil {
+			t.Errorf("Expected an error for the downloaded version with signature \"%s\"", signature)
+		}
+
+		if _, err := os.Stat(cachedVersionPath(downloadDir, "2.0.0")); !os.IsNotExist(err) {
+			t.Errorf("Expected the version with signature \"%s\" not to be cached: %v", signature, err)
+		}
+	}
+}
+
+func TestUpdateLoopStartsDaemonBeforeSplay(t *testing.T) {
+
+	if !common.IsPosix() {
+		t.Skip("The fake executable is a shell script")
+	}
+
+	dir := t.TempDir()
+	started := filepath.Join(dir, "started")
+	exe := filepath.Join(dir, POKEMON)
+
+	if err := os.WriteFile(exe, []byte(fmt.Sprintf("#!/bin/sh\necho started > '%s'\n", started)), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var requests atomic.Int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer httpServer.Close()
+
+	// Restore the env variable which the update loop sets for children.
+	t.Setenv(POKEMON_CLI, "")
+
+	// The loop never returns in daemon mode. The maximum interval makes the
+	// splay before the first check last far longer than the test.
+	go updateLoop(exe, filepath.Join(dir, "cache"), CachePolicy{}, 0o755, true, "1.0.0", httpServer.URL, "", math.MaxUint16)
+
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+	}
+
+	assertFileContent(t, started, "started\n")
+
+	if requests.Load() != 0 {
+		t.Errorf("Expected the daemon to start before checking for updates but found %d requests", requests.Load())
+	}
+}
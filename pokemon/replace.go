@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Suffix of the backup of the installed executable kept after it is replaced.
+const BACKUP_SUFFIX = ".old"
+
+func backupPath(exe string) string {
+	return exe + BACKUP_SUFFIX
+}
+
+// Copies src to a new temp file next to exe so that it can be moved over exe
+// without crossing filesystems.
+func copyNextTo(exe string, src string, permissions fs.FileMode) (string, error) {
+
+	srcFile, err := os.Open(src)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer srcFile.Close()
+
+	tempPath := filepath.Join(filepath.Dir(exe), fmt.Sprintf(".%s.%d.new", filepath.Base(exe), time.Now().UnixNano()))
+	tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, permissions)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(tempFile, srcFile)
+
+	if err == nil {
+		err = tempFile.Sync()
+	}
+
+	// Close the file before it is executed, otherwise Linux fails to start it
+	// with "text file busy".
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	// The umask may have removed some permissions when the file was created.
+	if err == nil {
+		err = os.Chmod(tempPath, permissions)
+	}
+
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+
+	return tempPath, nil
+}
+
+// Replaces the installed executable with the update file, keeping the
+// installed executable as a backup for rollback. The executable is never
+// written in place since Linux refuses to write to a running executable with
+// "text file busy". Instead the update is copied next to the executable and
+// moved over it, which is atomic on POSIX systems.
+func replaceExecutable(exe string, updateFilePath string, permissions fs.FileMode) error {
+
+	tempPath, err := copyNextTo(exe, updateFilePath, permissions)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tempPath)
+
+	backup := backupPath(exe)
+
+	if err = os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+
+		// Windows doesn't allow replacing a running executable, but it does
+		// allow renaming it.
+		if err = os.Rename(exe, backup); err != nil {
+			return err
+		}
+
+		if err = os.Rename(tempPath, exe); err != nil {
+			os.Rename(backup, exe)
+			return err
+		}
+
+		return nil
+	}
+
+	// Link the backup so that the executable exists at every point during
+	// the replacement.
+	if err = os.Link(exe, backup); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, exe)
+}
+
+// Restores the backup of the executable kept by replaceExecutable.
+func restoreExecutable(exe string) error {
+
+	backup := backupPath(exe)
+
+	if _, err := os.Stat(backup); err != nil {
+		return fmt.Errorf("no backup of \"%s\" to restore:\n%v", exe, err)
+	}
+
+	if runtime.GOOS == "windows" {
+
+		// The running executable can't be replaced, so move it out of the
+		// way first.
+		replaced := fmt.Sprintf("%s.%d.replaced", exe, time.Now().UnixNano())
+
+		if err := os.Rename(exe, replaced); err != nil {
+			return err
+		}
+
+		if err := os.Rename(backup, exe); err != nil {
+			os.Rename(replaced, exe)
+			return err
+		}
+
+		os.Remove(replaced)
+		return nil
+	}
+
+	return os.Rename(backup, exe)
+}
+
+// Checks for an update and replaces the installed executable with it so that
+// future invocations run the update directly without an updater process. If
+// the executable was replaced, the update is run for this invocation and the
+// process exits with its exit code. Otherwise this returns so that the
+// installed version can run directly.
+func selfReplace(exe string, cacheDir string, cachePolicy CachePolicy, exePermissions fs.FileMode, installedVersion string, updateUrl string, channel string) error {
+
+	output.Debug(EVENT_UPDATE_CHECK, VersionPayload{Version: installedVersion}, messages.Get(MSG_UPDATER_CHECKING))
+
+	version, err := getLatestVersion(newUpdateServer(updateUrl, channel, cacheDir, installedVersion))
+
+	if err != nil {
+		return err
+	}
+
+	// The installed version was chosen with `pokemon update apply VERSION` or
+	// `pokemon update rollback`.
+	if version == installedVersion || pinnedVersion(cacheDir) != "" {
+		return nil
+	}
+
+	if err = os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+
+	updateFilePath, err := downloadUpdateVersion(cacheDir, updateUrl, version, exePermissions)
+
+	if err != nil {
+		return err
+	}
+
+	if err = replaceExecutable(exe, updateFilePath, exePermissions); err != nil {
+		return err
+	}
+
+	// Run the update for this invocation without updating again.
+	if err = os.Setenv(POKEMON_CLI, "TRUE"); err != nil {
+		return err
+	}
+
+	cmd, err := upgradeChildProcess(Cmd{}, exe, version)
+
+	if err != nil {
+
+		if restoreErr := restoreExecutable(exe); restoreErr != nil {
+			output.Error(EVENT_ERROR, newErrorPayload(installedVersion, restoreErr), messages.Get(MSG_UPDATER_RESTORE_FAILED, exe))
+		}
+
+		return fmt.Errorf("failed to start updated \"%s\" so it was rolled back to %s:\n%v", exe, installedVersion, err)
+	}
+
+	output.Info(EVENT_UPDATED, UpdatePayload{From: installedVersion, To: version}, messages.Get(MSG_UPDATER_UPDATED))
+	updateCache(cacheDir, cachePolicy, cmd, Cmd{Version: installedVersion}, false)
+
+	cmd.Cmd.Wait()
+	os.Exit(cmd.Cmd.ProcessState.ExitCode())
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func assertFileContent(t *testing.T, path string, expected string) {
+
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if string(content) != expected {
+		t.Errorf("Expected \"%s\" to contain %s but found %s", path, expected, content)
+	}
+}
+
+func TestReplaceAndRestoreExecutable(t *testing.T) {
+
+	dir := t.TempDir()
+	exe := filepath.Join(dir, POKEMON)
+	updateFilePath := filepath.Join(dir, "update")
+
+	if err := os.WriteFile(exe, []byte("1.0.0"), 0o750); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Replace twice with different versions to ensure an existing backup is
+	// overwritten.
+	for _, versions := range [][2]string{{"2.0.0", "1.0.0"}, {"3.0.0", "2.0.0"}} {
+
+		if err := os.WriteFile(updateFilePath, []byte(versions[0]), 0o644); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if err := replaceExecutable(exe, updateFilePath, 0o750); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		assertFileContent(t, exe, versions[0])
+		assertFileContent(t, backupPath(exe), versions[1])
+	}
+
+	if info, err := os.Stat(exe); err != nil || (common.IsPosix() && info.Mode().Perm() != 0o750) {
+		t.Errorf("Expected permissions %v to be preserved: %v", os.FileMode(0o750), err)
+	}
+
+	if err := restoreExecutable(exe); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The restore must replace 3.0.0 with the backup of 2.0.0.
+	assertFileContent(t, exe, "2.0.0")
+
+	if err := restoreExecutable(exe); err == nil {
+		t.Errorf("Expected error restoring without a backup")
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected temp files to be removed but found %v", entries)
+	}
+}
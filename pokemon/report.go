@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The most time spent sending a report so that a slow server never holds up
+// the updater.
+const REPORT_TIMEOUT_SECS = 5
+
+// Sends the outcome of an update to the server.
+func postReport(server *UpdateServer, report common.Report) error {
+
+	reportJson, err := json.Marshal(report)
+
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: time.Duration(REPORT_TIMEOUT_SECS) * time.Second}
+	resp, err := client.Post(fmt.Sprintf("%s/v1.0/reports/%s", server.Url, POKEMON), "application/json", bytes.NewReader(reportJson))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusAccepted {
+		return newHttpStatusError(resp)
+	}
+
+	return nil
+}
+
+// Reports the outcome of an update to the server in the background. Reports
+// are best-effort, so failures are only logged and never retried.
+func sendReport(server *UpdateServer, stage string, from string, to string, err error) {
+
+	report := common.Report{
+		ClientId: server.ClientId,
+		Platform: clientPlatform(),
+		From:     from,
+		To:       to,
+		Stage:    stage,
+	}
+
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	go func() {
+		if err := postReport(server, report); err != nil {
+			output.Debug(EVENT_REPORT_FAILED, newErrorPayload(to, err), messages.Get(MSG_UPDATER_REPORT_FAILED))
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func TestPostReport(t *testing.T) {
+
+	var received common.Report
+	status := http.StatusAccepted
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" || r.URL.Path != "/v1.0/reports/pokemon" {
+			t.Errorf("Expected POST /v1.0/reports/pokemon but found %s %s", r.Method, r.URL.Path)
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("%v", err)
+		}
+
+		w.WriteHeader(status)
+	}))
+	defer httpServer.Close()
+
+	server := &UpdateServer{Url: httpServer.URL, ClientId: "0123456789abcdef0123456789abcdef"}
+	report := common.Report{
+		ClientId: server.ClientId,
+		Platform: clientPlatform(),
+		From:     "1.0.0",
+		To:       "2.0.0",
+		Stage:    common.ReportStageStart,
+		Error:    "exec format error",
+	}
+
+	if err := postReport(server, report); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if received != report {
+		t.Errorf("Expected %v but found %v", report, received)
+	}
+
+	status = http.StatusInternalServerError
+
+	if err := postReport(server, report); err == nil {
+		t.Errorf("Expected an error when the server fails")
+	}
+}
@@ -0,0 +1,6 @@
+module github.com/stiemannkj1/auto-update-example/publish
+
+go 1.24
+
+require github.com/stiemannkj1/auto-update-example/common v0.0.1-00000000000000-000000000000
+replace github.com/stiemannkj1/auto-update-example/common => ../
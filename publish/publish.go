@@ -0,0 +1,656 @@
+// Command which builds, signs, and publishes releases of the Pokemon CLI either
+// into the server's version dir or through the server's admin API.
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+const Pokemon string = "pokemon"
+
+// The PEM block types of signing keys.
+const PrivateKeyType string = "PRIVATE KEY"
+const PublicKeyType string = "PUBLIC KEY"
+
+// Suffix of the public key written next to a generated signing key.
+const PublicKeySuffix string = ".pub"
+
+// The file in a version dir of the server's blob layout which names the
+// version's executable by its Sha-512.
+const ManifestFileName string = "manifest.json"
+
+type Manifest struct {
+	Sha512 string `json:"sha512"`
+}
+
+// Versions which are already published with different content.
+var errVersionExists = errors.New("the version already exists with different content")
+
+func printUsage(flags []common.CliFlag) {
+	fmt.Fprintf(os.Stderr, "Usage: publish --version 1.0.0 (--version-dir DIR | --server-url URL --admin-token-file FILE) [options]\n\tBuild, sign, and publish a version of the pokemon cli tool\n")
+	fmt.Fprintf(os.Stderr, "Usage: publish keygen --signing-key FILE\n\tGenerate an ed25519 signing key and write its public key to FILE%s\n", PublicKeySuffix)
+
+	for _, flag := range flags {
+		fmt.Fprintf(os.Stderr, "%s, %s\n\t%s\n", flag.Name, flag.Short, flag.Description)
+	}
+}
+
+// A target to build for such as linux/amd64
+type Platform struct {
+	Os   string
+	Arch string
+}
+
+func (p Platform) String() string {
+	return p.Os + "/" + p.Arch
+}
+
+// Parses comma-separated platforms such as "linux/amd64,darwin/arm64".
+func parsePlatforms(platforms string) ([]Platform, error) {
+
+	parsed := make([]Platform, 0)
+
+	for _, platform := range strings.Split(platforms, ",") {
+
+		goos, goarch, found := strings.Cut(strings.TrimSpace(platform), "/")
+
+		if !found || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+			return nil, fmt.Errorf("platform \"%s\" must be GOOS/GOARCH such as linux/amd64", platform)
+		}
+
+		parsed = append(parsed, Platform{Os: goos, Arch: goarch})
+	}
+
+	return parsed, nil
+}
+
+type Options struct {
+	Version   string
+	UpdateUrl string
+	Platforms []Platform
+	// The pokemon package to build
+	Source string
+	// The server's version dir to publish into or empty to upload instead
+	VersionDir string
+	// The server to upload to or empty to publish into VersionDir instead
+	ServerUrl  string
+	AdminToken string
+	// The key to sign executables with or nil to leave them unsigned
+	SigningKey ed25519.PrivateKey
+}
+
+// Gets the dir to publish a platform's versions into. Each platform gets its
+// own version dir when publishing for several platforms since the server
+// serves a single executable for each version.
+func (options *Options) versionDirFor(platform Platform) string {
+
+	if len(options.Platforms) == 1 {
+		return options.VersionDir
+	}
+
+	return filepath.Join(options.VersionDir, platform.Os+"-"+platform.Arch)
+}
+
+// A built executable ready to publish.
+type Artifact struct {
+	Platform Platform
+	Path     string
+	Sha512   string
+	// The detached signature or nil if the executable is unsigned
+	Signature []byte
+}
+
+// Gets the -ldflags which inject the version, update URL, and public key into
+// the CLI. The CLI only installs updates signed by the public key's signing
+// key.
+func ldflags(version string, updateUrl string, publicKey ed25519.PublicKey) string {
+
+	flags := fmt.Sprintf("-X 'main.Version=%s'", version)
+
+	if updateUrl != "" {
+		flags += fmt.Sprintf(" -X 'main.UpdateUrl=%s'", updateUrl)
+	}
+
+	if publicKey != nil {
+		flags += fmt.Sprintf(" -X 'main.PublicKey=%s'", base64.StdEncoding.EncodeToString(publicKey))
+	}
+
+	return flags
+}
+
+// Builds the CLI for the platform into the dir, then hashes and signs it.
+func buildArtifact(options *Options, platform Platform, dir string) (Artifact, error) {
+
+	artifact := Artifact{
+		Platform: platform,
+		Path:     filepath.Join(dir, platform.Os+"-"+platform.Arch, Pokemon),
+	}
+
+	// Trim paths so that rebuilding the same source builds the same
+	// executable.
+	var publicKey ed25519.PublicKey
+
+	if options.SigningKey != nil {
+		publicKey = options.SigningKey.Public().(ed25519.PublicKey)
+	}
+
+	cmd := exec.Command("go", "build", "-trimpath", "-ldflags", ldflags(options.Version, options.UpdateUrl, publicKey), "-o", artifact.Path, options.Source)
+	cmd.Env = append(os.Environ(), "GOOS="+platform.Os, "GOARCH="+platform.Arch, "CGO_ENABLED=0")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return Artifact{}, fmt.Errorf("failed to build %s:\n%v", platform, err)
+	}
+
+	return signArtifact(artifact, options.SigningKey)
+}
+
+// Hashes the artifact and signs it if there's a signing key.
+func signArtifact(artifact Artifact, key ed25519.PrivateKey) (Artifact, error) {
+
+	content, err := os.ReadFile(artifact.Path)
+
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	artifact.Sha512, err = common.Sha512Hash(bytes.NewReader(content))
+
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	if key != nil {
+		artifact.Signature = ed25519.Sign(key, content)
+	}
+
+	return artifact, nil
+}
+
+// Reads an ed25519 private key from a PEM file.
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+
+	if block == nil || block.Type != PrivateKeyType {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded %s", path, PrivateKeyType)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, isEd25519 := key.(ed25519.PrivateKey)
+
+	if !isEd25519 {
+		return nil, fmt.Errorf("%s does not contain an ed25519 key", path)
+	}
+
+	return signingKey, nil
+}
+
+// Generates an ed25519 signing key and writes it to the path and its public
+// key to the path with PublicKeySuffix. Existing keys are never overwritten.
+func generateSigningKey(path string) error {
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+
+	if err != nil {
+		return err
+	}
+
+	publicDer, err := x509.MarshalPKIXPublicKey(publicKey)
+
+	if err != nil {
+		return err
+	}
+
+	keys := []struct {
+		path        string
+		block       pem.Block
+		permissions os.FileMode
+	}{
+		{path, pem.Block{Type: PrivateKeyType, Bytes: privateDer}, 0o600},
+		{path + PublicKeySuffix, pem.Block{Type: PublicKeyType, Bytes: publicDer}, 0o644},
+	}
+
+	for _, key := range keys {
+
+		file, err := os.OpenFile(key.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, key.permissions)
+
+		if err != nil {
+			return err
+		}
+
+		err = pem.Encode(file, &key.block)
+
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns an error if the version dir already has the version with different
+// content. Versions migrated to the blob layout are checked by the hash in
+// their manifest. Returns true if the version needs to be published.
+func checkVersionDir(versionDir string, version string, artifact Artifact) (bool, error) {
+
+	manifestPath := filepath.Join(versionDir, version, ManifestFileName)
+	manifestJson, err := os.ReadFile(manifestPath)
+
+	if err == nil {
+
+		var manifest Manifest
+
+		if err = json.Unmarshal(manifestJson, &manifest); err != nil {
+			return false, fmt.Errorf("failed to parse %s:\n%w", manifestPath, err)
+		}
+
+		if manifest.Sha512 != artifact.Sha512 {
+			return false, fmt.Errorf("%w: %s has Sha-512 %s, but the build has %s", errVersionExists, manifestPath, manifest.Sha512, artifact.Sha512)
+		}
+
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	file, err := os.Open(filepath.Join(versionDir, version, Pokemon))
+
+	if err != nil && os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	sha512, err := common.Sha512Hash(file)
+	file.Close()
+
+	if err != nil {
+		return false, err
+	}
+
+	if sha512 != artifact.Sha512 {
+		return false, fmt.Errorf("%w: %s has Sha-512 %s, but the build has %s", errVersionExists, file.Name(), sha512, artifact.Sha512)
+	}
+
+	return false, nil
+}
+
+// Lays out the artifact in the server's version dir format:
+// .
+// └── 1.0.0/
+//
+//	├── pokemon
+//	└── pokemon.sig
+//
+// The executable is moved into place after its signature so that the server
+// never finds it unsigned. Returns false if the version was already
+// published with the same content.
+func publishToDir(versionDir string, version string, artifact Artifact) (bool, error) {
+
+	publish, err := checkVersionDir(versionDir, version, artifact)
+
+	if !publish || err != nil {
+		return false, err
+	}
+
+	dir := filepath.Join(versionDir, version)
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return false, err
+	}
+
+	path := filepath.Join(dir, Pokemon)
+
+	if artifact.Signature != nil {
+		if err = common.CopyFileAtomic(path+common.SignatureSuffix, bytes.NewReader(artifact.Signature), 0o644); err != nil {
+			return false, err
+		}
+	}
+
+	executable, err := os.Open(artifact.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer executable.Close()
+
+	return true, common.CopyFileAtomic(path, executable, 0o755)
+}
+
+// Uploads the artifact through the server's admin API which verifies its
+// hash. Returns false if the version was already published with the same
+// content.
+func uploadArtifact(serverUrl string, adminToken string, version string, artifact Artifact) (bool, error) {
+
+	executable, err := os.Open(artifact.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer executable.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/v1.0/admin/versions/%s?version=%s", serverUrl, Pokemon, url.QueryEscape(version)), executable)
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(common.Sha512Name, artifact.Sha512)
+
+	if artifact.Signature != nil {
+		req.Header.Set(common.SignatureName, base64.StdEncoding.EncodeToString(artifact.Signature))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	case http.StatusConflict:
+		return false, errVersionExists
+	default:
+		return false, fmt.Errorf("upload to %s failed with %s:\n%s", serverUrl, resp.Status, body)
+	}
+}
+
+func main() {
+
+	// Define CLI args:
+	helpFlag := common.CliFlag{
+		Name:        "--help",
+		Short:       "-h",
+		Description: "Print this help message",
+	}
+
+	versionFlag := common.CliFlag{
+		Name:        "--version",
+		Short:       "-v",
+		Description: "The version to publish such as 1.0.0",
+	}
+
+	updateUrlFlag := common.CliFlag{
+		Name:        "--update-url",
+		Short:       "-u",
+		Description: "The URL the CLI checks for updates. Defaults to the URL embedded in the CLI's defaults.properties",
+	}
+
+	platformsFlag := common.CliFlag{
+		Name:        "--platforms",
+		Short:       "-p",
+		Description: fmt.Sprintf("Comma-separated GOOS/GOARCH platforms to build for. Defaults to %s/%s. With several platforms, each platform is published into its own GOOS-GOARCH dir in the version dir", runtime.GOOS, runtime.GOARCH),
+	}
+
+	sourceFlag := common.CliFlag{
+		Name:        "--source",
+		Short:       "-S",
+		Description: "The pokemon package to build. Defaults to ./pokemon",
+	}
+
+	versionDirFlag := common.CliFlag{
+		Name:        "--version-dir",
+		Short:       "-d",
+		Description: "The server's PokemonVersionDir to publish into",
+	}
+
+	serverUrlFlag := common.CliFlag{
+		Name:        "--server-url",
+		Short:       "-s",
+		Description: "The server to upload to through its admin API instead of publishing into a version dir",
+	}
+
+	adminTokenFileFlag := common.CliFlag{
+		Name:        "--admin-token-file",
+		Short:       "-t",
+		Description: "The file containing the server's admin token for uploads",
+	}
+
+	signingKeyFlag := common.CliFlag{
+		Name:        "--signing-key",
+		Short:       "-k",
+		Description: "The PEM encoded ed25519 private key to sign executables with. Executables are unsigned without it",
+	}
+
+	flags := []common.CliFlag{helpFlag, versionFlag, updateUrlFlag, platformsFlag, sourceFlag, versionDirFlag, serverUrlFlag, adminTokenFileFlag, signingKeyFlag}
+
+	options := Options{Source: "./pokemon"}
+	platforms := fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+	adminTokenFile := ""
+	signingKeyFile := ""
+	keygen := false
+
+	args := os.Args
+
+	flagValue := func(flag common.CliFlag, i int) string {
+
+		if i+1 >= len(args) {
+			fmt.Fprintf(os.Stderr, "Missing value for %s\n\n", flag.Name)
+			printUsage(flags)
+			os.Exit(64)
+		}
+
+		return args[i+1]
+	}
+
+	// Parse CLI Args:
+	for i := 1; i < len(args); i += 1 {
+		switch args[i] {
+		case helpFlag.Name, helpFlag.Short:
+			printUsage(flags)
+			return
+		case versionFlag.Name, versionFlag.Short:
+			options.Version = flagValue(versionFlag, i)
+			i += 1
+		case updateUrlFlag.Name, updateUrlFlag.Short:
+			options.UpdateUrl = flagValue(updateUrlFlag, i)
+			i += 1
+		case platformsFlag.Name, platformsFlag.Short:
+			platforms = flagValue(platformsFlag, i)
+			i += 1
+		case sourceFlag.Name, sourceFlag.Short:
+			options.Source = flagValue(sourceFlag, i)
+			i += 1
+		case versionDirFlag.Name, versionDirFlag.Short:
+			options.VersionDir = flagValue(versionDirFlag, i)
+			i += 1
+		case serverUrlFlag.Name, serverUrlFlag.Short:
+			options.ServerUrl = strings.TrimSuffix(flagValue(serverUrlFlag, i), "/")
+			i += 1
+		case adminTokenFileFlag.Name, adminTokenFileFlag.Short:
+			adminTokenFile = flagValue(adminTokenFileFlag, i)
+			i += 1
+		case signingKeyFlag.Name, signingKeyFlag.Short:
+			signingKeyFile = flagValue(signingKeyFlag, i)
+			i += 1
+		case "keygen":
+			keygen = i == 1
+			if keygen {
+				break
+			}
+			fallthrough
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown argument \"%s\"\n\n", args[i])
+			printUsage(flags)
+			os.Exit(64)
+		}
+	}
+
+	if keygen {
+
+		if signingKeyFile == "" {
+			fmt.Fprintf(os.Stderr, "keygen requires %s\n\n", signingKeyFlag.Name)
+			printUsage(flags)
+			os.Exit(64)
+		}
+
+		if err := generateSigningKey(signingKeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate signing key \"%s\":\n%v\n", signingKeyFile, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Wrote signing key %s and public key %s%s\n", signingKeyFile, signingKeyFile, PublicKeySuffix)
+		return
+	}
+
+	if _, err := common.ParseSemVer(options.Version); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid version \"%s\":\n%v\n\n", options.Version, err)
+		printUsage(flags)
+		os.Exit(64)
+	}
+
+	parsedPlatforms, err := parsePlatforms(platforms)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid platforms:\n%v\n\n", err)
+		printUsage(flags)
+		os.Exit(64)
+	}
+
+	options.Platforms = parsedPlatforms
+
+	if (options.VersionDir == "") == (options.ServerUrl == "") {
+		fmt.Fprintf(os.Stderr, "Specify either %s or %s\n\n", versionDirFlag.Name, serverUrlFlag.Name)
+		printUsage(flags)
+		os.Exit(64)
+	}
+
+	if options.ServerUrl != "" {
+
+		// The server serves a single executable for each version.
+		if len(options.Platforms) != 1 {
+			fmt.Fprintf(os.Stderr, "%s only supports a single platform\n\n", serverUrlFlag.Name)
+			printUsage(flags)
+			os.Exit(64)
+		}
+
+		token, err := os.ReadFile(adminTokenFile)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read admin token from \"%s\":\n%v\n\n", adminTokenFile, err)
+			printUsage(flags)
+			os.Exit(64)
+		}
+
+		options.AdminToken = strings.TrimSpace(string(token))
+	}
+
+	if signingKeyFile != "" {
+
+		options.SigningKey, err = readSigningKey(signingKeyFile)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read signing key \"%s\":\n%v\n\n", signingKeyFile, err)
+			os.Exit(1)
+		}
+	}
+
+	buildDir, err := os.MkdirTemp("", "pokemon-publish-")
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create build dir:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	defer os.RemoveAll(buildDir)
+
+	// Build and check every platform before publishing any so that a
+	// conflict doesn't leave the version published for only some platforms.
+	artifacts := make([]Artifact, 0, len(options.Platforms))
+
+	for _, platform := range options.Platforms {
+
+		artifact, err := buildArtifact(&options, platform, buildDir)
+
+		if err == nil && options.VersionDir != "" {
+			_, err = checkVersionDir(options.versionDirFor(platform), options.Version, artifact)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to publish %s for %s:\n%v\n", options.Version, platform, err)
+			os.RemoveAll(buildDir)
+			os.Exit(1)
+		}
+
+		artifacts = append(artifacts, artifact)
+	}
+
+	for _, artifact := range artifacts {
+
+		var published bool
+		destination := options.ServerUrl
+
+		if options.ServerUrl != "" {
+			published, err = uploadArtifact(options.ServerUrl, options.AdminToken, options.Version, artifact)
+		} else {
+			destination = filepath.Join(options.versionDirFor(artifact.Platform), options.Version)
+			published, err = publishToDir(options.versionDirFor(artifact.Platform), options.Version, artifact)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to publish %s for %s:\n%v\n", options.Version, artifact.Platform, err)
+			os.RemoveAll(buildDir)
+			os.Exit(1)
+		}
+
+		status := "published"
+
+		if !published {
+			status = "unchanged"
+		}
+
+		fmt.Printf("%s  %s  %s  %s\n", artifact.Sha512, artifact.Platform, destination, status)
+	}
+}
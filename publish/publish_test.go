@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func newTestArtifact(t *testing.T, content string, key ed25519.PrivateKey) Artifact {
+
+	path := filepath.Join(t.TempDir(), Pokemon)
+
+	if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	artifact, err := signArtifact(Artifact{Platform: Platform{"linux", "amd64"}, Path: path}, key)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return artifact
+}
+
+func TestParsePlatforms(t *testing.T) {
+
+	platforms, err := parsePlatforms("linux/amd64, darwin/arm64")
+
+	if err != nil || len(platforms) != 2 || platforms[0] != (Platform{"linux", "amd64"}) || platforms[1].String() != "darwin/arm64" {
+		t.Errorf("Expected [linux/amd64 darwin/arm64] but found %v: %v", platforms, err)
+	}
+
+	for _, invalid := range []string{"", "linux", "linux/", "/amd64", "linux/amd64/v2", "linux/amd64,"} {
+		if _, err := parsePlatforms(invalid); err == nil {
+			t.Errorf("Expected an error for \"%s\"", invalid)
+		}
+	}
+}
+
+func TestLdflags(t *testing.T) {
+
+	if flags := ldflags("1.0.0", "", nil); flags != "-X 'main.Version=1.0.0'" {
+		t.Errorf("Expected only the version but found %s", flags)
+	}
+
+	if flags := ldflags("1.0.0", "https://example.com", nil); flags != "-X 'main.Version=1.0.0' -X 'main.UpdateUrl=https://example.com'" {
+		t.Errorf("Expected the version and update URL but found %s", flags)
+	}
+
+	publicKey := ed25519.PublicKey(make([]byte, ed25519.PublicKeySize))
+	expected := "-X 'main.Version=1.0.0' -X 'main.PublicKey=" + base64.StdEncoding.EncodeToString(publicKey) + "'"
+
+	if flags := ldflags("1.0.0", "", publicKey); flags != expected {
+		t.Errorf("Expected %s but found %s", expected, flags)
+	}
+}
+
+func TestVersionDirFor(t *testing.T) {
+
+	linux := Platform{"linux", "amd64"}
+	options := Options{VersionDir: "versions", Platforms: []Platform{linux}}
+
+	if dir := options.versionDirFor(linux); dir != "versions" {
+		t.Errorf("Expected versions but found %s", dir)
+	}
+
+	options.Platforms = append(options.Platforms, Platform{"darwin", "arm64"})
+
+	if dir := options.versionDirFor(linux); dir != filepath.Join("versions", "linux-amd64") {
+		t.Errorf("Expected versions/linux-amd64 but found %s", dir)
+	}
+}
+
+func TestGenerateSigningKeySignsArtifacts(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "signing-key.pem")
+
+	if err := generateSigningKey(path); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := generateSigningKey(path); err == nil {
+		t.Errorf("Expected an error instead of overwriting %s", path)
+	}
+
+	key, err := readSigningKey(path)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	artifact := newTestArtifact(t, "v1", key)
+	sum := sha512.Sum512([]byte("v1"))
+
+	if artifact.Sha512 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the Sha-512 of v1 but found %s", artifact.Sha512)
+	}
+
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), []byte("v1"), artifact.Signature) {
+		t.Errorf("Expected a valid signature but found %x", artifact.Signature)
+	}
+
+	if _, err := os.Stat(path + PublicKeySuffix); err != nil {
+		t.Errorf("Expected the public key to be written: %v", err)
+	}
+
+	if _, err := readSigningKey(path + PublicKeySuffix); err == nil {
+		t.Errorf("Expected an error reading a public key as a signing key")
+	}
+}
+
+func TestPublishToDir(t *testing.T) {
+
+	versionDir := t.TempDir()
+	_, key, _ := ed25519.GenerateKey(nil)
+	artifact := newTestArtifact(t, "v1", key)
+	path := filepath.Join(versionDir, "1.0.0", Pokemon)
+
+	if published, err := publishToDir(versionDir, "1.0.0", artifact); err != nil || !published {
+		t.Fatalf("Expected 1.0.0 to be published but found %t: %v", published, err)
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "v1" {
+		t.Errorf("Expected v1 but found %s", content)
+	}
+
+	if signature, _ := os.ReadFile(path + common.SignatureSuffix); string(signature) != string(artifact.Signature) {
+		t.Errorf("Expected the signature but found %x", signature)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm()&0o100 == 0 {
+		t.Errorf("Expected an executable but found %v: %v", info, err)
+	}
+
+	// Publishing the same version again changes nothing.
+	if published, err := publishToDir(versionDir, "1.0.0", artifact); err != nil || published {
+		t.Errorf("Expected the identical version to be unchanged but found %t: %v", published, err)
+	}
+
+	if _, err := publishToDir(versionDir, "1.0.0", newTestArtifact(t, "changed", nil)); !errors.Is(err, errVersionExists) {
+		t.Errorf("Expected %v but found %v", errVersionExists, err)
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "v1" {
+		t.Errorf("Expected v1 to be unchanged but found %s", content)
+	}
+
+	// Versions migrated to the blob layout only have a manifest.
+	manifest := "{\"sha512\": \"" + artifact.Sha512 + "\"}"
+
+	if err := os.MkdirAll(filepath.Join(versionDir, "2.0.0"), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(versionDir, "2.0.0", ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if published, err := publishToDir(versionDir, "2.0.0", artifact); err != nil || published {
+		t.Errorf("Expected the identical migrated version to be unchanged but found %t: %v", published, err)
+	}
+
+	if _, err := publishToDir(versionDir, "2.0.0", newTestArtifact(t, "changed", nil)); !errors.Is(err, errVersionExists) {
+		t.Errorf("Expected %v but found %v", errVersionExists, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(versionDir, "2.0.0", Pokemon)); !os.IsNotExist(err) {
+		t.Errorf("Expected no executable next to the manifest but found %v", err)
+	}
+}
+
+func TestUploadArtifact(t *testing.T) {
+
+	_, key, _ := ed25519.GenerateKey(nil)
+	artifact := newTestArtifact(t, "v1", key)
+	status := http.StatusCreated
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, _ := io.ReadAll(r.Body)
+
+		if r.Method != "PUT" || r.URL.Path != "/v1.0/admin/versions/pokemon" || r.URL.Query().Get("version") != "1.0.0" ||
+			r.Header.Get("Authorization") != "Bearer token" || r.Header.Get(common.Sha512Name) != artifact.Sha512 ||
+			r.Header.Get(common.SignatureName) != base64.StdEncoding.EncodeToString(artifact.Signature) || string(body) != "v1" {
+			t.Errorf("Unexpected upload %s %s with headers %v and body %s", r.Method, r.URL, r.Header, body)
+		}
+
+		w.WriteHeader(status)
+	}))
+
+	defer server.Close()
+
+	if uploaded, err := uploadArtifact(server.URL, "token", "1.0.0", artifact); err != nil || !uploaded {
+		t.Errorf("Expected 1.0.0 to be uploaded but found %t: %v", uploaded, err)
+	}
+
+	status = http.StatusOK
+
+	if uploaded, err := uploadArtifact(server.URL, "token", "1.0.0", artifact); err != nil || uploaded {
+		t.Errorf("Expected the identical version to be unchanged but found %t: %v", uploaded, err)
+	}
+
+	status = http.StatusConflict
+
+	if _, err := uploadArtifact(server.URL, "token", "1.0.0", artifact); !errors.Is(err, errVersionExists) {
+		t.Errorf("Expected %v but found %v", errVersionExists, err)
+	}
+
+	status = http.StatusUnauthorized
+
+	if _, err := uploadArtifact(server.URL, "token", "1.0.0", artifact); err == nil {
+		t.Errorf("Expected an error for an unauthorized upload")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The largest executable which may be uploaded.
+const MaxUploadSize int64 = 512 * MB
+
+// The shortest admin token accepted so that tokens can't be guessed.
+const MinAdminTokenLength int = 16
+
+// An upload which is rejected because of the request rather than the server.
+type InvalidUploadError struct {
+	Reason string
+}
+
+func (e *InvalidUploadError) Error() string {
+	return e.Reason
+}
+
+// Uploads which would change an existing version.
+var errVersionExists = errors.New("the version already exists with different content")
+
+// Admin changes to versions which don't exist.
+var errVersionNotFound = errors.New("the version does not exist")
+
+// Acceptances of changes to versions whose content didn't change.
+var errNoContentChange = errors.New("the version's content has not changed")
+
+// Acceptances of content other than the version's current content.
+var errContentMismatch = errors.New("the hash does not match the version's current content")
+
+// Reads the bearer token which authorizes admin requests from the file.
+func readAdminToken(path string) (string, error) {
+
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(content))
+
+	if len(token) < MinAdminTokenLength {
+		return "", fmt.Errorf("admin token in %s must be at least %d characters", path, MinAdminTokenLength)
+	}
+
+	return token, nil
+}
+
+// Returns true if the request has the admin bearer token.
+func isAdmin(r *http.Request, token string) bool {
+
+	presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+// Writes the data to a temp file and moves it into place so that the file is
+// never partially written even if the server is killed.
+func writeFileAtomic(path string, data []byte, permissions fs.FileMode) error {
+	return common.CopyFileAtomic(path, bytes.NewReader(data), permissions)
+}
+
+// A version with everything the server knows about it.
+type AdminVersion struct {
+	common.Metadata
+	Rollout Rollout `json:"rollout"`
+	// True if the rollout was halted because too many clients failed to
+	// update to the version
+	Halted bool `json:"halted"`
+	// The change to the version's content if it's withheld because its
+	// content changed
+	ContentChange *ContentChange `json:"contentChange,omitempty"`
+}
+
+// Lists every version found in the version dir including halted, yanked,
+// and withheld versions in ascending order. Withheld versions only have their
+// version and the hash of their changed content.
+func listAdminVersions(versions *VersionsCache) []AdminVersion {
+
+	versions.Lock.RLock()
+	defer versions.Lock.RUnlock()
+
+	semVers := common.SemVers(make([]common.SemVer, 0, len(versions.VersionToMetadataMap)+len(versions.ContentChanged)))
+
+	for possibleVersion := range versions.VersionToMetadataMap {
+		if version, err := common.ParseSemVer(possibleVersion); err == nil {
+			semVers = append(semVers, version)
+		}
+	}
+
+	for possibleVersion := range versions.ContentChanged {
+		if version, err := common.ParseSemVer(possibleVersion); err == nil {
+			semVers = append(semVers, version)
+		}
+	}
+
+	sort.Sort(semVers)
+	list := make([]AdminVersion, 0, len(semVers))
+
+	for _, version := range semVers {
+
+		if change, changed := versions.ContentChanged[version.String]; changed {
+			list = append(list, AdminVersion{
+				Metadata:      common.Metadata{Version: version.String, Sha512: change.FoundSha512},
+				ContentChange: &change,
+			})
+			continue
+		}
+
+		_, halted := versions.Halted[version.String]
+		list = append(list, AdminVersion{
+			Metadata: versions.VersionToMetadataMap[version.String],
+			Rollout:  versions.VersionToRolloutMap[version.String],
+			Halted:   halted,
+		})
+	}
+
+	return list
+}
+
+// Stores an uploaded executable and its optional signature as the version in
+// the layout after verifying its hash. The executable (or the manifest in the
+// blob layout) is stored after its signature so that the server never finds a
+// partially uploaded version. Versions which were removed may only be
+// uploaded again with the hash in the ledger. Returns false if the version
+// already exists with the same hash.
+func uploadVersion(storage Storage, layout string, ledger *Ledger, version string, body io.Reader, expectedSha512 string, signature []byte) (bool, error) {
+
+	if _, err := common.ParseSemVer(version); err != nil {
+		return false, &InvalidUploadError{fmt.Sprintf("version \"%s\" is not a semantic version", version)}
+	}
+
+	expectedSha512 = strings.ToLower(expectedSha512)
+
+	if decoded, err := hex.DecodeString(expectedSha512); err != nil || len(decoded) != sha512.Size {
+		return false, &InvalidUploadError{fmt.Sprintf("the %s header must be a hexadecimal %s hash", common.Sha512Name, common.Sha512Name)}
+	}
+
+	if recordedSha512, recorded := ledger.Get(version); recorded && recordedSha512 != expectedSha512 {
+		return false, errVersionExists
+	}
+
+	name := path.Join(version, Pokemon)
+	fileName, manifestSha512, err := versionFile(storage, version)
+
+	if err != nil {
+		return false, err
+	}
+
+	if manifestSha512 != "" && manifestSha512 != expectedSha512 {
+		return false, errVersionExists
+	} else if manifestSha512 != "" {
+		return false, nil
+	}
+
+	if existing, err := storage.Open(fileName); err == nil {
+
+		sha512, err := common.Sha512Hash(existing)
+		existing.Close()
+
+		if err != nil {
+			return false, err
+		}
+
+		if sha512 != expectedSha512 {
+			return false, errVersionExists
+		}
+
+		return false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	// Receive the upload in a temp file so that it's only stored once it
+	// matches its hash.
+	temp, err := os.CreateTemp("", Pokemon+".*.tmp")
+
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	var hasher hash.Hash = sha512.New()
+
+	if _, err = io.Copy(io.MultiWriter(temp, hasher), body); err != nil {
+		return false, err
+	}
+
+	if sha512 := common.ToHexHash(&hasher); sha512 != expectedSha512 {
+		return false, &InvalidUploadError{common.NewSha512Error(name, expectedSha512, sha512).Error()}
+	}
+
+	if len(signature) > 0 {
+		if err = storage.WriteFile(name+common.SignatureSuffix, bytes.NewReader(signature), 0o644); err != nil {
+			return false, err
+		}
+	}
+
+	if layout == LayoutBlob {
+		if err = writeBlob(storage, expectedSha512, temp); err == nil {
+			err = writeManifest(storage, version, expectedSha512)
+		}
+	} else {
+		err = storage.WriteFile(name, temp, 0o755)
+	}
+
+	if err != nil {
+
+		// Don't leave a signature without an executable behind.
+		if len(signature) > 0 {
+			storage.Remove(name + common.SignatureSuffix)
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Changes the rollout of an existing version and writes it to the version's
+// RolloutFileName. The change applies once the versions are updated.
+func changeRollout(versions *VersionsCache, version string, change func(rollout *Rollout)) (Rollout, error) {
+
+	if _, exists := getMetadata(versions, version); !exists {
+		return Rollout{}, errVersionNotFound
+	}
+
+	rollout, err := readRollout(versions.Storage, version)
+
+	if err != nil {
+		return Rollout{}, err
+	}
+
+	change(&rollout)
+
+	rolloutJson, err := json.MarshalIndent(rollout, "", "  ")
+
+	if err != nil {
+		return Rollout{}, err
+	}
+
+	return rollout, versions.Storage.WriteFile(path.Join(version, RolloutFileName), bytes.NewReader(append(rolloutJson, '\n')), 0o644)
+}
+
+// Accepts the changed content of a version withheld because its content
+// changed so that the version is served again. The hash must be the hash of
+// the changed content so that content which changed again isn't accepted by
+// accident. The change applies once the versions are updated.
+func acceptContentChange(versions *VersionsCache, version string, sha512 string) error {
+
+	change, changed := getContentChange(versions, version)
+
+	if !changed {
+		return errNoContentChange
+	}
+
+	if !strings.EqualFold(sha512, change.FoundSha512) {
+		return errContentMismatch
+	}
+
+	return versions.Ledger.Accept(version, change.FoundSha512, time.Now().UTC())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func sha512Hex(content string) string {
+	sum := sha512.Sum512([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestIsAdmin(t *testing.T) {
+
+	token := "0123456789abcdef"
+	headers := map[string]bool{
+		"":                      false,
+		token:                   false,
+		"Bearer ":               false,
+		"Bearer wrong":          false,
+		"Bearer " + token + "x": false,
+		"bearer " + token:       false,
+		"Bearer " + token:       true,
+	}
+
+	for header, expected := range headers {
+
+		r := httptest.NewRequest("GET", "/v1.0/admin/versions/pokemon", nil)
+		r.Header.Set("Authorization", header)
+
+		if actual := isAdmin(r, token); actual != expected {
+			t.Errorf("Expected %t for \"%s\" but found %t", expected, header, actual)
+		}
+	}
+}
+
+func TestReadAdminToken(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "admin-token")
+
+	if err := os.WriteFile(path, []byte("0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if token, err := readAdminToken(path); err != nil || token != "0123456789abcdef" {
+		t.Errorf("Expected 0123456789abcdef but found %s: %v", token, err)
+	}
+
+	if err := os.WriteFile(path, []byte("short"), 0o600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := readAdminToken(path); err == nil {
+		t.Errorf("Expected an error for a short token")
+	}
+}
+
+func TestUploadVersion(t *testing.T) {
+
+	settings := &Settings{PokemonVersionDir: t.TempDir()}
+	storage := NewFileStorage(settings.PokemonVersionDir)
+	ledger := NewLedger("")
+	path := filepath.Join(settings.PokemonVersionDir, "1.0.0", Pokemon)
+
+	created, err := uploadVersion(storage, LayoutVersion, ledger, "1.0.0", strings.NewReader("v1"), strings.ToUpper(sha512Hex("v1")), []byte("signature"))
+
+	if err != nil || !created {
+		t.Fatalf("Expected the version to be created but found %t: %v", created, err)
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "v1" {
+		t.Errorf("Expected v1 but found %s", content)
+	}
+
+	if signature, _ := os.ReadFile(path + common.SignatureSuffix); string(signature) != "signature" {
+		t.Errorf("Expected signature but found %s", signature)
+	}
+
+	// Uploading the same version again changes nothing.
+	if created, err := uploadVersion(storage, LayoutVersion, ledger, "1.0.0", strings.NewReader("v1"), sha512Hex("v1"), nil); err != nil || created {
+		t.Errorf("Expected the identical version to be unchanged but found %t: %v", created, err)
+	}
+
+	if _, err := uploadVersion(storage, LayoutVersion, ledger, "1.0.0", strings.NewReader("changed"), sha512Hex("changed"), nil); !errors.Is(err, errVersionExists) {
+		t.Errorf("Expected %v but found %v", errVersionExists, err)
+	}
+
+	var invalidUpload *InvalidUploadError
+
+	if _, err := uploadVersion(storage, LayoutVersion, ledger, "2.0.0", strings.NewReader("corrupt"), sha512Hex("v2"), nil); !errors.As(err, &invalidUpload) {
+		t.Errorf("Expected an invalid upload but found %v", err)
+	}
+
+	// Failed uploads leave nothing behind for the server to find.
+	if _, err := os.Stat(filepath.Join(settings.PokemonVersionDir, "2.0.0")); !os.IsNotExist(err) {
+		t.Errorf("Expected no 2.0.0 dir after a failed upload but found %v", err)
+	}
+
+	for _, invalid := range [][]string{{"latest", sha512Hex("v2")}, {"2.0.0", "abc"}, {"2.0.0", ""}} {
+		if _, err := uploadVersion(storage, LayoutVersion, ledger, invalid[0], strings.NewReader("v2"), invalid[1], nil); !errors.As(err, &invalidUpload) {
+			t.Errorf("Expected an invalid upload for %v but found %v", invalid, err)
+		}
+	}
+}
+
+func TestChangeRolloutYanksVersions(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0", "2.0.0")
+
+	rollout, err := changeRollout(versions, "2.0.0", func(rollout *Rollout) {
+		rollout.Yanked = true
+	})
+
+	if err != nil || !rollout.Yanked || rollout.Percentage != 100 {
+		t.Fatalf("Expected a yanked rollout of 100%% but found %+v: %v", rollout, err)
+	}
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0"}) {
+		t.Errorf("Expected [1.0.0] but found %v", offered)
+	}
+
+	list := listAdminVersions(versions)
+
+	if len(list) != 2 || list[1].Version != "2.0.0" || !list[1].Rollout.Yanked || list[1].Sha512 != sha512Hex("2.0.0") {
+		t.Errorf("Expected 1.0.0 and yanked 2.0.0 but found %+v", list)
+	}
+
+	if _, err := changeRollout(versions, "3.0.0", func(rollout *Rollout) {}); !errors.Is(err, errVersionNotFound) {
+		t.Errorf("Expected %v but found %v", errVersionNotFound, err)
+	}
+}
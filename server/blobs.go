@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The layouts which Settings.VersionLayout may select for uploaded versions.
+const (
+	// Each version's executable in its version dir such as 1.0.0/pokemon
+	LayoutVersion string = "version"
+	// Each version's executable in BlobDir named by its Sha-512 with a
+	// ManifestFileName in its version dir pointing at the blob
+	LayoutBlob string = "blob"
+)
+
+// The dir of executables named by their Sha-512 so that identical executables
+// are only stored once.
+const BlobDir string = "blobs"
+
+// The file in each version dir of the blob layout which names the version's
+// blob such as 1.0.0/manifest.json
+const ManifestFileName string = "manifest.json"
+
+var sha512Pattern = regexp.MustCompile(`^[0-9a-f]{128}$`)
+
+// Points a version at the blob containing its executable.
+type Manifest struct {
+	// The lowercase hexadecimal Sha-512 of the executable which names its
+	// blob
+	Sha512 string `json:"sha512"`
+}
+
+// Gets the name of the blob with the hash.
+func blobName(sha512 string) string {
+	return path.Join(BlobDir, sha512)
+}
+
+// Gets the name of the version's executable in the storage and the hash its
+// manifest expects. Versions without a manifest have their executable in
+// their version dir and expect no hash. The executable may not exist.
+func versionFile(storage Storage, version string) (name string, expectedSha512 string, err error) {
+
+	file, err := storage.Open(path.Join(version, ManifestFileName))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return path.Join(version, Pokemon), "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	var manifest Manifest
+	err = readJson(file, MB, &manifest)
+	file.Close()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if !sha512Pattern.MatchString(manifest.Sha512) {
+		return "", "", fmt.Errorf("%s \"%s\" is not a lowercase hexadecimal %s hash", ManifestFileName, manifest.Sha512, common.Sha512Name)
+	}
+
+	return blobName(manifest.Sha512), manifest.Sha512, nil
+}
+
+// Stores the content as the blob named by its hash unless the blob already
+// exists.
+func writeBlob(storage Storage, sha512 string, content io.ReadSeeker) error {
+
+	name := blobName(sha512)
+
+	if blob, err := storage.Open(name); err == nil {
+		blob.Close()
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return storage.WriteFile(name, content, 0o755)
+}
+
+// Points the version at the blob with the hash.
+func writeManifest(storage Storage, version string, sha512 string) error {
+
+	manifestJson, err := json.MarshalIndent(Manifest{Sha512: sha512}, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return storage.WriteFile(path.Join(version, ManifestFileName), bytes.NewReader(append(manifestJson, '\n')), 0o644)
+}
+
+// Moves the executable of every version in the version layout into a blob
+// and points the version at it. Each executable is removed only after its
+// version's manifest is written, so versions are served throughout and an
+// interrupted migration can be run again. Returns the migrated versions.
+func migrateToBlobs(storage Storage) ([]string, error) {
+
+	entries, err := storage.ReadDir(".")
+
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+
+		version := entry.Name()
+
+		if _, err := common.ParseSemVer(version); err != nil {
+			continue
+		}
+
+		if err := migrateVersionToBlob(storage, version); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return migrated, fmt.Errorf("failed to migrate version %s: %w", version, err)
+		}
+
+		migrated = append(migrated, version)
+	}
+
+	return migrated, nil
+}
+
+// Moves the version's executable into a blob. Returns an error matching
+// fs.ErrNotExist if the version has no executable in its version dir.
+func migrateVersionToBlob(storage Storage, version string) error {
+
+	name := path.Join(version, Pokemon)
+	file, err := storage.Open(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	// Copy the executable to a temp file while hashing it since not every
+	// storage can seek.
+	temp, err := os.CreateTemp("", Pokemon+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	var hasher hash.Hash = sha512.New()
+
+	if _, err = io.Copy(io.MultiWriter(temp, hasher), file); err != nil {
+		return err
+	}
+
+	sha512 := common.ToHexHash(&hasher)
+
+	if _, expectedSha512, err := versionFile(storage, version); err != nil {
+		return err
+	} else if expectedSha512 != "" && expectedSha512 != sha512 {
+		return fmt.Errorf("%s points at %s, but %s has %s %s", ManifestFileName, expectedSha512, name, common.Sha512Name, sha512)
+	}
+
+	if err = writeBlob(storage, sha512, temp); err != nil {
+		return err
+	}
+
+	if err = writeManifest(storage, version, sha512); err != nil {
+		return err
+	}
+
+	return storage.Remove(name)
+}
+
+// Gets the name of the executable with the hash in the storage and the newest
+// version served with it. Withheld versions aren't served by hash.
+func getBlob(versions *VersionsCache, sha512 string) (version string, name string, exists bool) {
+
+	versions.Lock.RLock()
+	defer versions.Lock.RUnlock()
+
+	var newest common.SemVer
+
+	for possibleVersion, metadata := range versions.VersionToMetadataMap {
+
+		if metadata.Sha512 != sha512 {
+			continue
+		}
+
+		if semVer, err := common.ParseSemVer(possibleVersion); err == nil && (!exists || newest.Less(semVer)) {
+			newest = semVer
+			exists = true
+		}
+	}
+
+	if !exists {
+		return "", "", false
+	}
+
+	return newest.String, versions.VersionToFileMap[newest.String], true
+}
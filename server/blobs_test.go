@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMigrateToBlobsDeduplicatesExecutables(t *testing.T) {
+
+	s3Storage, _ := newFakeS3(t)
+	settings, _ := newTestVersions(t)
+
+	for _, storage := range []Storage{NewFileStorage(settings.PokemonVersionDir), s3Storage} {
+		t.Run(storage.String(), func(t *testing.T) {
+
+			// 3.0.0 was republished with the executable of 1.0.0.
+			for version, content := range map[string]string{"1.0.0": "1.0.0", "2.0.0": "2.0.0", "3.0.0": "1.0.0"} {
+				if err := storage.WriteFile(path.Join(version, Pokemon), strings.NewReader(content), 0o755); err != nil {
+					t.Fatalf("%v", err)
+				}
+			}
+
+			versions := &VersionsCache{Ledger: NewLedger(""), Storage: storage}
+
+			if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			before := versions.VersionToMetadataMap
+			migrated, err := migrateToBlobs(storage)
+
+			if err != nil || !slices.Equal(migrated, []string{"1.0.0", "2.0.0", "3.0.0"}) {
+				t.Fatalf("Expected [1.0.0 2.0.0 3.0.0] to be migrated but found %v: %v", migrated, err)
+			}
+
+			if blobs, err := storage.ReadDir(BlobDir); err != nil || len(blobs) != 2 {
+				t.Errorf("Expected 2 blobs but found %v: %v", blobs, err)
+			}
+
+			if _, err := storage.Open("3.0.0/pokemon"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Expected the migrated executable to be removed but found %v", err)
+			}
+
+			if updated, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); !updated || err != nil {
+				t.Fatalf("Expected the versions to be updated but found %t: %v", updated, err)
+			}
+
+			if len(versions.ContentChanged) != 0 {
+				t.Errorf("Expected no content changes but found %v", versions.ContentChanged)
+			}
+
+			for version, metadata := range before {
+				if versions.VersionToMetadataMap[version] != metadata {
+					t.Errorf("Expected %+v but found %+v", metadata, versions.VersionToMetadataMap[version])
+				}
+			}
+
+			if name := getFileName(versions, "3.0.0"); name != blobName(sha512Hex("1.0.0")) {
+				t.Errorf("Expected 3.0.0 to be served from the blob of 1.0.0 but found %s", name)
+			}
+
+			if version, name, exists := getBlob(versions, sha512Hex("1.0.0")); !exists || version != "3.0.0" || name != blobName(sha512Hex("1.0.0")) {
+				t.Errorf("Expected the blob of 1.0.0 for 3.0.0 but found %t %s %s", exists, version, name)
+			}
+
+			if _, _, exists := getBlob(versions, sha512Hex("missing")); exists {
+				t.Errorf("Expected no blob for missing content")
+			}
+
+			if migrated, err := migrateToBlobs(storage); err != nil || len(migrated) != 0 {
+				t.Errorf("Expected nothing left to migrate but found %v: %v", migrated, err)
+			}
+		})
+	}
+}
+
+func TestUpdateVersionsIgnoresCorruptBlobs(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0", "2.0.0")
+	metrics := NewMetrics()
+
+	if _, err := migrateToBlobs(versions.Storage); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	blobPath := filepath.Join(settings.PokemonVersionDir, BlobDir, sha512Hex("2.0.0"))
+
+	if err := os.WriteFile(blobPath, []byte("corrupt"), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, metrics); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0"}) {
+		t.Errorf("Expected [1.0.0] but found %v", offered)
+	}
+
+	expectMetricLines(t, writeTestMetrics(t, metrics, versions), "pokemon_server_hash_failures_total 1")
+
+	manifestPath := filepath.Join(settings.PokemonVersionDir, "1.0.0", ManifestFileName)
+
+	if err := os.WriteFile(manifestPath, []byte(`{"sha512": "../2.0.0/pokemon"}`), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, metrics); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if offered := offeredVersions(versions); len(offered) != 0 {
+		t.Errorf("Expected no versions for invalid manifests but found %v", offered)
+	}
+}
+
+func TestUploadVersionToBlobs(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0")
+
+	if _, err := migrateToBlobs(versions.Storage); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The new version reuses the blob of 1.0.0.
+	created, err := uploadVersion(versions.Storage, LayoutBlob, versions.Ledger, "2.0.0", strings.NewReader("1.0.0"), sha512Hex("1.0.0"), []byte("signature"))
+
+	if err != nil || !created {
+		t.Fatalf("Expected 2.0.0 to be created but found %t: %v", created, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(settings.PokemonVersionDir, "2.0.0", Pokemon)); !os.IsNotExist(err) {
+		t.Errorf("Expected no executable in the version dir but found %v", err)
+	}
+
+	if blobs, err := versions.Storage.ReadDir(BlobDir); err != nil || len(blobs) != 1 {
+		t.Errorf("Expected 1 blob but found %v: %v", blobs, err)
+	}
+
+	if created, err := uploadVersion(versions.Storage, LayoutBlob, versions.Ledger, "2.0.0", strings.NewReader("1.0.0"), sha512Hex("1.0.0"), nil); err != nil || created {
+		t.Errorf("Expected the identical upload to be unchanged but found %t: %v", created, err)
+	}
+
+	if _, err := uploadVersion(versions.Storage, LayoutVersion, versions.Ledger, "2.0.0", strings.NewReader("2.0.0"), sha512Hex("2.0.0"), nil); !errors.Is(err, errVersionExists) {
+		t.Errorf("Expected %v but found %v", errVersionExists, err)
+	}
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if metadata, exists := getMetadata(versions, "2.0.0"); !exists || metadata.Sha512 != sha512Hex("1.0.0") || metadata.Signature == "" {
+		t.Errorf("Expected the signed blob of 1.0.0 but found %+v", metadata)
+	}
+}
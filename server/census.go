@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The census windows used if Settings.CensusWindows is empty.
+const DefaultCensusWindows string = "1h,24h,168h"
+
+// The most clients the census remembers. Check-ins from new clients are
+// ignored once the census is full until inactive clients are forgotten.
+const MaxCensusClients int = 100_000
+
+// The interval between saving the census to Settings.CensusFile.
+const CensusSaveIntervalSecs uint64 = 60
+
+// Client IDs and platforms are restricted so that check-ins can't inject
+// arbitrary data into the stats.
+var clientIdPattern = regexp.MustCompile(`^[0-9A-Za-z-]{1,64}$`)
+var clientPlatformPattern = regexp.MustCompile(`^[0-9a-z_]{1,32}/[0-9a-z_]{1,32}$`)
+
+// The latest check-in from a client.
+type CensusClient struct {
+	Id       string    `json:"id"`
+	Version  string    `json:"version"`
+	Platform string    `json:"platform"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Clients which checked for updates keyed by client ID. Use the Lock when
+// reading and writing data otherwise access will not be thread-safe.
+type Census struct {
+	Clients map[string]CensusClient
+	// Clients which haven't checked in for longer than the largest window
+	// are forgotten.
+	Retention time.Duration
+	// True if clients changed since the census was last saved
+	Changed bool
+	Lock    sync.Mutex
+}
+
+// Active clients during a window ending now.
+type CensusWindow struct {
+	WindowSecs uint64 `json:"windowSecs"`
+	Clients    int    `json:"clients"`
+	// Client counts keyed by version
+	Versions map[string]int `json:"versions"`
+	// Client counts keyed by platform such as linux/amd64
+	Platforms map[string]int `json:"platforms"`
+}
+
+type CensusStats struct {
+	Time    time.Time      `json:"time"`
+	Windows []CensusWindow `json:"windows"`
+}
+
+func NewCensus(retention time.Duration) *Census {
+	return &Census{
+		Clients:   map[string]CensusClient{},
+		Retention: retention,
+	}
+}
+
+// Parses comma-separated Go durations such as "1h,24h" into ascending
+// windows.
+func parseCensusWindows(windows string) ([]time.Duration, error) {
+
+	if windows == "" {
+		windows = DefaultCensusWindows
+	}
+
+	parsed := make([]time.Duration, 0)
+
+	for _, window := range strings.Split(windows, ",") {
+
+		duration, err := time.ParseDuration(strings.TrimSpace(window))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if duration < time.Second {
+			return nil, fmt.Errorf("census window %s must be at least 1s", duration)
+		}
+
+		parsed = append(parsed, duration)
+	}
+
+	slices.Sort(parsed)
+	return parsed, nil
+}
+
+// Records a client's check-in from the census headers of a versions request.
+// Returns false if the check-in was ignored because the client didn't send
+// valid headers or the census is full.
+func (c *Census) CheckIn(header func(string) string, now time.Time) bool {
+
+	client := CensusClient{
+		Id:       header(common.ClientIdName),
+		Version:  header(common.ClientVersionName),
+		Platform: header(common.ClientPlatformName),
+		LastSeen: now,
+	}
+
+	if !clientIdPattern.MatchString(client.Id) || !clientPlatformPattern.MatchString(client.Platform) {
+		return false
+	}
+
+	if _, err := common.ParseSemVer(client.Version); err != nil {
+		return false
+	}
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if _, exists := c.Clients[client.Id]; !exists && len(c.Clients) >= MaxCensusClients {
+		return false
+	}
+
+	c.Clients[client.Id] = client
+	c.Changed = true
+	return true
+}
+
+// Returns true if the client checked in and hasn't been forgotten.
+func (c *Census) Has(id string) bool {
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	_, exists := c.Clients[id]
+	return exists
+}
+
+// Forgets clients which haven't checked in during the retention period.
+func (c *Census) Prune(now time.Time) {
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	for id, client := range c.Clients {
+		if now.Sub(client.LastSeen) > c.Retention {
+			delete(c.Clients, id)
+			c.Changed = true
+		}
+	}
+}
+
+// Counts the clients which checked in during each window ending now.
+func (c *Census) Stats(windows []time.Duration, now time.Time) CensusStats {
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	stats := CensusStats{Time: now, Windows: make([]CensusWindow, 0, len(windows))}
+
+	for _, window := range windows {
+
+		counts := CensusWindow{
+			WindowSecs: uint64(window / time.Second),
+			Versions:   map[string]int{},
+			Platforms:  map[string]int{},
+		}
+
+		for _, client := range c.Clients {
+			if now.Sub(client.LastSeen) <= window {
+				counts.Clients += 1
+				counts.Versions[client.Version] += 1
+				counts.Platforms[client.Platform] += 1
+			}
+		}
+
+		stats.Windows = append(stats.Windows, counts)
+	}
+
+	return stats
+}
+
+// Writes the census to the file if clients changed since the last save.
+func (c *Census) Save(path string) error {
+
+	c.Lock.Lock()
+
+	if !c.Changed {
+		c.Lock.Unlock()
+		return nil
+	}
+
+	clients := make([]CensusClient, 0, len(c.Clients))
+
+	for _, client := range c.Clients {
+		clients = append(clients, client)
+	}
+
+	c.Changed = false
+	c.Lock.Unlock()
+
+	censusJson, err := json.Marshal(clients)
+
+	if err == nil {
+		err = writeFileAtomic(path, censusJson, 0o644)
+	}
+
+	// Retry on the next save.
+	if err != nil {
+		c.Lock.Lock()
+		c.Changed = true
+		c.Lock.Unlock()
+	}
+
+	return err
+}
+
+// Loads a census saved to the path. A missing file loads an empty census.
+func loadCensus(path string, retention time.Duration) (*Census, error) {
+
+	census := NewCensus(retention)
+
+	if path == "" {
+		return census, nil
+	}
+
+	var clients []CensusClient
+
+	if err := readJsonFile(path, int64(MaxCensusClients)*1024, &clients); err != nil && os.IsNotExist(err) {
+		return census, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, client := range clients {
+		census.Clients[client.Id] = client
+	}
+
+	return census, nil
+}
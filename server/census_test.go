@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func censusHeader(id string, version string, platform string) func(string) string {
+	return func(name string) string {
+		switch name {
+		case common.ClientIdName:
+			return id
+		case common.ClientVersionName:
+			return version
+		case common.ClientPlatformName:
+			return platform
+		}
+		return ""
+	}
+}
+
+func TestCensusCheckInValidatesHeaders(t *testing.T) {
+
+	census := NewCensus(time.Hour)
+	now := time.Now()
+
+	invalid := []func(string) string{
+		censusHeader("", "1.0.0", "linux/amd64"),
+		censusHeader("a b", "1.0.0", "linux/amd64"),
+		censusHeader("client", "", "linux/amd64"),
+		censusHeader("client", "one", "linux/amd64"),
+		censusHeader("client", "1.0.0", ""),
+		censusHeader("client", "1.0.0", "linux"),
+		censusHeader("client", "1.0.0", "<script>/amd64"),
+	}
+
+	for _, header := range invalid {
+		if census.CheckIn(header, now) {
+			t.Errorf("Expected check-in with id=%s version=%s platform=%s to be ignored",
+				header(common.ClientIdName), header(common.ClientVersionName), header(common.ClientPlatformName))
+		}
+	}
+
+	if !census.CheckIn(censusHeader("client", "1.0.0", "linux/amd64"), now) {
+		t.Fatalf("Expected valid check-in to be counted")
+	}
+
+	// A later check-in replaces the client's version.
+	census.CheckIn(censusHeader("client", "2.0.0", "linux/amd64"), now)
+
+	if len(census.Clients) != 1 || census.Clients["client"].Version != "2.0.0" {
+		t.Errorf("Expected 1 client with version 2.0.0 but found %v", census.Clients)
+	}
+
+	if !census.Has("client") || census.Has("other") || census.Has("") {
+		t.Errorf("Expected only the client which checked in to be known")
+	}
+}
+
+func TestCensusStatsCountsClientsPerWindow(t *testing.T) {
+
+	census := NewCensus(24 * time.Hour)
+	now := time.Now()
+
+	census.CheckIn(censusHeader("a", "1.0.0", "linux/amd64"), now.Add(-10*time.Minute))
+	census.CheckIn(censusHeader("b", "2.0.0", "linux/amd64"), now.Add(-2*time.Hour))
+	census.CheckIn(censusHeader("c", "2.0.0", "windows/amd64"), now.Add(-20*time.Hour))
+	census.CheckIn(censusHeader("d", "1.0.0", "darwin/arm64"), now.Add(-30*time.Hour))
+
+	stats := census.Stats([]time.Duration{time.Hour, 24 * time.Hour}, now)
+
+	if len(stats.Windows) != 2 {
+		t.Fatalf("Expected 2 windows but found %d", len(stats.Windows))
+	}
+
+	hour := stats.Windows[0]
+
+	if hour.WindowSecs != 3600 || hour.Clients != 1 || hour.Versions["1.0.0"] != 1 || hour.Platforms["linux/amd64"] != 1 {
+		t.Errorf("Expected 1 client with 1.0.0 on linux/amd64 in the last hour but found %+v", hour)
+	}
+
+	day := stats.Windows[1]
+
+	if day.Clients != 3 || day.Versions["2.0.0"] != 2 || day.Platforms["windows/amd64"] != 1 || day.Platforms["darwin/arm64"] != 0 {
+		t.Errorf("Expected 3 clients in the last day but found %+v", day)
+	}
+
+	census.Prune(now)
+
+	if _, exists := census.Clients["d"]; exists || len(census.Clients) != 3 {
+		t.Errorf("Expected client d to be pruned but found %v", census.Clients)
+	}
+}
+
+func TestCensusSaveAndLoad(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "census.json")
+
+	census, err := loadCensus(path, time.Hour)
+
+	if err != nil || len(census.Clients) != 0 {
+		t.Fatalf("Expected an empty census for a missing file but found %v: %v", census, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	census.CheckIn(censusHeader("a", "1.0.0", "linux/amd64"), now)
+
+	if err := census.Save(path); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if census.Changed {
+		t.Errorf("Expected the census to be unchanged after saving")
+	}
+
+	loaded, err := loadCensus(path, time.Hour)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if client := loaded.Clients["a"]; client.Version != "1.0.0" || client.Platform != "linux/amd64" || !client.LastSeen.Equal(now) {
+		t.Errorf("Expected %v but found %v", census.Clients["a"], client)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := loadCensus(path, time.Hour); err == nil {
+		t.Errorf("Expected an error loading an invalid census")
+	}
+}
+
+func TestParseCensusWindows(t *testing.T) {
+
+	windows, err := parseCensusWindows("24h, 1h")
+
+	if err != nil || len(windows) != 2 || windows[0] != time.Hour || windows[1] != 24*time.Hour {
+		t.Errorf("Expected [1h 24h] but found %v: %v", windows, err)
+	}
+
+	if windows, err := parseCensusWindows(""); err != nil || len(windows) != 3 {
+		t.Errorf("Expected the default windows but found %v: %v", windows, err)
+	}
+
+	for _, invalid := range []string{"1d", "1h,", "500ms"} {
+		if _, err := parseCensusWindows(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
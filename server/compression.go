@@ -0,0 +1,191 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha512"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The content coding executables are precompressed with. Zstandard isn't in
+// the standard library, so only gzip is offered.
+const EncodingGzip string = "gzip"
+
+// The suffix of each precompressed executable in Settings.CompressedDir
+// such as <sha512>.gz
+const CompressedSuffix string = ".gz"
+
+// Gets the path of the precompressed executable with the hash.
+func compressedPath(dir string, sha512 string) string {
+	return filepath.Join(dir, sha512+CompressedSuffix)
+}
+
+// Compresses the executable into the dir named by its hash unless it's
+// already compressed. The compressed executable is only kept if the
+// executable still has the hash so that a changed executable is never served
+// under an old hash. The best compression barely shrinks Go executables more
+// than the default while taking several times longer, so the default is used.
+func compressExecutable(storage Storage, name string, sha512Hex string, dir string) error {
+
+	compressed := compressedPath(dir, sha512Hex)
+
+	if _, err := os.Stat(compressed); err == nil {
+		return nil
+	}
+
+	file, err := storage.Open(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(dir, filepath.Base(compressed)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	var hasher hash.Hash = sha512.New()
+	writer, err := gzip.NewWriterLevel(temp, gzip.DefaultCompression)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(writer, io.TeeReader(file, hasher)); err != nil {
+		return err
+	}
+
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	if found := common.ToHexHash(&hasher); found != sha512Hex {
+		return common.NewSha512Error(name, sha512Hex, found)
+	}
+
+	if err = temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), compressed)
+}
+
+// Removes every precompressed executable which isn't one of the hashes.
+func pruneCompressed(dir string, sha512s map[string]bool) error {
+
+	entries, err := os.ReadDir(dir)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+
+		sha512, compressed := strings.CutSuffix(entry.Name(), CompressedSuffix)
+
+		if compressed && !sha512s[sha512] {
+			if err = os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks whether an Accept-Encoding header such as "gzip, br;q=0.5" accepts
+// gzip. A gzip coding takes precedence over "*".
+func acceptsGzip(acceptEncoding string) bool {
+
+	gzipQ := -1.0
+	anyQ := -1.0
+
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+
+		name, params, _ := strings.Cut(coding, ";")
+		q := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+			if key, value, found := strings.Cut(param, "="); found && strings.TrimSpace(key) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case EncodingGzip, "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+
+	return anyQ > 0
+}
+
+// Serves the executable from the storage or its precompressed copy in the dir
+// if the client accepts gzip. Ranges apply to the bytes sent, so ranges of a
+// compressed response are ranges of the compressed executable. Callers set
+// the other headers.
+func serveExecutable(w http.ResponseWriter, r *http.Request, storage Storage, name string, sha512 string, compressedDir string) {
+
+	if compressedDir == "" {
+		http.ServeFileFS(w, r, storage, name)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		http.ServeFileFS(w, r, storage, name)
+		return
+	}
+
+	compressed, err := os.Open(compressedPath(compressedDir, sha512))
+
+	if err != nil {
+		// Versions are served uncompressed until they're compressed.
+		http.ServeFileFS(w, r, storage, name)
+		return
+	}
+
+	defer compressed.Close()
+	stat, err := compressed.Stat()
+
+	if err != nil {
+		http.ServeFileFS(w, r, storage, name)
+		return
+	}
+
+	// Each encoding is a different representation, so it has its own tag.
+	if etag := w.Header().Get("ETag"); etag != "" {
+		w.Header().Set("ETag", strings.TrimSuffix(etag, "\"")+"-"+EncodingGzip+"\"")
+	}
+
+	w.Header().Add("Content-Encoding", EncodingGzip)
+	http.ServeContent(w, r, name, stat.ModTime(), compressed)
+}
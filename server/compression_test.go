@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+
+	acceptEncodings := map[string]bool{
+		"":                      false,
+		"identity":              false,
+		"gzip":                  true,
+		"GZIP":                  true,
+		"x-gzip":                true,
+		"br, gzip;q=0.5":        true,
+		"gzip;q=0":              false,
+		"gzip; q=0.0, br":       false,
+		"*":                     true,
+		"*;q=0":                 false,
+		"*, gzip;q=0":           false,
+		"gzip;q=0.1, *;q=0":     true,
+		"deflate, identity;q=1": false,
+	}
+
+	for acceptEncoding, expected := range acceptEncodings {
+		if accepted := acceptsGzip(acceptEncoding); accepted != expected {
+			t.Errorf("Expected %t for \"%s\" but found %t", expected, acceptEncoding, accepted)
+		}
+	}
+}
+
+// Downloads the version with the Accept-Encoding header and returns the
+// response with its decompressed body.
+func downloadTestVersion(t testing.TB, settings *Settings, versions *VersionsCache, version string, acceptEncoding string) (*httptest.ResponseRecorder, []byte) {
+
+	metadata, _ := getMetadata(versions, version)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/v1.0/downloads/pokemon?version="+version, nil)
+	request.Header.Set("Accept-Encoding", acceptEncoding)
+	serveExecutable(recorder, request, versions.Storage, getFileName(versions, version), metadata.Sha512, settings.CompressedDir)
+
+	var body io.Reader = bytes.NewReader(recorder.Body.Bytes())
+
+	if recorder.Header().Get("Content-Encoding") == EncodingGzip {
+
+		reader, err := gzip.NewReader(body)
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		body = reader
+	}
+
+	content, err := io.ReadAll(body)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return recorder, content
+}
+
+func TestServeExecutableNegotiatesGzip(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0", "2.0.0")
+	settings.CompressedDir = t.TempDir()
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	recorder, content := downloadTestVersion(t, settings, versions, "2.0.0", "br, gzip")
+
+	if recorder.Header().Get("Content-Encoding") != EncodingGzip || recorder.Header().Get("Vary") != "Accept-Encoding" || string(content) != "2.0.0" {
+		t.Errorf("Expected gzip-compressed 2.0.0 but found %v %s", recorder.Header(), content)
+	}
+
+	recorder, content = downloadTestVersion(t, settings, versions, "2.0.0", "gzip;q=0")
+
+	if recorder.Header().Get("Content-Encoding") != "" || recorder.Header().Get("Vary") != "Accept-Encoding" || string(content) != "2.0.0" {
+		t.Errorf("Expected uncompressed 2.0.0 but found %v %s", recorder.Header(), content)
+	}
+
+	// Compressed copies of removed versions are removed.
+	if err := os.RemoveAll(filepath.Join(settings.PokemonVersionDir, "1.0.0")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if entries, err := os.ReadDir(settings.CompressedDir); err != nil || len(entries) != 1 || entries[0].Name() != sha512Hex("2.0.0")+CompressedSuffix {
+		t.Errorf("Expected only 2.0.0 to be compressed but found %v: %v", entries, err)
+	}
+
+	// Versions which aren't compressed yet are served uncompressed.
+	if err := os.RemoveAll(settings.CompressedDir); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if recorder, content = downloadTestVersion(t, settings, versions, "2.0.0", "gzip"); recorder.Header().Get("Content-Encoding") != "" || string(content) != "2.0.0" {
+		t.Errorf("Expected uncompressed 2.0.0 but found %v %s", recorder.Header(), content)
+	}
+}
+
+func TestCompressExecutableVerifiesHash(t *testing.T) {
+
+	_, versions := newTestVersions(t, "1.0.0")
+	dir := t.TempDir()
+
+	if err := compressExecutable(versions.Storage, "1.0.0/pokemon", sha512Hex("changed"), dir); err == nil {
+		t.Errorf("Expected hash mismatch error")
+	}
+
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("Expected nothing to be compressed but found %v: %v", entries, err)
+	}
+}
+
+// Reports the bytes transferred to download a real executable with and
+// without gzip. Run with:
+//
+//	go test -run - -bench ServeExecutable ./server
+func BenchmarkServeExecutable(b *testing.B) {
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	content, err := os.ReadFile(executable)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	settings := &Settings{PokemonVersionDir: b.TempDir(), CompressedDir: b.TempDir()}
+	versions := &VersionsCache{Ledger: NewLedger(""), Storage: NewFileStorage(settings.PokemonVersionDir)}
+
+	if err = versions.Storage.WriteFile("1.0.0/pokemon", bytes.NewReader(content), 0o755); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if _, err = updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for _, acceptEncoding := range []string{"identity", EncodingGzip} {
+		b.Run(acceptEncoding, func(b *testing.B) {
+
+			var transferred int
+
+			for range b.N {
+
+				recorder, downloaded := downloadTestVersion(b, settings, versions, "1.0.0", acceptEncoding)
+
+				if recorder.Code != http.StatusOK || !bytes.Equal(downloaded, content) {
+					b.Fatalf("Expected the executable but found %d", recorder.Code)
+				}
+
+				transferred = recorder.Body.Len()
+			}
+
+			b.ReportMetric(float64(transferred), "bytes-transferred/op")
+			b.ReportMetric(float64(transferred)/float64(len(content)), "transferred/size")
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// The largest ledger file which may be loaded.
+const MaxLedgerSize int64 = 16 * MB
+
+// The hash recorded for a version when it was first found.
+type LedgerEntry struct {
+	Sha512   string    `json:"sha512"`
+	Recorded time.Time `json:"recorded"`
+}
+
+// Hashes of every version ever found keyed by version so that a published
+// version's content can't change underneath clients which already downloaded
+// it. Use the Lock when reading and writing data otherwise access will not be
+// thread-safe.
+type Ledger struct {
+	Versions map[string]LedgerEntry
+	// The file the ledger is saved to or empty if the ledger is only kept in
+	// memory
+	Path string
+	Lock sync.Mutex
+}
+
+// A version withheld because its executable no longer matches the ledger.
+type ContentChange struct {
+	// The hash recorded when the version was first found
+	RecordedSha512 string `json:"recordedSha512"`
+	// The hash of the executable found in the version dir
+	FoundSha512 string `json:"foundSha512"`
+}
+
+func NewLedger(path string) *Ledger {
+	return &Ledger{
+		Versions: map[string]LedgerEntry{},
+		Path:     path,
+	}
+}
+
+// Loads a ledger saved to the path. A missing file loads an empty ledger.
+func loadLedger(path string) (*Ledger, error) {
+
+	ledger := NewLedger(path)
+
+	if path == "" {
+		return ledger, nil
+	}
+
+	if err := readJsonFile(path, MaxLedgerSize, &ledger.Versions); err != nil && os.IsNotExist(err) {
+		return ledger, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if ledger.Versions == nil {
+		ledger.Versions = map[string]LedgerEntry{}
+	}
+
+	return ledger, nil
+}
+
+// Gets the hash recorded for the version.
+func (l *Ledger) Get(version string) (string, bool) {
+
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	entry, exists := l.Versions[version]
+	return entry.Sha512, exists
+}
+
+// Records the hash if the version is new and returns the version's recorded
+// hash. New versions are saved immediately so that they can't change across
+// restarts. If saving fails, the hash is still recorded in memory.
+func (l *Ledger) Record(version string, sha512 string, now time.Time) (string, error) {
+
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	if entry, exists := l.Versions[version]; exists {
+		return entry.Sha512, nil
+	}
+
+	l.Versions[version] = LedgerEntry{Sha512: sha512, Recorded: now}
+	return sha512, l.save()
+}
+
+// Replaces the version's recorded hash so that changed content is served.
+func (l *Ledger) Accept(version string, sha512 string, now time.Time) error {
+
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	l.Versions[version] = LedgerEntry{Sha512: sha512, Recorded: now}
+	return l.save()
+}
+
+// Writes the ledger to its file. Requires the Lock.
+func (l *Ledger) save() error {
+
+	if l.Path == "" {
+		return nil
+	}
+
+	ledgerJson, err := json.MarshalIndent(l.Versions, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(l.Path, append(ledgerJson, '\n'), 0o644)
+}
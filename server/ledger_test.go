@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedgerRecordsFirstHash(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	ledger, err := loadLedger(path)
+
+	if err != nil || len(ledger.Versions) != 0 {
+		t.Fatalf("Expected an empty ledger for a missing file but found %v: %v", ledger, err)
+	}
+
+	if recorded, err := ledger.Record("1.0.0", "first", time.Now()); recorded != "first" || err != nil {
+		t.Errorf("Expected first but found %s: %v", recorded, err)
+	}
+
+	if recorded, err := ledger.Record("1.0.0", "second", time.Now()); recorded != "first" || err != nil {
+		t.Errorf("Expected the first hash to be kept but found %s: %v", recorded, err)
+	}
+
+	loaded, err := loadLedger(path)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if recorded, exists := loaded.Get("1.0.0"); !exists || recorded != "first" {
+		t.Errorf("Expected the saved ledger to have first but found %s", recorded)
+	}
+
+	if err := ledger.Accept("1.0.0", "second", time.Now()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if loaded, _ = loadLedger(path); loaded.Versions["1.0.0"].Sha512 != "second" {
+		t.Errorf("Expected the accepted hash to be saved but found %+v", loaded.Versions)
+	}
+}
+
+func TestUpdateVersionsFindsChangesWithTheSameSizeAndModificationTime(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0")
+	path := filepath.Join(settings.PokemonVersionDir, "1.0.0", Pokemon)
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Overwrite the executable like `cp -p` would.
+	writeTestVersion(t, settings, "1.0.0", "1.0.1")
+
+	if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if change, changed := getContentChange(versions, "1.0.0"); !changed || change.FoundSha512 != sha512Hex("1.0.1") {
+		t.Errorf("Expected the change to 1.0.0 to be found but found %+v", change)
+	}
+}
+
+func TestUpdateVersionsWithholdsChangedContent(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0", "2.0.0")
+	logger := newTestLogger()
+	metrics := NewMetrics()
+
+	writeTestVersion(t, settings, "2.0.0", "changed")
+
+	if updated, err := updateVersions(logger, settings, versions, metrics); !updated || err != nil {
+		t.Fatalf("Expected the versions to be updated but found %t: %v", updated, err)
+	}
+
+	if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0"}) {
+		t.Errorf("Expected [1.0.0] but found %v", offered)
+	}
+
+	if _, exists := getMetadata(versions, "2.0.0"); exists {
+		t.Errorf("Expected no metadata for the changed version 2.0.0")
+	}
+
+	expected := ContentChange{RecordedSha512: sha512Hex("2.0.0"), FoundSha512: sha512Hex("changed")}
+
+	if change, changed := getContentChange(versions, "2.0.0"); !changed || change != expected {
+		t.Errorf("Expected %+v but found %+v", expected, change)
+	}
+
+	var out strings.Builder
+	metrics.Write(&out, versions)
+
+	if !strings.Contains(out.String(), "pokemon_server_version_content_changed{version=\"2.0.0\"} 1") {
+		t.Errorf("Expected the changed version in the metrics but found:\n%s", out.String())
+	}
+
+	if list := listAdminVersions(versions); len(list) != 2 || list[1].ContentChange == nil || *list[1].ContentChange != expected {
+		t.Errorf("Expected the changed version in the admin list but found %+v", list)
+	}
+
+	// Changed versions can't be uploaded again with other content even once
+	// they're removed.
+	if err := os.RemoveAll(filepath.Join(settings.PokemonVersionDir, "2.0.0")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := uploadVersion(versions.Storage, LayoutVersion, versions.Ledger, "2.0.0", strings.NewReader("other"), sha512Hex("other"), nil); !errors.Is(err, errVersionExists) {
+		t.Errorf("Expected %v but found %v", errVersionExists, err)
+	}
+
+	writeTestVersion(t, settings, "2.0.0", "changed")
+
+	if err := acceptContentChange(versions, "1.0.0", sha512Hex("1.0.0")); !errors.Is(err, errNoContentChange) {
+		t.Errorf("Expected %v but found %v", errNoContentChange, err)
+	}
+
+	if err := acceptContentChange(versions, "2.0.0", sha512Hex("2.0.0")); !errors.Is(err, errContentMismatch) {
+		t.Errorf("Expected %v but found %v", errContentMismatch, err)
+	}
+
+	if err := acceptContentChange(versions, "2.0.0", strings.ToUpper(sha512Hex("changed"))); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err := updateVersions(logger, settings, versions, metrics); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if metadata, exists := getMetadata(versions, "2.0.0"); !exists || metadata.Sha512 != sha512Hex("changed") {
+		t.Errorf("Expected the accepted version 2.0.0 but found %+v", metadata)
+	}
+
+	if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0", "2.0.0"}) {
+		t.Errorf("Expected [1.0.0 2.0.0] but found %v", offered)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The content type of the Prometheus text exposition format.
+const MetricsContentType string = "text/plain; version=0.0.4; charset=utf-8"
+
+// Upper bounds in seconds of the request latency buckets. Watch requests may
+// block for up to MaxWatchTimeoutSecs, so the buckets extend well past the
+// usual Prometheus defaults.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type RouteStatus struct {
+	// The registered route rather than the requested path to keep the number
+	// of series bounded
+	Route  string
+	Status int
+}
+
+// Request latencies for a single route and status.
+type LatencyHistogram struct {
+	// Counts per bucket in LatencyBuckets. Counts aren't cumulative until
+	// they're written.
+	BucketCounts []uint64
+	// Requests slower than the largest bucket
+	InfCount   uint64
+	SumSeconds float64
+}
+
+// Server metrics exposed at /metrics in the Prometheus text format. Use the
+// Lock when reading and writing data otherwise access will not be
+// thread-safe.
+type Metrics struct {
+	Requests map[RouteStatus]*LatencyHistogram
+	// Bytes of executables served keyed by version
+	BytesServed map[string]uint64
+	// The time and duration of the last updateVersions scan which didn't fail
+	LastScan         time.Time
+	LastScanDuration time.Duration
+	// Executables whose hash couldn't be calculated
+	HashFailures uint64
+	Lock         sync.Mutex
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Requests:    map[RouteStatus]*LatencyHistogram{},
+		BytesServed: map[string]uint64{},
+	}
+}
+
+func (m *Metrics) ObserveRequest(route string, status int, latency time.Duration) {
+
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	key := RouteStatus{Route: route, Status: status}
+	histogram, exists := m.Requests[key]
+
+	if !exists {
+		histogram = &LatencyHistogram{BucketCounts: make([]uint64, len(LatencyBuckets))}
+		m.Requests[key] = histogram
+	}
+
+	seconds := latency.Seconds()
+	histogram.SumSeconds += seconds
+
+	if i, _ := slices.BinarySearch(LatencyBuckets, seconds); i < len(LatencyBuckets) {
+		histogram.BucketCounts[i] += 1
+	} else {
+		histogram.InfCount += 1
+	}
+}
+
+func (m *Metrics) AddBytesServed(version string, bytes int64) {
+
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	m.BytesServed[version] += uint64(bytes)
+}
+
+func (m *Metrics) ObserveScan(start time.Time, duration time.Duration) {
+
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	m.LastScan = start
+	m.LastScanDuration = duration
+}
+
+func (m *Metrics) AddHashFailure() {
+
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	m.HashFailures += 1
+}
+
+// Escapes a Prometheus label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Writes every metric in the Prometheus text format with series in a stable
+// order.
+func (m *Metrics) Write(w io.Writer, versions *VersionsCache) error {
+
+	versions.Lock.RLock()
+	versionCount := len(versions.VersionToMetadataMap)
+	halted := slices.Sorted(maps.Keys(versions.Halted))
+	contentChanged := slices.Sorted(maps.Keys(versions.ContentChanged))
+	versions.Lock.RUnlock()
+
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+
+	var out strings.Builder
+
+	header := func(name string, metricType string, help string) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	}
+
+	keys := slices.SortedFunc(maps.Keys(m.Requests), func(a RouteStatus, b RouteStatus) int {
+
+		if a.Route != b.Route {
+			return strings.Compare(a.Route, b.Route)
+		}
+
+		return a.Status - b.Status
+	})
+
+	labels := func(key RouteStatus) string {
+		return fmt.Sprintf("route=\"%s\",status=\"%d\"", labelEscaper.Replace(key.Route), key.Status)
+	}
+
+	header("pokemon_server_http_requests_total", "counter", "Requests by route and status.")
+
+	for _, key := range keys {
+		histogram := m.Requests[key]
+		count := histogram.InfCount
+
+		for _, bucketCount := range histogram.BucketCounts {
+			count += bucketCount
+		}
+
+		fmt.Fprintf(&out, "pokemon_server_http_requests_total{%s} %d\n", labels(key), count)
+	}
+
+	header("pokemon_server_http_request_duration_seconds", "histogram", "Request latencies by route and status.")
+
+	for _, key := range keys {
+		histogram := m.Requests[key]
+		cumulative := uint64(0)
+
+		for i, bound := range LatencyBuckets {
+			cumulative += histogram.BucketCounts[i]
+			fmt.Fprintf(&out, "pokemon_server_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels(key), formatFloat(bound), cumulative)
+		}
+
+		cumulative += histogram.InfCount
+		fmt.Fprintf(&out, "pokemon_server_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(key), cumulative)
+		fmt.Fprintf(&out, "pokemon_server_http_request_duration_seconds_sum{%s} %s\n", labels(key), formatFloat(histogram.SumSeconds))
+		fmt.Fprintf(&out, "pokemon_server_http_request_duration_seconds_count{%s} %d\n", labels(key), cumulative)
+	}
+
+	header("pokemon_server_bytes_served_total", "counter", "Bytes of executables served by version.")
+
+	for _, version := range slices.Sorted(maps.Keys(m.BytesServed)) {
+		fmt.Fprintf(&out, "pokemon_server_bytes_served_total{version=\"%s\"} %d\n", labelEscaper.Replace(version), m.BytesServed[version])
+	}
+
+	header("pokemon_server_versions", "gauge", "Versions available in the versions cache.")
+	fmt.Fprintf(&out, "pokemon_server_versions %d\n", versionCount)
+
+	header("pokemon_server_rollout_halted", "gauge", "Versions withdrawn because too many clients failed to update to them.")
+
+	for _, version := range halted {
+		fmt.Fprintf(&out, "pokemon_server_rollout_halted{version=\"%s\"} 1\n", labelEscaper.Replace(version))
+	}
+
+	header("pokemon_server_version_content_changed", "gauge", "Versions withheld because their executables changed since they were first found.")
+
+	for _, version := range contentChanged {
+		fmt.Fprintf(&out, "pokemon_server_version_content_changed{version=\"%s\"} 1\n", labelEscaper.Replace(version))
+	}
+
+	// Omit the scan time until the first scan rather than reporting 1970.
+	if !m.LastScan.IsZero() {
+		header("pokemon_server_last_scan_timestamp_seconds", "gauge", "Unix time of the last successful scan for versions.")
+		fmt.Fprintf(&out, "pokemon_server_last_scan_timestamp_seconds %s\n", strconv.FormatFloat(float64(m.LastScan.UnixMilli())/1000, 'f', 3, 64))
+		header("pokemon_server_last_scan_duration_seconds", "gauge", "Duration of the last successful scan for versions.")
+		fmt.Fprintf(&out, "pokemon_server_last_scan_duration_seconds %s\n", formatFloat(m.LastScanDuration.Seconds()))
+	}
+
+	header("pokemon_server_hash_failures_total", "counter", "Executables whose hash couldn't be calculated or didn't match their blob.")
+	fmt.Fprintf(&out, "pokemon_server_hash_failures_total %d\n", m.HashFailures)
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// Records the status and size of a response.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+
+	if r.Status == 0 {
+		r.Status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Wraps the handler to record the latency and status of each request to the
+// route.
+func instrument(metrics *Metrics, route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		recorder := &ResponseRecorder{ResponseWriter: w}
+		handler(recorder, r)
+
+		status := recorder.Status
+
+		// Handlers which write nothing respond with 200.
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		metrics.ObserveRequest(route, status, time.Since(start))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestMetrics(t *testing.T, metrics *Metrics, versions *VersionsCache) string {
+
+	var out strings.Builder
+
+	if err := metrics.Write(&out, versions); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return out.String()
+}
+
+func expectMetricLines(t *testing.T, output string, lines ...string) {
+
+	for _, line := range lines {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("Expected %s but found:\n%s", line, output)
+		}
+	}
+}
+
+func TestInstrumentRecordsRequestsByRouteAndStatus(t *testing.T) {
+
+	metrics := NewMetrics()
+	handler := instrument(metrics, "/v1.0/metadata/pokemon", func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Query().Get("version") == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte("{}"))
+	})
+
+	for _, target := range []string{"/v1.0/metadata/pokemon?version=1.0.0", "/v1.0/metadata/pokemon?version=2.0.0", "/v1.0/metadata/pokemon"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	// Writing nothing responds with 200.
+	instrument(metrics, "/healthcheck", func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthcheck", nil))
+
+	_, versions := newTestVersions(t, "1.0.0")
+	output := writeTestMetrics(t, metrics, versions)
+
+	expectMetricLines(t, output,
+		"# TYPE pokemon_server_http_requests_total counter",
+		`pokemon_server_http_requests_total{route="/healthcheck",status="200"} 1`,
+		`pokemon_server_http_requests_total{route="/v1.0/metadata/pokemon",status="200"} 2`,
+		`pokemon_server_http_requests_total{route="/v1.0/metadata/pokemon",status="404"} 1`,
+		"# TYPE pokemon_server_http_request_duration_seconds histogram",
+		`pokemon_server_http_request_duration_seconds_bucket{route="/v1.0/metadata/pokemon",status="200",le="300"} 2`,
+		`pokemon_server_http_request_duration_seconds_bucket{route="/v1.0/metadata/pokemon",status="200",le="+Inf"} 2`,
+		`pokemon_server_http_request_duration_seconds_count{route="/v1.0/metadata/pokemon",status="200"} 2`,
+	)
+
+	if strings.Index(output, `route="/healthcheck"`) > strings.Index(output, `route="/v1.0/metadata/pokemon"`) {
+		t.Errorf("Expected series sorted by route but found:\n%s", output)
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+
+	metrics := NewMetrics()
+	metrics.ObserveRequest("/", http.StatusOK, 10*time.Millisecond)
+	metrics.ObserveRequest("/", http.StatusOK, 2*time.Second)
+	metrics.ObserveRequest("/", http.StatusOK, 10*time.Minute)
+
+	_, versions := newTestVersions(t, "1.0.0")
+
+	expectMetricLines(t, writeTestMetrics(t, metrics, versions),
+		`pokemon_server_http_request_duration_seconds_bucket{route="/",status="200",le="0.005"} 0`,
+		`pokemon_server_http_request_duration_seconds_bucket{route="/",status="200",le="0.01"} 1`,
+		`pokemon_server_http_request_duration_seconds_bucket{route="/",status="200",le="2.5"} 2`,
+		`pokemon_server_http_request_duration_seconds_bucket{route="/",status="200",le="300"} 2`,
+		`pokemon_server_http_request_duration_seconds_bucket{route="/",status="200",le="+Inf"} 3`,
+		`pokemon_server_http_request_duration_seconds_sum{route="/",status="200"} 602.01`,
+	)
+}
+
+func TestMetricsRecordScansHashFailuresAndBytesServed(t *testing.T) {
+
+	metrics := NewMetrics()
+	settings, versions := newTestVersions(t, "1.0.0")
+
+	// Hashing a dir fails.
+	if err := os.MkdirAll(filepath.Join(settings.PokemonVersionDir, "2.0.0", Pokemon), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	writeTestVersion(t, settings, "3.0.0", "3.0.0")
+
+	if output := writeTestMetrics(t, metrics, versions); strings.Contains(output, "last_scan") {
+		t.Errorf("Expected no scan metrics before the first scan but found:\n%s", output)
+	}
+
+	before := time.Now()
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, metrics); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if metrics.LastScan.Before(before) || metrics.LastScanDuration <= 0 {
+		t.Errorf("Expected a scan after %s but found %s taking %s", before, metrics.LastScan, metrics.LastScanDuration)
+	}
+
+	metrics.AddBytesServed("3.0.0", 5)
+	metrics.AddBytesServed("3.0.0", 5)
+	metrics.AddBytesServed("1.0.0", 3)
+
+	expectMetricLines(t, writeTestMetrics(t, metrics, versions),
+		`pokemon_server_bytes_served_total{version="1.0.0"} 3`,
+		`pokemon_server_bytes_served_total{version="3.0.0"} 10`,
+		"pokemon_server_versions 2",
+		"# TYPE pokemon_server_last_scan_timestamp_seconds gauge",
+		"pokemon_server_hash_failures_total 1",
+	)
+
+	// Failed scans aren't recorded.
+	lastScan := metrics.LastScan
+	versions.Storage = NewFileStorage(filepath.Join(settings.PokemonVersionDir, "missing"))
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, metrics); err == nil || metrics.LastScan != lastScan {
+		t.Errorf("Expected a failed scan not to change %s but found %s: %v", lastScan, metrics.LastScan, err)
+	}
+}
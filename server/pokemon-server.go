@@ -4,16 +4,24 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"maps"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,7 +40,8 @@ func printUsage(flags []common.CliFlag) {
 type Settings struct {
 	// The port for the server to listen on
 	Port uint16
-	// The directory containing the versions of executables available for download
+	// The directory containing the versions of executables available for
+	// download with "file" Storage
 	// .
 	// ├── 1.0.0/
 	// │     └── pokemon
@@ -43,35 +52,132 @@ type Settings struct {
 	// └── 3.0.0/
 	//       └──  pokemon
 	PokemonVersionDir string
+	// Where versions are stored: "file" (the default) for PokemonVersionDir,
+	// "s3" for an S3-compatible bucket, "zip" for the read-only zip archive
+	// StorageArchive, or "embed" for the read-only versions embedded in the
+	// server. Each has the same layout as PokemonVersionDir.
+	Storage string
+	// The endpoint of the bucket for "s3" Storage such as
+	// http://localhost:9000. Credentials are read from the
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+	S3Endpoint string
+	S3Bucket   string
+	// The prefix of each version's keys in the bucket such as "pokemon/"
+	S3Prefix string
+	// The region to sign requests for. Defaults to DefaultS3Region.
+	S3Region string
+	// The zip archive for "zip" Storage
+	StorageArchive string
+	// The layout uploaded versions are stored in: "version" (the default) for
+	// each executable in its version dir or "blob" for executables in the
+	// BlobDir named by their Sha-512 so that identical executables are only
+	// stored once. Versions in either layout are always found.
+	VersionLayout string
+	// The dir to keep a gzip-compressed copy of each executable in so that
+	// clients which accept gzip download less. Executables are compressed
+	// when they're found, and copies of executables which are no longer found
+	// are removed, so the dir must only be used by this server. If empty,
+	// executables are always served uncompressed.
+	CompressedDir string
 	// The interval in seconds to wait before checking for new versions
 	VersionCheckIntervalSecs uint64
+	// The interval in seconds that clients should wait between update checks.
+	// If 0, clients use their own interval.
+	ClientPollIntervalSecs uint64
 	// The directory where logs files should be written. If empty, logs will be written to os.Stderr
 	LogsDir string
 	// The log level
 	LogsLevel string
+	// The file to save the census of clients to so that it survives restarts.
+	// If empty, the census is only kept in memory.
+	CensusFile string
+	// Comma-separated windows such as "1h,24h" to count active clients in.
+	// Clients inactive for longer than the largest window are forgotten.
+	// Defaults to DefaultCensusWindows.
+	CensusWindows string
+	// The file to append update outcomes reported by clients to. If empty,
+	// reports are only counted in memory.
+	ReportsFile string
+	// The size in bytes of the reports file before it is rotated. Defaults to
+	// DefaultReportsFileMaxBytes.
+	ReportsFileMaxBytes int64
+	// Stop offering a version once more than this fraction of clients fail to
+	// update to it such as 0.25. If 0, rollouts are only halted for versions
+	// with a HaltFailureRate in their Rollout.
+	HaltFailureRate float64
+	// The number of distinct clients which must report an outcome for a
+	// version before it may be halted. Defaults to DefaultHaltMinAttempts.
+	HaltMinAttempts uint64
+	// The file containing the bearer token which authorizes admin requests. If
+	// empty, the admin API is disabled.
+	AdminTokenFile string
+	// The file to record the hash of every version found in so that versions
+	// can't change across restarts. If empty, hashes are only recorded in
+	// memory.
+	LedgerFile string
 }
 
 // Cache of version data to avoid unnecessary allocations and recalculations
 // Use the Lock when reading and writing data otherwise access will not be
 // thread-safe.
 type VersionsCache struct {
-	Versions           common.SemanticVersions
-	Json               []byte
-	VersionToSha512Map map[string]string
-	Lock               sync.RWMutex
+	// The versions offered to clients which excludes Halted versions
+	Versions             common.SemanticVersions
+	Json                 []byte
+	VersionToMetadataMap map[string]common.Metadata
+	VersionToRolloutMap  map[string]Rollout
+	// The name of each version's executable in the Storage which is a blob
+	// for versions in the blob layout
+	VersionToFileMap map[string]string
+	// Versions withdrawn because too many clients failed to update to them
+	// with the outcomes which caused the halt
+	Halted map[string]VersionOutcomes
+	// Versions withheld because their executables changed since they were
+	// first found. They're excluded from VersionToMetadataMap so that they're
+	// neither offered nor downloaded.
+	ContentChanged map[string]ContentChange
+	// The hashes of every version ever found
+	Ledger *Ledger
+	// The executables hashed by the last scan keyed by name so that each
+	// scan only downloads S3 objects whose ETag changed
+	Hashes map[string]HashedFile
+	// Where the versions are stored
+	Storage Storage
+	// Entity tag identifying the current Json for conditional requests
+	ETag string
+	// The time that the Json last changed
+	LastModified time.Time
+	// Closed and replaced whenever the versions change so that clients
+	// watching for new versions can be notified.
+	Changed chan struct{}
+	Lock    sync.RWMutex
+	// Serializes scans so that a slower scan which started before an admin
+	// change can't replace the later scan's versions or prune its compressed
+	// files.
+	ScanLock sync.Mutex
 }
 
-// Gets the Sha-512 hash for a particular version
-func getSha512(versions *VersionsCache, version string) string {
+// Gets the metadata for a particular version
+func getMetadata(versions *VersionsCache, version string) (common.Metadata, bool) {
 	versions.Lock.RLock()
 	defer versions.Lock.RUnlock()
-	sha512, exists := versions.VersionToSha512Map[version]
+	metadata, exists := versions.VersionToMetadataMap[version]
+	return metadata, exists
+}
 
-	if exists {
-		return sha512
-	} else {
-		return ""
-	}
+// Gets the name of a version's executable in the Storage
+func getFileName(versions *VersionsCache, version string) string {
+	versions.Lock.RLock()
+	defer versions.Lock.RUnlock()
+	return versions.VersionToFileMap[version]
+}
+
+// Gets the change to a version withheld because its content changed
+func getContentChange(versions *VersionsCache, version string) (ContentChange, bool) {
+	versions.Lock.RLock()
+	defer versions.Lock.RUnlock()
+	change, changed := versions.ContentChanged[version]
+	return change, changed
 }
 
 type VersionMessage struct {
@@ -79,6 +185,58 @@ type VersionMessage struct {
 	Version string `json:"version"`
 }
 
+type Message struct {
+	Msg string `json:"message"`
+}
+
+// Responds with the status and a message explaining it.
+func writeMessage(logger *slog.Logger, w http.ResponseWriter, r *http.Request, status int, message string) {
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(Message{Msg: message}); err != nil {
+		logger.Warn("Error response failed for", "url", r.URL, "error", err)
+	}
+}
+
+// Responds with 404 and a message explaining that the version doesn't exist
+// or 409 if the version is withheld because its content changed.
+func writeVersionNotFound(logger *slog.Logger, w http.ResponseWriter, r *http.Request, versions *VersionsCache, version string) {
+
+	if _, changed := getContentChange(versions, version); changed {
+		writeMessage(logger, w, r, http.StatusConflict, "The version's content changed since it was published, so it is withheld.")
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json := json.NewEncoder(w)
+	err := json.Encode(VersionMessage{
+		Msg:     "The requested version does not exist.",
+		Version: version,
+	})
+
+	if err != nil {
+		logger.Warn("Error response failed for", "url", r.URL, "error", err)
+	}
+}
+
+// Reads the optional detached signature stored next to an executable and
+// encodes it in base64. Returns an empty string if the executable is unsigned.
+func readSignature(storage Storage, name string) (string, error) {
+
+	signature, err := fs.ReadFile(storage, name+common.SignatureSuffix)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
 // Reads a Json file into the provided value object. If the file is larger than
 // maxSize, this method returns an error and the value struct is invalid
 func readJsonFile[T any](filePath string, maxSize int64, value *T) error {
@@ -89,11 +247,18 @@ func readJsonFile[T any](filePath string, maxSize int64, value *T) error {
 	}
 	defer file.Close()
 
-	limitedReader := io.LimitReader(file, maxSize)
+	return readJson(file, maxSize, value)
+}
+
+// Reads Json into the provided value object. If the Json is larger than
+// maxSize, this method returns an error and the value struct is invalid
+func readJson[T any](reader io.Reader, maxSize int64, value *T) error {
+
+	limitedReader := io.LimitReader(reader, maxSize)
 
 	decoder := json.NewDecoder(limitedReader)
 
-	err = decoder.Decode(&value)
+	err := decoder.Decode(&value)
 	if err != nil {
 		return err
 	}
@@ -101,8 +266,7 @@ func readJsonFile[T any](filePath string, maxSize int64, value *T) error {
 	return nil
 }
 
-// Searches the filesystem for versions under settings.PokemonVersionsDir with
-// the structure:
+// Searches the version storage for versions with the structure:
 // .
 // ├── 1.0.0/
 // │     └── pokemon
@@ -114,11 +278,37 @@ func readJsonFile[T any](filePath string, maxSize int64, value *T) error {
 //
 //	└──  pokemon
 //
+// or with the blob layout where each manifest names a blob by its Sha-512:
+// .
+// ├── blobs/
+// │     └── 3bafbf08...
+// |
+// └── 1.0.0/
+//
+//	└──  manifest.json
+//
+// Each blob is only hashed once no matter how many versions share it. If
+// Settings.CompressedDir is set, each executable is compressed once it's
+// found.
 // If the versions found are different than the previous version, this method
 // updates the cache with the latest version information. Returns true if the
-// cache was updated.
-func updateVersions(logger *slog.Logger, settings *Settings, versions *VersionsCache) (updated bool, err error) {
-	entries, err := os.ReadDir(settings.PokemonVersionDir)
+// cache was updated. Successful scans and hash failures are recorded in the
+// metrics. Versions whose hash differs from the hash in the ledger are
+// withheld until an admin accepts the change. Only one scan runs at a time.
+func updateVersions(logger *slog.Logger, settings *Settings, versions *VersionsCache, metrics *Metrics) (updated bool, err error) {
+
+	versions.ScanLock.Lock()
+	defer versions.ScanLock.Unlock()
+
+	start := time.Now()
+
+	defer func() {
+		if err == nil {
+			metrics.ObserveScan(start, time.Since(start))
+		}
+	}()
+
+	entries, err := versions.Storage.ReadDir(".")
 
 	if err != nil {
 		return false, err
@@ -126,67 +316,375 @@ func updateVersions(logger *slog.Logger, settings *Settings, versions *VersionsC
 
 	// Find all versions and calculate all hashes prior to obtaining the locks
 	// to minimize time spent holding the write lock.
-	availableVersions := common.SemVers(make([]common.SemVer, 0, len(entries)))
-	versionToSha512Map := make(map[string]string, len(entries))
+	versionToMetadataMap := make(map[string]common.Metadata, len(entries))
+	versionToRolloutMap := make(map[string]Rollout, len(entries))
+	versionToFileMap := make(map[string]string, len(entries))
+	contentChanged := make(map[string]ContentChange)
+	fileToMetadataMap := make(map[string]common.Metadata, len(entries))
+	hashes := make(map[string]HashedFile, len(entries))
+
+	versions.Lock.RLock()
+	previousHashes := versions.Hashes
+	versions.Lock.RUnlock()
 
 	for _, entry := range entries {
 		possibleVersion := entry.Name()
 
-		version, err := common.ParseSemVer(possibleVersion)
+		if possibleVersion == BlobDir {
+			continue
+		}
+
+		_, err := common.ParseSemVer(possibleVersion)
 
 		if err != nil {
 			logger.Warn(fmt.Sprintf("Ignoring invalid version: %s", possibleVersion), "error", err)
 			continue
 		}
 
-		path := filepath.Join(settings.PokemonVersionDir, entry.Name(), Pokemon)
-		pokemonFile, err := os.Open(path)
+		name := path.Join(possibleVersion, Pokemon)
+		fileName, expectedSha512, err := versionFile(versions.Storage, possibleVersion)
+
+		if err != nil {
+			logger.Warn("Ignoring version with unreadable manifest.", "file_name", path.Join(possibleVersion, ManifestFileName), "error", err)
+			continue
+		}
+
+		file, hashed := fileToMetadataMap[fileName]
+
+		if !hashed {
+
+			hashed, err := hashVersionFile(logger, versions.Storage, fileName, previousHashes, metrics)
+
+			if err != nil {
+				continue
+			}
+
+			file = hashed.Metadata
+			hashes[fileName] = hashed
+			fileToMetadataMap[fileName] = file
 
-		if err != nil && os.IsNotExist(err) {
-			logger.Warn("Ignoring version with missing pokemon binary.", "file_name", path, "error", err)
+			if settings.CompressedDir != "" {
+				if err := compressExecutable(versions.Storage, fileName, file.Sha512, settings.CompressedDir); err != nil {
+					logger.Warn("Failed to compress pokemon binary. Serving it uncompressed.", "file_name", fileName, "error", err)
+				}
+			}
+		}
+
+		if expectedSha512 != "" && file.Sha512 != expectedSha512 {
+			metrics.AddHashFailure()
+			logger.Warn(fmt.Sprintf("Ignoring version whose blob doesn't match its %s.", common.Sha512Name), "file_name", fileName, "found_sha512", file.Sha512)
 			continue
-		} else if err != nil {
-			logger.Warn("Error reading pokemon binary.", "file_name", path, "error", err)
+		}
+
+		sha512 := file.Sha512
+		signature, err := readSignature(versions.Storage, name)
+
+		if err != nil {
+			logger.Warn("Ignoring version with unreadable signature.", "file_name", name+common.SignatureSuffix, "error", err)
 			continue
 		}
 
-		sha512, err := common.Sha512Hash(pokemonFile)
-		pokemonFile.Close()
+		rollout, err := readRollout(versions.Storage, possibleVersion)
 
 		if err != nil {
-			logger.Warn(fmt.Sprintf("Failed to obtain %s", common.Sha512Name), "file_name", path, "error", err)
+			logger.Warn("Ignoring version with unreadable rollout.", "file_name", path.Join(possibleVersion, RolloutFileName), "error", err)
 			continue
 		}
 
-		versionToSha512Map[possibleVersion] = sha512
+		recordedSha512, err := versions.Ledger.Record(possibleVersion, sha512, time.Now().UTC())
+
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to save ledger to %s", versions.Ledger.Path), "error", err)
+		}
+
+		if recordedSha512 != sha512 {
+			contentChanged[possibleVersion] = ContentChange{RecordedSha512: recordedSha512, FoundSha512: sha512}
+			continue
+		}
+
+		versionToRolloutMap[possibleVersion] = rollout
+		versionToFileMap[possibleVersion] = fileName
+
+		versionToMetadataMap[possibleVersion] = common.Metadata{
+			Version:   possibleVersion,
+			Sha512:    sha512,
+			Size:      file.Size,
+			Signature: signature,
+		}
+
+	}
+
+	if settings.CompressedDir != "" {
+
+		sha512s := make(map[string]bool, len(fileToMetadataMap))
 
-		availableVersions = append(availableVersions, version)
+		for _, file := range fileToMetadataMap {
+			sha512s[file.Sha512] = true
+		}
+
+		if err := pruneCompressed(settings.CompressedDir, sha512s); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to remove unused compressed binaries from %s", settings.CompressedDir), "error", err)
+		}
 	}
 
-	if maps.Equal(versionToSha512Map, versions.VersionToSha512Map) {
+	// Only executables which still exist are kept so that removed ones are
+	// hashed again if they return.
+	versions.Lock.Lock()
+	versions.Hashes = hashes
+	versions.Lock.Unlock()
+
+	versions.Lock.RLock()
+	unchanged := maps.Equal(versionToMetadataMap, versions.VersionToMetadataMap) &&
+		maps.Equal(versionToRolloutMap, versions.VersionToRolloutMap) &&
+		maps.Equal(versionToFileMap, versions.VersionToFileMap) &&
+		maps.Equal(contentChanged, versions.ContentChanged)
+	versions.Lock.RUnlock()
+
+	if unchanged {
 		return false, nil
 	}
 
-	sort.Sort(availableVersions)
+	// Minimal write locking here to replace the old values.
+	versions.Lock.Lock()
+	defer versions.Lock.Unlock()
+
+	for version, change := range contentChanged {
+		if _, alerted := versions.ContentChanged[version]; !alerted {
+			logger.Error(fmt.Sprintf("VERSION CONTENT CHANGED. Withholding version %s until its content is restored or the change is accepted.", version),
+				"version", version, "recorded_sha512", change.RecordedSha512, "found_sha512", change.FoundSha512)
+		}
+	}
+
+	for version := range versions.ContentChanged {
+		if _, changed := contentChanged[version]; !changed {
+			logger.Warn(fmt.Sprintf("No longer withholding version %s since its content changed back or it was removed.", version), "version", version)
+		}
+	}
+
+	versions.VersionToMetadataMap = versionToMetadataMap
+	versions.VersionToRolloutMap = versionToRolloutMap
+	versions.VersionToFileMap = versionToFileMap
+	versions.ContentChanged = contentChanged
+
+	if err = versions.publish(); err != nil {
+		logger.Warn("Unable to convert versions to JSON", "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// An executable's hash with the S3 ETag it had when it was hashed.
+type HashedFile struct {
+	Metadata common.Metadata
+	ETag     string
+}
+
+// Gets the hash and size of a version's executable. Only objects in S3 reuse
+// the previously hashed files, and only if their ETag hasn't changed, so that
+// unchanged objects aren't downloaded again. Local files are always hashed
+// since an executable overwritten with the same size and modification time
+// must still be found by the ledger. Failures are logged and hash failures are
+// recorded in the metrics.
+func hashVersionFile(logger *slog.Logger, storage Storage, name string, hashes map[string]HashedFile, metrics *Metrics) (HashedFile, error) {
+
+	pokemonFile, err := storage.Open(name)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("Ignoring version with missing pokemon binary.", "file_name", name, "error", err)
+		return HashedFile{}, err
+	} else if err != nil {
+		logger.Warn("Error reading pokemon binary.", "file_name", name, "error", err)
+		return HashedFile{}, err
+	}
+
+	defer pokemonFile.Close()
+	stat, err := pokemonFile.Stat()
+
+	if err != nil {
+		logger.Warn("Failed to obtain pokemon binary size.", "file_name", name, "error", err)
+		return HashedFile{}, err
+	}
+
+	etag := ""
+
+	if object, isObject := stat.(*s3FileInfo); isObject {
+		etag = object.etag
+	}
+
+	if hashed, exists := hashes[name]; exists && etag != "" && hashed.ETag == etag {
+		return hashed, nil
+	}
+
+	sha512, err := common.Sha512Hash(pokemonFile)
+
+	if err != nil {
+		metrics.AddHashFailure()
+		logger.Warn(fmt.Sprintf("Failed to obtain %s", common.Sha512Name), "file_name", name, "error", err)
+		return HashedFile{}, err
+	}
+
+	return HashedFile{
+		Metadata: common.Metadata{Sha512: sha512, Size: stat.Size()},
+		ETag:     etag,
+	}, nil
+}
+
+// Updates the versions offered to clients from the metadata, excluding halted
+// and yanked versions, and wakes up clients watching for new versions.
+// Requires the write Lock.
+func (versions *VersionsCache) publish() error {
+
+	offeredVersions := common.SemVers(make([]common.SemVer, 0, len(versions.VersionToMetadataMap)))
+
+	for possibleVersion := range versions.VersionToMetadataMap {
+
+		if _, halted := versions.Halted[possibleVersion]; halted || versions.VersionToRolloutMap[possibleVersion].Yanked {
+			continue
+		}
+
+		if version, err := common.ParseSemVer(possibleVersion); err == nil {
+			offeredVersions = append(offeredVersions, version)
+		}
+	}
+
+	sort.Sort(offeredVersions)
 	allVersions := common.SemanticVersions{
-		All: availableVersions,
+		All: offeredVersions,
 	}
 	versionsJson, err := json.Marshal(&allVersions)
 
 	if err != nil {
-		logger.Warn(fmt.Sprintf("Unable to convert versions to JSON %s", allVersions), "error", err)
-		return false, err
+		return err
 	}
 
-	// Minimal write locking here to replace the old values.
-	versions.Lock.Lock()
-	defer versions.Lock.Unlock()
-
 	versions.Versions = allVersions
-	versions.VersionToSha512Map = versionToSha512Map
 	versions.Json = versionsJson
 
-	return true, nil
+	// Only change the ETag when the JSON actually changed, since clients
+	// don't see the hashes in the versions response. Rollouts may change the
+	// versions offered to some clients without changing the JSON, so the
+	// time always changes.
+	versions.ETag = fmt.Sprintf("\"%x\"", sha256.Sum256(versionsJson))
+	versions.LastModified = time.Now().UTC().Truncate(time.Second)
+
+	// Wake up any clients watching for new versions.
+	if versions.Changed != nil {
+		close(versions.Changed)
+	}
+
+	versions.Changed = make(chan struct{})
+
+	return nil
+}
+
+// Gets the versions offered to the client according to each version's
+// rollout. Requires the read Lock.
+func (versions *VersionsCache) offeredTo(client VersionsClient) common.SemanticVersions {
+
+	offered := common.SemVers(make([]common.SemVer, 0, len(versions.Versions.All)))
+
+	for _, version := range versions.Versions.All {
+		if versions.VersionToRolloutMap[version.String].Offers(version.String, client) {
+			offered = append(offered, version)
+		}
+	}
+
+	return common.SemanticVersions{All: offered}
+}
+
+// Blocks until the latest version offered to the client differs from
+// knownVersion, the versions change, the timeout elapses, or the context is
+// cancelled.
+func waitForNewVersion(ctx context.Context, versions *VersionsCache, client VersionsClient, knownVersion string, timeout time.Duration) {
+
+	versions.Lock.RLock()
+	changed := versions.Changed
+	latestVersion := ""
+
+	if all := versions.offeredTo(client).All; len(all) > 0 {
+		latestVersion = all[len(all)-1].String
+	}
+
+	versions.Lock.RUnlock()
+
+	if latestVersion != knownVersion {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Returns true if the If-None-Match header value matches the entity tag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes the versions JSON along with the client polling interval. If the
+// client already has the current versions, responds with 304 Not Modified
+// instead.
+func writeVersions(w http.ResponseWriter, r *http.Request, settings *Settings, versions *VersionsCache) {
+
+	if settings.ClientPollIntervalSecs > 0 {
+		w.Header().Add(common.PollIntervalName, strconv.FormatUint(settings.ClientPollIntervalSecs, 10))
+	}
+
+	versions.Lock.RLock()
+	defer versions.Lock.RUnlock()
+
+	versionsJson := versions.Json
+	etag := versions.ETag
+
+	// Clients which aren't offered every version get their own JSON and ETag.
+	if offered := versions.offeredTo(newVersionsClient(r)); len(offered.All) != len(versions.Versions.All) {
+
+		var err error
+		versionsJson, err = json.Marshal(&offered)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		etag = fmt.Sprintf("\"%x\"", sha256.Sum256(versionsJson))
+	}
+
+	// Clients may cache the versions but must revalidate them every time.
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("ETag", etag)
+	w.Header().Add("Last-Modified", versions.LastModified.Format(http.TimeFormat))
+
+	notModified := false
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		notModified = etagMatches(ifNoneMatch, etag)
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		notModified = !versions.LastModified.After(since)
+	}
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(versionsJson)
 }
 
 func logRequest(logger *slog.Logger, r *http.Request) {
@@ -194,8 +692,12 @@ func logRequest(logger *slog.Logger, r *http.Request) {
 }
 
 const Pokemon string = "pokemon"
+
 const MB int64 = 1024 * 1024
 
+// The longest time a client may wait for new versions in a single request.
+const MaxWatchTimeoutSecs uint64 = 5 * 60
+
 func main() {
 
 	// Define CLI args:
@@ -208,9 +710,21 @@ func main() {
 	exampleSettings := Settings{
 		Port:                     1234,
 		PokemonVersionDir:        "/path/to/pokemon/versions/dir",
+		Storage:                  StorageFile,
+		VersionLayout:            LayoutVersion,
+		CompressedDir:            "/path/to/compressed/dir",
 		VersionCheckIntervalSecs: 15,
+		ClientPollIntervalSecs:   60,
 		LogsDir:                  "/path/to/logs/dir",
 		LogsLevel:                "WARN",
+		CensusFile:               "/path/to/census.json",
+		CensusWindows:            DefaultCensusWindows,
+		ReportsFile:              "/path/to/reports.jsonl",
+		ReportsFileMaxBytes:      DefaultReportsFileMaxBytes,
+		HaltFailureRate:          0.25,
+		HaltMinAttempts:          DefaultHaltMinAttempts,
+		AdminTokenFile:           "/path/to/admin-token",
+		LedgerFile:               "/path/to/ledger.json",
 	}
 	settingsJson, err := json.MarshalIndent(&exampleSettings, "\t", "\t")
 	if err != nil {
@@ -223,10 +737,17 @@ func main() {
 		Description: fmt.Sprintf("The JSON settings file for the server. You can configure the following settings in this file:\n\t%s", settingsJson),
 	}
 
-	flags := []common.CliFlag{helpFlag, settingsFlag}
+	migrateFlag := common.CliFlag{
+		Name:        "--migrate-blobs",
+		Short:       "-m",
+		Description: "Move the executable of every version in the storage into the blob layout and exit. Set VersionLayout to \"blob\" afterwards so that uploads use the blob layout too.",
+	}
+
+	flags := []common.CliFlag{helpFlag, settingsFlag, migrateFlag}
 
 	var settings Settings
 	var settingsDir string
+	migrate := false
 
 	args := os.Args
 
@@ -236,6 +757,8 @@ func main() {
 		case helpFlag.Name, helpFlag.Short:
 			printUsage(flags)
 			return
+		case migrateFlag.Name, migrateFlag.Short:
+			migrate = true
 		case settingsFlag.Name, settingsFlag.Short:
 			if i+1 >= len(args) {
 				break
@@ -267,6 +790,30 @@ func main() {
 			if !filepath.IsAbs(settings.PokemonVersionDir) {
 				settings.PokemonVersionDir = filepath.Join(settingsDir, settings.PokemonVersionDir)
 			}
+
+			if settings.CensusFile != "" && !filepath.IsAbs(settings.CensusFile) {
+				settings.CensusFile = filepath.Join(settingsDir, settings.CensusFile)
+			}
+
+			if settings.ReportsFile != "" && !filepath.IsAbs(settings.ReportsFile) {
+				settings.ReportsFile = filepath.Join(settingsDir, settings.ReportsFile)
+			}
+
+			if settings.AdminTokenFile != "" && !filepath.IsAbs(settings.AdminTokenFile) {
+				settings.AdminTokenFile = filepath.Join(settingsDir, settings.AdminTokenFile)
+			}
+
+			if settings.LedgerFile != "" && !filepath.IsAbs(settings.LedgerFile) {
+				settings.LedgerFile = filepath.Join(settingsDir, settings.LedgerFile)
+			}
+
+			if settings.StorageArchive != "" && !filepath.IsAbs(settings.StorageArchive) {
+				settings.StorageArchive = filepath.Join(settingsDir, settings.StorageArchive)
+			}
+
+			if settings.CompressedDir != "" && !filepath.IsAbs(settings.CompressedDir) {
+				settings.CompressedDir = filepath.Join(settingsDir, settings.CompressedDir)
+			}
 		default:
 			if len(args[i]) == 0 || args[i][0] == '-' {
 				fmt.Fprintf(os.Stderr, "Invalid flag: \"%s\"\n\n", args[i])
@@ -282,6 +829,14 @@ func main() {
 		os.Exit(64)
 	}
 
+	switch settings.VersionLayout {
+	case "", LayoutVersion, LayoutBlob:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid version layout \"%s\". Expected \"%s\" or \"%s\".\n\n", settings.VersionLayout, LayoutVersion, LayoutBlob)
+		printUsage(flags)
+		os.Exit(64)
+	}
+
 	// Initialize Logger.
 	var logWriter io.Writer
 
@@ -319,20 +874,93 @@ func main() {
 		Level: level,
 	}))
 
+	censusWindows, err := parseCensusWindows(settings.CensusWindows)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid census windows \"%s\":\n%v\n\n", settings.CensusWindows, err)
+		printUsage(flags)
+		os.Exit(64)
+	}
+
+	census, err := loadCensus(settings.CensusFile, censusWindows[len(censusWindows)-1])
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load census from \"%s\":\n%v\n\n", settings.CensusFile, err)
+		os.Exit(1)
+	}
+
+	reports, err := openReports(settings.ReportsFile, settings.ReportsFileMaxBytes)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open reports file \"%s\":\n%v\n\n", settings.ReportsFile, err)
+		os.Exit(1)
+	}
+
+	reportLimiter := NewReportLimiter(MaxReportsPerWindow, ReportLimitWindow)
+
+	adminToken := ""
+
+	if settings.AdminTokenFile != "" {
+
+		adminToken, err = readAdminToken(settings.AdminTokenFile)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read admin token from \"%s\":\n%v\n\n", settings.AdminTokenFile, err)
+			os.Exit(1)
+		}
+	}
+
+	ledger, err := loadLedger(settings.LedgerFile)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load ledger from \"%s\":\n%v\n\n", settings.LedgerFile, err)
+		os.Exit(1)
+	}
+
+	storage, err := newStorage(&settings)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s storage:\n%v\n\n", settings.Storage, err)
+		os.Exit(1)
+	}
+
+	if migrate {
+
+		migrated, err := migrateToBlobs(storage)
+
+		for _, version := range migrated {
+			fmt.Printf("Migrated version %s to %s\n", version, path.Join(version, ManifestFileName))
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate versions in %s to the blob layout:\n%v\n\n", storage, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Migrated %d versions in %s to the blob layout\n", len(migrated), storage)
+		return
+	}
+
 	// Find CLI versions:
-	versions := VersionsCache{}
+	versions := VersionsCache{Ledger: ledger, Storage: storage}
+	metrics := NewMetrics()
 
-	updated, err := updateVersions(logger, &settings, &versions)
+	updated, err := updateVersions(logger, &settings, &versions, metrics)
 
 	if !updated || err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to find initial versions from pokemon version dir \"%s\":\n%v\n\n", settings.PokemonVersionDir, err)
+		fmt.Fprintf(os.Stderr, "Failed to find initial versions from \"%s\":\n%v\n\n", storage, err)
 		printUsage(flags)
 		os.Exit(1)
 	} else {
+		haltRollouts(logger, &settings, &versions, reports.Stats())
 		logger.Info(fmt.Sprintf("Updated versions. Found: %s", versions.Versions))
 	}
 
-	// Initialize Endpoints:
+	// Initialize Endpoints. Every endpoint records request metrics:
+	handle := func(route string, handler http.HandlerFunc) {
+		http.HandleFunc(route, instrument(metrics, route, handler))
+	}
+
 	healthcheckHandler := func(w http.ResponseWriter, r *http.Request) {
 
 		logRequest(logger, r)
@@ -345,13 +973,13 @@ func main() {
 		}
 	}
 
-	http.HandleFunc("/", healthcheckHandler)
-	http.HandleFunc("/ping", healthcheckHandler)
-	http.HandleFunc("/healthcheck", healthcheckHandler)
+	handle("/", healthcheckHandler)
+	handle("/ping", healthcheckHandler)
+	handle("/healthcheck", healthcheckHandler)
 
 	// Versions enpoint that publishes the versions of the CLI tool which can
 	// be downloaded:
-	http.HandleFunc(fmt.Sprintf("/v1.0/versions/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+	handle(fmt.Sprintf("/v1.0/versions/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
 
 		logRequest(logger, r)
 
@@ -359,48 +987,425 @@ func main() {
 			w.WriteHeader(http.StatusForbidden)
 		}
 
-		w.Header().Add("Content-Type", "application/json")
-
-		versions.Lock.RLock()
-		defer versions.Lock.RUnlock()
-		w.Write(versions.Json)
+		census.CheckIn(r.Header.Get, time.Now())
+		writeVersions(w, r, &settings, &versions)
 	})
 
-	// Download endpoint which serves the CLI executable binary:
-	http.HandleFunc(fmt.Sprintf("/v1.0/downloads/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+	// Watch endpoint which blocks until a version other than the client's
+	// latest known version is available or the timeout elapses. Responds
+	// with the same data as the versions endpoint so clients can avoid
+	// polling:
+	handle(fmt.Sprintf("/v1.0/watch/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
 
 		logRequest(logger, r)
 
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		timeoutSecs, err := strconv.ParseUint(r.URL.Query().Get("timeout"), 10, 64)
+
+		if err != nil || timeoutSecs == 0 || timeoutSecs > MaxWatchTimeoutSecs {
+			timeoutSecs = MaxWatchTimeoutSecs
+		}
+
+		waitForNewVersion(r.Context(), &versions, newVersionsClient(r), r.URL.Query().Get("version"), time.Duration(timeoutSecs)*time.Second)
+
+		writeVersions(w, r, &settings, &versions)
+	})
+
+	// Metadata endpoint which describes a version of the CLI executable so
+	// clients can verify previously downloaded binaries without downloading
+	// them again:
+	handle(fmt.Sprintf("/v1.0/metadata/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+
+		logRequest(logger, r)
+
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.WriteHeader(http.StatusForbidden)
+			return
 		}
 
 		version := r.URL.Query().Get("version")
-		sha512 := getSha512(&versions, version)
+		metadata, exists := getMetadata(&versions, version)
 
-		if sha512 == "" {
-			w.WriteHeader(http.StatusNotFound)
-			w.Header().Add("Content-Type", "application/json")
-			json := json.NewEncoder(w)
-			err := json.Encode(VersionMessage{
-				Msg:     "The requested version does not exist.",
-				Version: version,
-			})
+		if !exists {
+			writeVersionNotFound(logger, w, r, &versions, version)
+			return
+		}
 
-			if err != nil {
-				logger.Warn("Error response failed for", "url", r.URL, "error", err)
-			}
+		w.Header().Add("Content-Type", "application/json")
+		w.Header().Add(common.Sha512Name, metadata.Sha512)
+		json.NewEncoder(w).Encode(metadata)
+	})
+
+	// Download endpoint which serves the CLI executable binary:
+	handle(fmt.Sprintf("/v1.0/downloads/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
 
+		logRequest(logger, r)
+
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		version := r.URL.Query().Get("version")
+		metadata, exists := getMetadata(&versions, version)
+
+		if !exists {
+			writeVersionNotFound(logger, w, r, &versions, version)
 			return
 		}
 
 		w.Header().Add("Content-Type", "application/octet-stream")
 		w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=pokemon-%s", version))
-		w.Header().Add(common.Sha512Name, sha512)
+		w.Header().Add(common.Sha512Name, metadata.Sha512)
 
 		// TODO potentially cache the latest file in memory since it's the most
 		// likely to be requested.
-		http.ServeFile(w, r, fmt.Sprintf("%s/%s/pokemon", settings.PokemonVersionDir, version))
+		recorder := &ResponseRecorder{ResponseWriter: w}
+		serveExecutable(recorder, r, storage, getFileName(&versions, version), metadata.Sha512, settings.CompressedDir)
+		metrics.AddBytesServed(version, recorder.Bytes)
+	})
+
+	// Blob endpoint which serves the CLI executable binary by its Sha-512.
+	// Blobs never change, so clients and caches can share them across
+	// versions:
+	handle(fmt.Sprintf("/v1.0/blobs/%s/{sha512}", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+
+		logRequest(logger, r)
+
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		sha512 := strings.ToLower(r.PathValue("sha512"))
+		version, name, exists := getBlob(&versions, sha512)
+
+		if !exists {
+			writeMessage(logger, w, r, http.StatusNotFound, "The requested blob does not exist.")
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/octet-stream")
+		w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s", Pokemon))
+		w.Header().Add("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Add("ETag", fmt.Sprintf("\"%s\"", sha512))
+		w.Header().Add(common.Sha512Name, sha512)
+
+		recorder := &ResponseRecorder{ResponseWriter: w}
+		serveExecutable(recorder, r, storage, name, sha512, settings.CompressedDir)
+		metrics.AddBytesServed(version, recorder.Bytes)
+	})
+
+	// Stats endpoint which counts the clients that checked for updates by
+	// version and platform during each census window or the requested
+	// window:
+	handle(fmt.Sprintf("/v1.0/stats/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+
+		logRequest(logger, r)
+
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		windows := censusWindows
+
+		if window := r.URL.Query().Get("window"); window != "" {
+
+			duration, err := time.ParseDuration(window)
+
+			if err != nil || duration < time.Second || duration > census.Retention {
+				writeMessage(logger, w, r, http.StatusBadRequest, fmt.Sprintf("The window must be a duration such as 30m between 1s and %s.", census.Retention))
+				return
+			}
+
+			windows = []time.Duration{duration}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(census.Stats(windows, time.Now().UTC()))
+	})
+
+	// Reports endpoint which accepts update outcomes from clients and responds
+	// with the outcomes and failure rates of each version:
+	handle(fmt.Sprintf("/v1.0/reports/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+
+		logRequest(logger, r)
+
+		switch r.Method {
+		case "GET":
+			w.Header().Add("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reports.Stats())
+		case "POST":
+			if wait := reportLimiter.Allow(r.RemoteAddr, time.Now()); wait > 0 {
+				w.Header().Set("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+				writeMessage(logger, w, r, http.StatusTooManyRequests, "Too many reports. Try again later.")
+				return
+			}
+
+			var report common.Report
+
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxReportSize)).Decode(&report); err != nil {
+				writeMessage(logger, w, r, http.StatusBadRequest, "The report must be a JSON object.")
+				return
+			}
+
+			if err := validateReport(&report, &versions); err != nil {
+				writeMessage(logger, w, r, http.StatusBadRequest, fmt.Sprintf("The report is invalid: %v.", err))
+				return
+			}
+
+			// Client IDs are self-asserted, so only clients which checked in
+			// count toward halting rollouts.
+			if !census.Has(report.ClientId) {
+				report.ClientId = ""
+			}
+
+			if err := reports.Add(report, time.Now().UTC()); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to write report to %s", settings.ReportsFile), "error", err)
+			}
+
+			haltRollouts(logger, &settings, &versions, reports.Stats())
+
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+
+	// Admin endpoints which manage versions. Every request must have the
+	// admin bearer token:
+	if adminToken != "" {
+
+		// Serializes changes so that concurrent requests don't overwrite each
+		// other's rollouts.
+		var adminLock sync.Mutex
+
+		handleAdmin := func(route string, handler http.HandlerFunc) {
+			handle(route, func(w http.ResponseWriter, r *http.Request) {
+
+				logRequest(logger, r)
+
+				if !isAdmin(r, adminToken) {
+					w.Header().Add("WWW-Authenticate", "Bearer")
+					writeMessage(logger, w, r, http.StatusUnauthorized, "The admin token is missing or invalid.")
+					return
+				}
+
+				adminLock.Lock()
+				defer adminLock.Unlock()
+
+				handler(w, r)
+			})
+		}
+
+		// Applies admin changes immediately rather than waiting for the next
+		// scan.
+		refreshVersions := func() {
+
+			if _, err := updateVersions(logger, &settings, &versions, metrics); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to update versions from %s", storage), "error", err)
+			}
+
+			haltRollouts(logger, &settings, &versions, reports.Stats())
+		}
+
+		writeRollout := func(w http.ResponseWriter, r *http.Request, change func(rollout *Rollout)) {
+
+			version := r.URL.Query().Get("version")
+			rollout, err := changeRollout(&versions, version, change)
+
+			if errors.Is(err, errVersionNotFound) {
+				writeVersionNotFound(logger, w, r, &versions, version)
+				return
+			} else if errors.Is(err, errReadOnlyStorage) {
+				writeMessage(logger, w, r, http.StatusForbidden, "The version storage is read-only.")
+				return
+			} else if err != nil {
+				logger.Error(fmt.Sprintf("Failed to change rollout of version %s", version), "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			logger.Warn(fmt.Sprintf("Changed rollout of version %s", version), "rollout", rollout, "ip address", r.RemoteAddr)
+			refreshVersions()
+
+			w.Header().Add("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rollout)
+		}
+
+		// Lists every version with its metadata and rollout or uploads a
+		// version with its Sha-512 hash and optional signature in headers:
+		handleAdmin(fmt.Sprintf("/v1.0/admin/versions/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+
+			switch r.Method {
+			case "GET":
+				w.Header().Add("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(listAdminVersions(&versions))
+			case "PUT":
+				version := r.URL.Query().Get("version")
+				signature, err := base64.StdEncoding.DecodeString(r.Header.Get(common.SignatureName))
+
+				if err != nil {
+					writeMessage(logger, w, r, http.StatusBadRequest, fmt.Sprintf("The %s header must be base64 encoded.", common.SignatureName))
+					return
+				}
+
+				created, err := uploadVersion(storage, settings.VersionLayout, versions.Ledger, version, http.MaxBytesReader(w, r.Body, MaxUploadSize), r.Header.Get(common.Sha512Name), signature)
+				var invalidUpload *InvalidUploadError
+
+				if errors.As(err, &invalidUpload) {
+					writeMessage(logger, w, r, http.StatusBadRequest, fmt.Sprintf("The upload is invalid: %v.", err))
+					return
+				} else if errors.Is(err, errVersionExists) {
+					writeMessage(logger, w, r, http.StatusConflict, "The version already exists with different content. Publish a new version instead.")
+					return
+				} else if errors.Is(err, errReadOnlyStorage) {
+					writeMessage(logger, w, r, http.StatusForbidden, "The version storage is read-only.")
+					return
+				} else if err != nil {
+					logger.Error(fmt.Sprintf("Failed to upload version %s", version), "error", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				refreshVersions()
+				metadata, _ := getMetadata(&versions, version)
+				w.Header().Add("Content-Type", "application/json")
+
+				// Uploading an identical version again succeeds without
+				// changing it.
+				if created {
+					logger.Warn(fmt.Sprintf("Uploaded version %s", version), "ip address", r.RemoteAddr)
+					w.WriteHeader(http.StatusCreated)
+				}
+
+				json.NewEncoder(w).Encode(metadata)
+			default:
+				w.WriteHeader(http.StatusForbidden)
+			}
+		})
+
+		// Accepts the changed content of a version withheld because its
+		// content changed. The Sha-512 header must have the hash of the
+		// changed content:
+		handleAdmin(fmt.Sprintf("/v1.0/admin/accept/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Method != "POST" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			version := r.URL.Query().Get("version")
+			err := acceptContentChange(&versions, version, r.Header.Get(common.Sha512Name))
+
+			if errors.Is(err, errNoContentChange) {
+				writeMessage(logger, w, r, http.StatusNotFound, "The version's content has not changed.")
+				return
+			} else if errors.Is(err, errContentMismatch) {
+				writeMessage(logger, w, r, http.StatusConflict, fmt.Sprintf("The %s header doesn't match the version's current content.", common.Sha512Name))
+				return
+			} else if err != nil {
+				logger.Error(fmt.Sprintf("Failed to accept the changed content of version %s", version), "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			logger.Warn(fmt.Sprintf("Accepted the changed content of version %s", version), "sha512", r.Header.Get(common.Sha512Name), "ip address", r.RemoteAddr)
+			refreshVersions()
+
+			metadata, _ := getMetadata(&versions, version)
+			w.Header().Add("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(metadata)
+		})
+
+		// Stops or resumes offering a version to every client:
+		for _, yanked := range []bool{true, false} {
+
+			route := fmt.Sprintf("/v1.0/admin/yank/%s", Pokemon)
+
+			if !yanked {
+				route = fmt.Sprintf("/v1.0/admin/unyank/%s", Pokemon)
+			}
+
+			handleAdmin(route, func(w http.ResponseWriter, r *http.Request) {
+
+				if r.Method != "POST" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				writeRollout(w, r, func(rollout *Rollout) {
+					rollout.Yanked = yanked
+				})
+			})
+		}
+
+		// Sets the percentage of clients and the channel a version is offered
+		// to or resumes a halted rollout. Omitted parameters are unchanged:
+		handleAdmin(fmt.Sprintf("/v1.0/admin/rollout/%s", Pokemon), func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Method != "POST" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			query := r.URL.Query()
+			percentage, err := strconv.ParseUint(query.Get("percentage"), 10, 64)
+
+			if query.Has("percentage") && (err != nil || percentage > 100) {
+				writeMessage(logger, w, r, http.StatusBadRequest, "The percentage must be a whole number from 0 to 100.")
+				return
+			}
+
+			if channel := query.Get("channel"); !clientChannelPattern.MatchString(channel) {
+				writeMessage(logger, w, r, http.StatusBadRequest, "The channel may only contain lowercase letters, digits, and dashes.")
+				return
+			}
+
+			resume, err := strconv.ParseBool(query.Get("resume"))
+
+			if query.Has("resume") && err != nil {
+				writeMessage(logger, w, r, http.StatusBadRequest, "Resume must be true or false.")
+				return
+			}
+
+			writeRollout(w, r, func(rollout *Rollout) {
+
+				if query.Has("percentage") {
+					rollout.Percentage = percentage
+				}
+
+				if query.Has("channel") {
+					rollout.Channel = query.Get("channel")
+				}
+
+				if query.Has("resume") {
+					rollout.Resume = resume
+				}
+			})
+		})
+	}
+
+	// Metrics endpoint in the Prometheus text format:
+	handle("/metrics", func(w http.ResponseWriter, r *http.Request) {
+
+		logRequest(logger, r)
+
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Add("Content-Type", MetricsContentType)
+
+		if err := metrics.Write(w, &versions); err != nil {
+			logger.Warn("Failed to write metrics", "error", err)
+		}
 	})
 
 	// Background thread to update versions. This thread may be killed at any
@@ -408,10 +1413,15 @@ func main() {
 	// used for writing external data to the filesystem.
 	go func() {
 		for {
-			updated, err := updateVersions(logger, &settings, &versions)
+			updated, err := updateVersions(logger, &settings, &versions, metrics)
+
+			// Rollouts may be resumed or have new thresholds after a scan.
+			if haltRollouts(logger, &settings, &versions, reports.Stats()) {
+				updated = true
+			}
 
 			if err != nil {
-				logger.Warn(fmt.Sprintf("Failed to update versions from %s", settings.PokemonVersionDir), "error", err)
+				logger.Warn(fmt.Sprintf("Failed to update versions from %s", storage), "error", err)
 			} else if updated {
 				logger.Info(fmt.Sprintf("Updated versions. Found %s", versions.Versions))
 			} else {
@@ -422,6 +1432,23 @@ func main() {
 		}
 	}()
 
+	// Background thread to forget inactive clients and save the census.
+	// Saves are atomic, so this thread may be killed at any time.
+	go func() {
+		for {
+			time.Sleep(time.Duration(CensusSaveIntervalSecs) * time.Second)
+			census.Prune(time.Now())
+
+			if settings.CensusFile == "" {
+				continue
+			}
+
+			if err := census.Save(settings.CensusFile); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to save census to %s", settings.CensusFile), "error", err)
+			}
+		}
+	}()
+
 	fmt.Printf("Listening on port: %d\n", settings.Port)
 	err = http.ListenAndServe(fmt.Sprintf(":%d", settings.Port), nil)
 
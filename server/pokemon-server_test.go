@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Creates a fake pokemon binary for the version in the settings' version dir.
+func writeTestVersion(t *testing.T, settings *Settings, version string, content string) {
+
+	dir := filepath.Join(settings.PokemonVersionDir, version)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, Pokemon), []byte(content), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func newTestVersions(t *testing.T, versions ...string) (*Settings, *VersionsCache) {
+
+	settings := &Settings{
+		PokemonVersionDir: t.TempDir(),
+	}
+
+	for _, version := range versions {
+		writeTestVersion(t, settings, version, version)
+	}
+
+	cache := &VersionsCache{Ledger: NewLedger(""), Storage: NewFileStorage(settings.PokemonVersionDir)}
+
+	if _, err := updateVersions(newTestLogger(), settings, cache, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return settings, cache
+}
+
+func TestUpdateVersionsWaitsForRunningScan(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0")
+	versions.ScanLock.Lock()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+			t.Errorf("%v", err)
+		}
+	}()
+
+	// Another scan is running, so this one waits and then finds the version
+	// written in the meantime.
+	writeTestVersion(t, settings, "2.0.0", "2.0.0")
+
+	select {
+	case <-done:
+		t.Fatalf("Expected the scan to wait for the running scan")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	versions.ScanLock.Unlock()
+	<-done
+
+	if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0", "2.0.0"}) {
+		t.Errorf("Expected [1.0.0 2.0.0] but found %v", offered)
+	}
+}
+
+func TestWaitForNewVersionReturnsImmediatelyForStaleVersion(t *testing.T) {
+
+	_, versions := newTestVersions(t, "1.0.0", "2.0.0")
+
+	start := time.Now()
+	waitForNewVersion(context.Background(), versions, VersionsClient{}, "1.0.0", time.Minute)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected an immediate return but waited %s", elapsed)
+	}
+}
+
+func TestWaitForNewVersionTimesOut(t *testing.T) {
+
+	_, versions := newTestVersions(t, "1.0.0")
+
+	start := time.Now()
+	waitForNewVersion(context.Background(), versions, VersionsClient{}, "1.0.0", 50*time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected to wait for the timeout but waited %s", elapsed)
+	}
+}
+
+func TestWaitForNewVersionWakesOnUpdate(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0")
+
+	done := make(chan struct{})
+
+	go func() {
+		waitForNewVersion(context.Background(), versions, VersionsClient{}, "1.0.0", time.Minute)
+		close(done)
+	}()
+
+	writeTestVersion(t, settings, "2.0.0", "2.0.0")
+
+	if updated, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); !updated || err != nil {
+		t.Fatalf("Expected versions to update: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Errorf("Expected watcher to wake up after versions changed")
+	}
+}
+
+func getVersions(settings *Settings, versions *VersionsCache, ifNoneMatch string) *httptest.ResponseRecorder {
+
+	r := httptest.NewRequest("GET", "/v1.0/versions/pokemon", nil)
+
+	if ifNoneMatch != "" {
+		r.Header.Set("If-None-Match", ifNoneMatch)
+	}
+
+	w := httptest.NewRecorder()
+	writeVersions(w, r, settings, versions)
+	return w
+}
+
+func TestVersionsConditionalRequests(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0")
+
+	resp := getVersions(settings, versions, "")
+	etag := resp.Header().Get("ETag")
+
+	if resp.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with an ETag but found %d with \"%s\"", resp.Code, etag)
+	}
+
+	if expected := "{\"versions\":[\"1.0.0\"]}"; resp.Body.String() != expected {
+		t.Errorf("Expected %s but found %s", expected, resp.Body.String())
+	}
+
+	if resp.Header().Get("Last-Modified") == "" || resp.Header().Get("Cache-Control") == "" {
+		t.Errorf("Expected caching headers but found %v", resp.Header())
+	}
+
+	resp = getVersions(settings, versions, etag)
+
+	if resp.Code != http.StatusNotModified || resp.Body.Len() != 0 {
+		t.Errorf("Expected 304 with no body but found %d with %s", resp.Code, resp.Body.String())
+	}
+
+	resp = getVersions(settings, versions, "\"stale\", W/"+etag)
+
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for weak ETag list but found %d", resp.Code)
+	}
+
+	// Changing only a hash doesn't change the JSON, so the ETag stays the same
+	// once the republished version is accepted.
+	writeTestVersion(t, settings, "1.0.0", "republished")
+	versions.Ledger.Accept("1.0.0", sha512Hex("republished"), time.Now())
+	updateVersions(newTestLogger(), settings, versions, NewMetrics())
+
+	if resp = getVersions(settings, versions, etag); resp.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 after hash change but found %d", resp.Code)
+	}
+
+	writeTestVersion(t, settings, "2.0.0", "2.0.0")
+	updateVersions(newTestLogger(), settings, versions, NewMetrics())
+	resp = getVersions(settings, versions, etag)
+
+	if resp.Code != http.StatusOK || resp.Header().Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag but found %d with %s", resp.Code, resp.Header().Get("ETag"))
+	}
+}
+
+func TestUpdateVersionsRecordsMetadata(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0")
+
+	signaturePath := filepath.Join(settings.PokemonVersionDir, "1.0.0", Pokemon+common.SignatureSuffix)
+
+	if err := os.WriteFile(signaturePath, []byte("signed"), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if updated, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); !updated || err != nil {
+		t.Fatalf("Expected signature to update versions: %v", err)
+	}
+
+	metadata, exists := getMetadata(versions, "1.0.0")
+
+	if !exists {
+		t.Fatalf("Expected metadata for 1.0.0")
+	}
+
+	if metadata.Version != "1.0.0" || metadata.Size != int64(len("1.0.0")) || len(metadata.Sha512) != 128 || metadata.Signature != "c2lnbmVk" {
+		t.Errorf("Unexpected metadata %+v", metadata)
+	}
+
+	if _, exists := getMetadata(versions, "2.0.0"); exists {
+		t.Errorf("Expected no metadata for 2.0.0")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The largest report body accepted from a client.
+const MaxReportSize int64 = 16 * 1024
+
+// The longest error kept from a report. Longer errors are truncated.
+const MaxReportErrorLength int = 1024
+
+// The size of the reports file before it is rotated if
+// Settings.ReportsFileMaxBytes is 0.
+const DefaultReportsFileMaxBytes int64 = 10 * 1024 * 1024
+
+// The number of rotated reports files to keep such as reports.jsonl.1.
+const MaxReportsBackups int = 3
+
+// The most reports accepted from a single remote address per
+// ReportLimitWindow so that one caller can't halt rollouts by itself.
+const MaxReportsPerWindow int = 30
+
+const ReportLimitWindow time.Duration = time.Minute
+
+// Counts reports from each remote address during a fixed window. Counts are
+// cleared when the window ends so that memory is bounded by the addresses
+// seen during a single window. Use the Lock when reading and writing data
+// otherwise access will not be thread-safe.
+type ReportLimiter struct {
+	Limit       int
+	Window      time.Duration
+	WindowStart time.Time
+	Counts      map[string]int
+	Lock        sync.Mutex
+}
+
+func NewReportLimiter(limit int, window time.Duration) *ReportLimiter {
+	return &ReportLimiter{
+		Limit:  limit,
+		Window: window,
+		Counts: map[string]int{},
+	}
+}
+
+// Records a report from the remote address such as 192.0.2.1:1234. Returns 0
+// if the report is allowed or else how long until the window ends.
+func (l *ReportLimiter) Allow(remoteAddr string, now time.Time) time.Duration {
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+
+	if err != nil {
+		host = remoteAddr
+	}
+
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	if now.Sub(l.WindowStart) >= l.Window {
+		l.WindowStart = now
+		clear(l.Counts)
+	}
+
+	if l.Counts[host] >= l.Limit {
+		return l.WindowStart.Add(l.Window).Sub(now)
+	}
+
+	l.Counts[host] += 1
+	return 0
+}
+
+// A report as written to a line of the reports file.
+type StoredReport struct {
+	common.Report
+	Time time.Time `json:"time"`
+}
+
+// Update outcomes reported for a single version.
+type VersionOutcomes struct {
+	Updates          uint64 `json:"updates"`
+	DownloadFailures uint64 `json:"downloadFailures"`
+	StartFailures    uint64 `json:"startFailures"`
+	// Clients which fell back from this version to another
+	Rollbacks uint64 `json:"rollbacks"`
+	// The distinct clients which reported an outcome for this version. Reports
+	// without a client ID aren't counted.
+	Clients uint64 `json:"clients"`
+	// The distinct clients whose latest outcome for this version was a failed
+	// download, a failed start, or a rollback
+	FailedClients uint64 `json:"failedClients"`
+	// FailedClients divided by Clients
+	FailureRate float64 `json:"failureRate"`
+}
+
+type ReportStats struct {
+	Versions map[string]VersionOutcomes `json:"versions"`
+}
+
+// Update outcomes reported by clients keyed by version. Reports are appended
+// to a file which is rotated once it exceeds MaxBytes. Use the Lock when
+// reading and writing data otherwise access will not be thread-safe.
+type Reports struct {
+	Versions map[string]VersionOutcomes
+	// The latest stage reported by each client keyed by version then client
+	// ID so that a client which reports repeatedly only counts once
+	ClientStages map[string]map[string]string
+	// The reports file or empty if reports are only kept in memory
+	Path     string
+	MaxBytes int64
+	File     *os.File
+	Size     int64
+	Lock     sync.Mutex
+}
+
+func NewReports() *Reports {
+	return &Reports{
+		Versions:     map[string]VersionOutcomes{},
+		ClientStages: map[string]map[string]string{},
+		MaxBytes:     DefaultReportsFileMaxBytes,
+	}
+}
+
+// Checks that a report from a client is well-formed and relates to a known
+// version and truncates its error.
+func validateReport(report *common.Report, versions *VersionsCache) error {
+
+	switch report.Stage {
+	case common.ReportStageUpdated, common.ReportStageDownload, common.ReportStageStart, common.ReportStageRollback:
+	default:
+		return fmt.Errorf("unknown stage \"%s\"", report.Stage)
+	}
+
+	if _, err := common.ParseSemVer(report.To); err != nil {
+		return fmt.Errorf("invalid to version \"%s\"", report.To)
+	}
+
+	if _, err := common.ParseSemVer(report.From); report.From != "" && err != nil {
+		return fmt.Errorf("invalid from version \"%s\"", report.From)
+	}
+
+	if _, exists := getMetadata(versions, reportedVersion(*report)); !exists {
+		return fmt.Errorf("unknown version \"%s\"", reportedVersion(*report))
+	}
+
+	if report.ClientId != "" && !clientIdPattern.MatchString(report.ClientId) {
+		return fmt.Errorf("invalid client ID")
+	}
+
+	if !clientPlatformPattern.MatchString(report.Platform) {
+		return fmt.Errorf("invalid platform \"%s\"", report.Platform)
+	}
+
+	if len(report.Error) > MaxReportErrorLength {
+		report.Error = strings.ToValidUTF8(report.Error[:MaxReportErrorLength], "")
+	}
+
+	return nil
+}
+
+// Gets the version a report relates to. Rollbacks relate to the version
+// which was rolled back from.
+func reportedVersion(report common.Report) string {
+
+	if report.Stage == common.ReportStageRollback {
+		return report.From
+	}
+
+	return report.To
+}
+
+// Counts the report against the version it relates to. Requires the Lock.
+func (r *Reports) count(report common.Report) {
+
+	version := reportedVersion(report)
+
+	if version == "" {
+		return
+	}
+
+	outcomes := r.Versions[version]
+
+	switch report.Stage {
+	case common.ReportStageUpdated:
+		outcomes.Updates += 1
+	case common.ReportStageDownload:
+		outcomes.DownloadFailures += 1
+	case common.ReportStageStart:
+		outcomes.StartFailures += 1
+	case common.ReportStageRollback:
+		outcomes.Rollbacks += 1
+	}
+
+	r.Versions[version] = outcomes
+
+	if report.ClientId == "" {
+		return
+	}
+
+	if r.ClientStages[version] == nil {
+		r.ClientStages[version] = map[string]string{}
+	}
+
+	r.ClientStages[version][report.ClientId] = report.Stage
+}
+
+// Moves reports.jsonl to reports.jsonl.1, reports.jsonl.1 to reports.jsonl.2,
+// and so on, dropping the oldest file, then opens a new reports file. Requires
+// the Lock.
+func (r *Reports) rotate() error {
+
+	if r.File != nil {
+		r.File.Close()
+		r.File = nil
+	}
+
+	for i := MaxReportsBackups; i > 0; i-- {
+
+		from := r.Path
+
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", r.Path, i-1)
+		}
+
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", r.Path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return r.open()
+}
+
+// Opens the reports file for appending. Requires the Lock.
+func (r *Reports) open() error {
+
+	file, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.File = file
+	r.Size = info.Size()
+	return nil
+}
+
+// Counts a validated report and appends it to the reports file. The report is
+// counted even if it can't be written.
+func (r *Reports) Add(report common.Report, now time.Time) error {
+
+	r.Lock.Lock()
+	defer r.Lock.Unlock()
+
+	r.count(report)
+
+	if r.Path == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(StoredReport{Report: report, Time: now})
+
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	// Retry opening the file if it couldn't be opened after the last rotation.
+	if r.File == nil {
+		err = r.open()
+	} else if r.Size > 0 && r.Size+int64(len(line)) > r.MaxBytes {
+		err = r.rotate()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	n, err := r.File.Write(line)
+	r.Size += int64(n)
+	return err
+}
+
+// Gets the outcomes for every version with their failure rates. Failure rates
+// only count the latest outcome of each client so that clients which report
+// the same failure repeatedly or reports without a client ID can't skew them.
+func (r *Reports) Stats() ReportStats {
+
+	r.Lock.Lock()
+	defer r.Lock.Unlock()
+
+	versions := maps.Clone(r.Versions)
+
+	for version, outcomes := range versions {
+
+		for _, stage := range r.ClientStages[version] {
+
+			outcomes.Clients += 1
+
+			if stage != common.ReportStageUpdated {
+				outcomes.FailedClients += 1
+			}
+		}
+
+		if outcomes.Clients > 0 {
+			outcomes.FailureRate = float64(outcomes.FailedClients) / float64(outcomes.Clients)
+		}
+
+		versions[version] = outcomes
+	}
+
+	return ReportStats{Versions: versions}
+}
+
+// Counts the reports in a reports file. Lines which can't be parsed are
+// skipped.
+func (r *Reports) replay(path string) error {
+
+	file, err := os.Open(path)
+
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), int(2*MaxReportSize))
+
+	for scanner.Scan() {
+
+		var stored StoredReport
+
+		if err := json.Unmarshal(scanner.Bytes(), &stored); err != nil {
+			continue
+		}
+
+		r.count(stored.Report)
+	}
+
+	return scanner.Err()
+}
+
+// Opens the reports file, counting the reports already in it and its rotated
+// files so that failure rates survive restarts. An empty path keeps reports
+// in memory.
+func openReports(path string, maxBytes int64) (*Reports, error) {
+
+	reports := NewReports()
+	reports.Path = path
+
+	if maxBytes > 0 {
+		reports.MaxBytes = maxBytes
+	}
+
+	if path == "" {
+		return reports, nil
+	}
+
+	for i := MaxReportsBackups; i > 0; i-- {
+		if err := reports.replay(fmt.Sprintf("%s.%d", path, i)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := reports.replay(path); err != nil {
+		return nil, err
+	}
+
+	if err := reports.open(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func newTestReport(stage string, from string, to string) common.Report {
+	return common.Report{
+		ClientId: "client",
+		Platform: "linux/amd64",
+		From:     from,
+		To:       to,
+		Stage:    stage,
+	}
+}
+
+func TestValidateReport(t *testing.T) {
+
+	_, versions := newTestVersions(t, "1.0.0", "2.0.0")
+	valid := newTestReport(common.ReportStageStart, "", "2.0.0")
+	valid.Error = strings.Repeat("x", MaxReportErrorLength+10)
+
+	if err := validateReport(&valid, versions); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(valid.Error) != MaxReportErrorLength {
+		t.Errorf("Expected the error to be truncated to %d but found %d", MaxReportErrorLength, len(valid.Error))
+	}
+
+	invalid := []common.Report{
+		newTestReport("exploded", "1.0.0", "2.0.0"),
+		newTestReport(common.ReportStageUpdated, "1.0.0", ""),
+		newTestReport(common.ReportStageUpdated, "one", "2.0.0"),
+		newTestReport(common.ReportStageUpdated, "1.0.0", "3.0.0"),
+		newTestReport(common.ReportStageRollback, "3.0.0", "1.0.0"),
+		{ClientId: "a b", Platform: "linux/amd64", To: "2.0.0", Stage: common.ReportStageUpdated},
+		{Platform: "linux", To: "2.0.0", Stage: common.ReportStageUpdated},
+	}
+
+	for _, report := range invalid {
+		if err := validateReport(&report, versions); err == nil {
+			t.Errorf("Expected %v to be invalid", report)
+		}
+	}
+}
+
+func TestReportsFailureRates(t *testing.T) {
+
+	reports := NewReports()
+	now := time.Now()
+
+	stages := []struct {
+		ClientId string
+		Report   common.Report
+	}{
+		{"a", newTestReport(common.ReportStageUpdated, "1.0.0", "2.0.0")},
+		{"b", newTestReport(common.ReportStageUpdated, "1.0.0", "2.0.0")},
+		{"c", newTestReport(common.ReportStageDownload, "1.0.0", "2.0.0")},
+		// Only the latest outcome of each client counts toward the failure rate.
+		{"c", newTestReport(common.ReportStageStart, "1.0.0", "2.0.0")},
+		{"c", newTestReport(common.ReportStageStart, "1.0.0", "2.0.0")},
+		// Rollbacks count against the version which was rolled back from.
+		{"b", newTestReport(common.ReportStageRollback, "2.0.0", "1.0.0")},
+		// Reports without a client ID are counted but don't affect failure
+		// rates.
+		{"", newTestReport(common.ReportStageStart, "1.0.0", "2.0.0")},
+	}
+
+	for _, stage := range stages {
+		stage.Report.ClientId = stage.ClientId
+		reports.Add(stage.Report, now)
+	}
+
+	outcomes := reports.Stats().Versions["2.0.0"]
+	expected := VersionOutcomes{Updates: 2, DownloadFailures: 1, StartFailures: 3, Rollbacks: 1, Clients: 3, FailedClients: 2, FailureRate: 2.0 / 3}
+
+	if outcomes != expected {
+		t.Errorf("Expected %+v but found %+v", expected, outcomes)
+	}
+
+	if _, exists := reports.Stats().Versions["1.0.0"]; exists {
+		t.Errorf("Expected no outcomes for 1.0.0 since rollbacks count against the failed version")
+	}
+}
+
+func TestReportsFileRotatesAndReplays(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+	reports, err := openReports(path, 1)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Every report exceeds the max size, so each one rotates the file.
+	for i := 0; i < MaxReportsBackups+2; i++ {
+		if err := reports.Add(newTestReport(common.ReportStageUpdated, "", "2.0.0"), time.Now()); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	reports.File.Close()
+
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", path, MaxReportsBackups+1)); !os.IsNotExist(err) {
+		t.Errorf("Expected at most %d rotated files but found another: %v", MaxReportsBackups, err)
+	}
+
+	reopened, err := openReports(path, 1)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	defer reopened.File.Close()
+
+	// The oldest report was dropped with the oldest rotated file.
+	if updates := reopened.Stats().Versions["2.0.0"].Updates; updates != uint64(MaxReportsBackups+1) {
+		t.Errorf("Expected %d updates but found %d", MaxReportsBackups+1, updates)
+	}
+}
+
+func TestReportLimiterLimitsEachAddressPerWindow(t *testing.T) {
+
+	limiter := NewReportLimiter(2, time.Minute)
+	now := time.Now()
+
+	for range 2 {
+		if wait := limiter.Allow("192.0.2.1:1234", now); wait != 0 {
+			t.Fatalf("Expected the report to be allowed but found wait %s", wait)
+		}
+	}
+
+	// Other ports on the same address share the limit.
+	if wait := limiter.Allow("192.0.2.1:5678", now.Add(20*time.Second)); wait != 40*time.Second {
+		t.Errorf("Expected to wait %s but found %s", 40*time.Second, wait)
+	}
+
+	if wait := limiter.Allow("[2001:db8::1]:1234", now); wait != 0 {
+		t.Errorf("Expected another address to be allowed but found wait %s", wait)
+	}
+
+	if wait := limiter.Allow("192.0.2.1:1234", now.Add(time.Minute)); wait != 0 {
+		t.Errorf("Expected the report to be allowed in the next window but found wait %s", wait)
+	}
+}
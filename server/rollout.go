@@ -0,0 +1,199 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The optional file next to each pokemon binary which configures the
+// version's rollout such as 1.0.0/rollout.json
+const RolloutFileName string = "rollout.json"
+
+// The number of distinct clients which must report an outcome for a version
+// before it may be halted if Settings.HaltMinAttempts is 0.
+const DefaultHaltMinAttempts uint64 = 10
+
+// The channel of versions and clients which don't specify one.
+const DefaultChannel string = "stable"
+
+// Rollout settings for a single version which can be configured via JSON file
+// in the version's dir.
+type Rollout struct {
+	// Overrides Settings.HaltFailureRate for this version if greater than 0
+	HaltFailureRate float64
+	// Overrides Settings.HaltMinAttempts for this version if greater than 0
+	HaltMinAttempts uint64
+	// Keeps offering the version no matter how many clients fail to update
+	// to it. Set this to resume a halted rollout.
+	Resume bool
+	// Stops offering the version to every client.
+	Yanked bool
+	// The percentage of clients offered the version from 0 to 100. Clients
+	// are chosen by their client ID, so the same clients keep the version as
+	// the percentage grows. Clients without an ID only get fully rolled out
+	// versions.
+	Percentage uint64
+	// Only clients on the channel are offered the version. Clients on other
+	// channels are also offered versions on the DefaultChannel. Defaults to
+	// DefaultChannel.
+	Channel string
+}
+
+func NewRollout() Rollout {
+	return Rollout{Percentage: 100}
+}
+
+// Reads the rollout settings for the version. Versions without rollout
+// settings use the defaults.
+func readRollout(storage Storage, version string) (Rollout, error) {
+
+	rollout := NewRollout()
+	file, err := storage.Open(path.Join(version, RolloutFileName))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewRollout(), nil
+	} else if err != nil {
+		return rollout, err
+	}
+
+	err = readJson(file, MB, &rollout)
+	file.Close()
+
+	if err == nil && rollout.Percentage > 100 {
+		err = fmt.Errorf("percentage %d is greater than 100", rollout.Percentage)
+	}
+
+	return rollout, err
+}
+
+// Channels are restricted so that they're safe to log and use in files. An
+// empty channel is the DefaultChannel.
+var clientChannelPattern = regexp.MustCompile(`^[0-9a-z-]{0,32}$`)
+
+// A client checking for versions which is identified by its census headers.
+type VersionsClient struct {
+	Id      string
+	Channel string
+}
+
+// Gets the client from the census headers of a request.
+func newVersionsClient(r *http.Request) VersionsClient {
+	return VersionsClient{
+		Id:      r.Header.Get(common.ClientIdName),
+		Channel: r.Header.Get(common.ClientChannelName),
+	}
+}
+
+// Places each client in one of 100 buckets for the version so that the
+// clients offered a partial rollout differ from version to version.
+func rolloutBucket(clientId string, version string) uint64 {
+
+	hash := fnv.New32a()
+	hash.Write([]byte(clientId))
+	hash.Write([]byte{0})
+	hash.Write([]byte(version))
+	return uint64(hash.Sum32() % 100)
+}
+
+// Returns true if the version is offered to the client. Halted versions are
+// never offered regardless of their rollout.
+func (rollout Rollout) Offers(version string, client VersionsClient) bool {
+
+	if rollout.Yanked {
+		return false
+	}
+
+	channel := cmp.Or(rollout.Channel, DefaultChannel)
+
+	if channel != DefaultChannel && channel != cmp.Or(client.Channel, DefaultChannel) {
+		return false
+	}
+
+	if rollout.Percentage >= 100 {
+		return true
+	}
+
+	return client.Id != "" && rolloutBucket(client.Id, version) < rollout.Percentage
+}
+
+// Returns true if so many clients failed to update to the version that it
+// should no longer be offered.
+func shouldHalt(settings *Settings, rollout Rollout, outcomes VersionOutcomes) bool {
+
+	if rollout.Resume {
+		return false
+	}
+
+	threshold := settings.HaltFailureRate
+
+	if rollout.HaltFailureRate > 0 {
+		threshold = rollout.HaltFailureRate
+	}
+
+	minAttempts := DefaultHaltMinAttempts
+
+	if rollout.HaltMinAttempts > 0 {
+		minAttempts = rollout.HaltMinAttempts
+	} else if settings.HaltMinAttempts > 0 {
+		minAttempts = settings.HaltMinAttempts
+	}
+
+	return threshold > 0 && outcomes.Clients >= minAttempts && outcomes.FailureRate > threshold
+}
+
+// Withdraws versions whose failure rates exceed their thresholds from the
+// versions clients are offered and restores versions which are resumed.
+// Halted versions may still be downloaded by clients which already know about
+// them. Returns true if the offered versions changed.
+func haltRollouts(logger *slog.Logger, settings *Settings, versions *VersionsCache, stats ReportStats) bool {
+
+	versions.Lock.Lock()
+	defer versions.Lock.Unlock()
+
+	halted := map[string]VersionOutcomes{}
+
+	for version, rollout := range versions.VersionToRolloutMap {
+		if outcomes, exists := stats.Versions[version]; exists && shouldHalt(settings, rollout, outcomes) {
+			halted[version] = outcomes
+		}
+	}
+
+	if slices.Equal(slices.Sorted(maps.Keys(halted)), slices.Sorted(maps.Keys(versions.Halted))) {
+		return false
+	}
+
+	for version, outcomes := range halted {
+		if _, wasHalted := versions.Halted[version]; !wasHalted {
+			logger.Error(fmt.Sprintf("HALTED ROLLOUT of version %s: %.1f%% of clients failed to update. Set Resume in %s in %s to resume.",
+				version, outcomes.FailureRate*100, path.Join(version, RolloutFileName), versions.Storage),
+				"version", version, "updates", outcomes.Updates, "download_failures", outcomes.DownloadFailures,
+				"start_failures", outcomes.StartFailures, "rollbacks", outcomes.Rollbacks,
+				"clients", outcomes.Clients, "failed_clients", outcomes.FailedClients)
+		}
+	}
+
+	for version := range versions.Halted {
+		if _, stillHalted := halted[version]; !stillHalted {
+			logger.Warn(fmt.Sprintf("Resumed rollout of version %s", version), "version", version)
+		}
+	}
+
+	versions.Halted = halted
+
+	if err := versions.publish(); err != nil {
+		logger.Warn("Unable to convert versions to JSON", "error", err)
+	}
+
+	return true
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+func offeredVersions(versions *VersionsCache) []string {
+
+	offered := make([]string, 0, len(versions.Versions.All))
+
+	for _, version := range versions.Versions.All {
+		offered = append(offered, version.String)
+	}
+
+	return offered
+}
+
+func writeTestRollout(t *testing.T, settings *Settings, version string, rollout string) {
+
+	if err := os.WriteFile(filepath.Join(settings.PokemonVersionDir, version, RolloutFileName), []byte(rollout), 0o644); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestShouldHalt(t *testing.T) {
+
+	settings := &Settings{HaltFailureRate: 0.5, HaltMinAttempts: 4}
+	failing := VersionOutcomes{Updates: 1, StartFailures: 3, Clients: 4, FailedClients: 3, FailureRate: 0.75}
+
+	if !shouldHalt(settings, Rollout{}, failing) {
+		t.Errorf("Expected %+v to halt", failing)
+	}
+
+	if shouldHalt(settings, Rollout{HaltMinAttempts: 5}, failing) {
+		t.Errorf("Expected %+v not to halt with too few attempts", failing)
+	}
+
+	if shouldHalt(settings, Rollout{HaltFailureRate: 0.8}, failing) {
+		t.Errorf("Expected %+v not to halt below the version's threshold", failing)
+	}
+
+	if shouldHalt(settings, Rollout{Resume: true}, failing) {
+		t.Errorf("Expected %+v not to halt once resumed", failing)
+	}
+
+	if shouldHalt(&Settings{}, Rollout{}, failing) {
+		t.Errorf("Expected %+v not to halt without a threshold", failing)
+	}
+
+	// A single client which reports the same failure repeatedly isn't enough.
+	repeated := VersionOutcomes{StartFailures: 10, Clients: 1, FailedClients: 1, FailureRate: 1}
+
+	if shouldHalt(settings, Rollout{}, repeated) {
+		t.Errorf("Expected %+v not to halt with too few clients", repeated)
+	}
+}
+
+func TestHaltRolloutsWithdrawsAndResumesVersions(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0", "2.0.0")
+	settings.HaltFailureRate = 0.9
+	settings.HaltMinAttempts = 2
+	logger := newTestLogger()
+	writeTestRollout(t, settings, "2.0.0", "{\"HaltFailureRate\": 0.5, \"Percentage\": 100}")
+
+	if _, err := updateVersions(logger, settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	stats := ReportStats{Versions: map[string]VersionOutcomes{
+		"1.0.0": {Updates: 10, Clients: 10},
+		"2.0.0": {Updates: 1, StartFailures: 2, Clients: 3, FailedClients: 2, FailureRate: 2.0 / 3},
+	}}
+
+	etag := versions.ETag
+
+	if !haltRollouts(logger, settings, versions, stats) {
+		t.Fatalf("Expected 2.0.0 to be halted")
+	}
+
+	if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0"}) {
+		t.Errorf("Expected [1.0.0] but found %v", offered)
+	}
+
+	if strings.Contains(string(versions.Json), "2.0.0") || versions.ETag == etag {
+		t.Errorf("Expected new versions JSON without 2.0.0 but found %s with ETag %s", versions.Json, versions.ETag)
+	}
+
+	// Clients which already know about the halted version can still get it.
+	if _, exists := getMetadata(versions, "2.0.0"); !exists {
+		t.Errorf("Expected the metadata for halted version 2.0.0")
+	}
+
+	if haltRollouts(logger, settings, versions, stats) {
+		t.Errorf("Expected no change when the same version is halted")
+	}
+
+	// Resuming keeps the rest of the rollout.
+	rollout, err := changeRollout(versions, "2.0.0", func(rollout *Rollout) {
+		rollout.Resume = true
+	})
+
+	if err != nil || rollout != (Rollout{HaltFailureRate: 0.5, Percentage: 100, Resume: true}) {
+		t.Fatalf("Expected the resumed rollout to keep its threshold but found %+v: %v", rollout, err)
+	}
+
+	if _, err := updateVersions(logger, settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !haltRollouts(logger, settings, versions, stats) {
+		t.Fatalf("Expected 2.0.0 to be resumed")
+	}
+
+	if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0", "2.0.0"}) {
+		t.Errorf("Expected [1.0.0 2.0.0] but found %v", offered)
+	}
+}
+
+func TestRolloutOffers(t *testing.T) {
+
+	client := VersionsClient{Id: "client"}
+	beta := VersionsClient{Id: "client", Channel: "beta"}
+
+	if !NewRollout().Offers("2.0.0", VersionsClient{}) {
+		t.Errorf("Expected a full rollout to be offered to clients without an ID")
+	}
+
+	if (Rollout{Percentage: 100, Yanked: true}).Offers("2.0.0", client) {
+		t.Errorf("Expected a yanked version not to be offered")
+	}
+
+	if (Rollout{Percentage: 100, Channel: "beta"}).Offers("2.0.0", client) {
+		t.Errorf("Expected a beta version not to be offered on the %s channel", DefaultChannel)
+	}
+
+	if !(Rollout{Percentage: 100, Channel: "beta"}).Offers("2.0.0", beta) || !NewRollout().Offers("2.0.0", beta) {
+		t.Errorf("Expected beta and %s versions to be offered on the beta channel", DefaultChannel)
+	}
+
+	if (Rollout{Percentage: 99}).Offers("2.0.0", VersionsClient{}) || (Rollout{Percentage: 0}).Offers("2.0.0", client) {
+		t.Errorf("Expected partial rollouts not to be offered to clients without an ID or at 0%%")
+	}
+
+	// Roughly half of the clients are offered a 50% rollout, and clients
+	// offered a smaller percentage are still offered a larger one.
+	offered := 0
+
+	for i := 0; i < 1000; i++ {
+
+		client := VersionsClient{Id: fmt.Sprintf("client-%d", i)}
+
+		if (Rollout{Percentage: 50}).Offers("2.0.0", client) {
+			offered += 1
+		} else if (Rollout{Percentage: 10}).Offers("2.0.0", client) {
+			t.Fatalf("Expected %s to be offered 50%% since it was offered 10%%", client.Id)
+		}
+	}
+
+	if offered < 400 || offered > 600 {
+		t.Errorf("Expected about 500 of 1000 clients to be offered a 50%% rollout but found %d", offered)
+	}
+}
+
+func TestWriteVersionsFiltersByRollout(t *testing.T) {
+
+	settings, versions := newTestVersions(t, "1.0.0", "2.0.0")
+	writeTestRollout(t, settings, "2.0.0", "{\"Channel\": \"beta\"}")
+
+	if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	stable := getVersions(settings, versions, "")
+
+	r := httptest.NewRequest("GET", "/v1.0/versions/pokemon", nil)
+	r.Header.Set(common.ClientChannelName, "beta")
+	beta := httptest.NewRecorder()
+	writeVersions(beta, r, settings, versions)
+
+	if body := stable.Body.String(); strings.Contains(body, "2.0.0") {
+		t.Errorf("Expected only 1.0.0 on the %s channel but found %s", DefaultChannel, body)
+	}
+
+	if body := beta.Body.String(); !strings.Contains(body, "2.0.0") {
+		t.Errorf("Expected 2.0.0 on the beta channel but found %s", body)
+	}
+
+	if stable.Header().Get("ETag") == beta.Header().Get("ETag") {
+		t.Errorf("Expected different ETags for different versions but found %s", beta.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The region requests are signed for if none is configured. S3-compatible
+// stores such as MinIO accept it by default.
+const DefaultS3Region string = "us-east-1"
+
+// How long to wait to connect to the S3 endpoint and for it to start
+// responding. Downloads aren't limited once they start since slow clients may
+// take a long time to download an executable.
+const S3DialTimeout time.Duration = 10 * time.Second
+const S3ResponseHeaderTimeout time.Duration = 30 * time.Second
+
+// The Sha-256 hash of an empty payload.
+const emptyPayloadSha256 string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Versions stored in an S3-compatible bucket such as AWS S3 or MinIO with
+// keys in the version dir layout under a prefix. Requests use path-style URLs
+// and are signed with AWS Signature Version 4.
+type S3Storage struct {
+	// The endpoint such as https://s3.us-east-1.amazonaws.com or
+	// http://localhost:9000
+	Endpoint string
+	Bucket   string
+	// The prefix of every key such as "pokemon/" or empty
+	Prefix          string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	Client          *http.Client
+}
+
+func NewS3Storage(endpoint string, bucket string, prefix string, region string, accessKeyId string, secretAccessKey string) (*S3Storage, error) {
+
+	if endpoint == "" || bucket == "" {
+		return nil, errors.New("s3 storage requires an endpoint and bucket")
+	}
+
+	if accessKeyId == "" || secretAccessKey == "" {
+		return nil, errors.New("s3 storage requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	if region == "" {
+		region = DefaultS3Region
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &S3Storage{
+		Endpoint:        strings.TrimSuffix(endpoint, "/"),
+		Bucket:          bucket,
+		Prefix:          prefix,
+		Region:          region,
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		Client:          &http.Client{Transport: newS3Transport()},
+	}, nil
+}
+
+// Creates a transport which times out connecting and waiting for responses
+// without limiting how long bodies take to read.
+func newS3Transport() *http.Transport {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: S3DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = S3DialTimeout
+	transport.ResponseHeaderTimeout = S3ResponseHeaderTimeout
+	return transport
+}
+
+func (s *S3Storage) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, s.Prefix)
+}
+
+// Percent-encodes everything except unreserved characters as required by
+// Signature Version 4.
+func awsEscape(s string, keepSlashes bool) string {
+
+	var builder strings.Builder
+
+	for _, b := range []byte(s) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' ||
+			b == '-' || b == '.' || b == '_' || b == '~' || (keepSlashes && b == '/') {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+
+	return builder.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Signs the request with AWS Signature Version 4. The host, range, and x-amz-*
+// headers are signed.
+func signS3Request(req *http.Request, payloadSha256 string, region string, accessKeyId string, secretAccessKey string, now time.Time) {
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadSha256)
+
+	host := req.Host
+
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	signedHeaders := slices.Sorted(maps.Keys(headers))
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range signedHeaders {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+
+	query := req.URL.Query()
+	canonicalQuery := make([]string, 0, len(query))
+
+	for name, values := range query {
+		for _, value := range values {
+			canonicalQuery = append(canonicalQuery, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+
+	sort.Strings(canonicalQuery)
+
+	canonicalUri := req.URL.EscapedPath()
+
+	if canonicalUri == "" {
+		canonicalUri = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalUri,
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadSha256,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyId, scope, strings.Join(signedHeaders, ";"), hex.EncodeToString(hmacSha256(key, stringToSign))))
+}
+
+// Sends a signed request for the key. Responses other than 2xx are returned
+// as errors, and 404 errors match fs.ErrNotExist.
+func (s *S3Storage) do(op string, method string, name string, key string, query url.Values, body io.ReadSeeker, header http.Header) (*http.Response, error) {
+
+	requestUrl := fmt.Sprintf("%s/%s/%s", s.Endpoint, awsEscape(s.Bucket, false), awsEscape(key, true))
+
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, requestUrl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadSha256 := emptyPayloadSha256
+
+	if body != nil {
+
+		hasher := sha256.New()
+		size, err := io.Copy(hasher, body)
+
+		if err == nil {
+			_, err = body.Seek(0, io.SeekStart)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		payloadSha256 = hex.EncodeToString(hasher.Sum(nil))
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+	}
+
+	signS3Request(req, payloadSha256, s.Region, s.AccessKeyId, s.SecretAccessKey, time.Now())
+	resp, err := s.Client.Do(req)
+
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("%s %s failed with %s: %s", method, key, resp.Status, message)}
+}
+
+// Describes an object or a dir of objects sharing a prefix.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	// The ETag of an opened object which changes whenever its content does
+	etag string
+}
+
+func (i *s3FileInfo) Name() string {
+	return i.name
+}
+
+func (i *s3FileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *s3FileInfo) Mode() fs.FileMode {
+
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+
+	return 0o444
+}
+
+func (i *s3FileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *s3FileInfo) IsDir() bool {
+	return i.dir
+}
+
+func (i *s3FileInfo) Sys() any {
+	return nil
+}
+
+// An object which is downloaded with range requests starting from the offset
+// so that seeking doesn't download the skipped bytes.
+type s3File struct {
+	storage *S3Storage
+	path    string
+	info    s3FileInfo
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) {
+	return &f.info, nil
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", f.offset)}}
+		resp, err := f.storage.do("read", "GET", f.path, f.storage.Prefix+f.path, nil, nil, header)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if resp.StatusCode != http.StatusPartialContent && f.offset > 0 {
+			resp.Body.Close()
+			return 0, &fs.PathError{Op: "read", Path: f.path, Err: errors.New("range requests are not supported")}
+		}
+
+		f.body = resp.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+
+	if err == io.EOF && f.offset < f.info.size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.path, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+
+	if f.body != nil {
+		return f.body.Close()
+	}
+
+	return nil
+}
+
+// Opens an object. The object is only downloaded once it's read.
+func (s *S3Storage) Open(name string) (fs.File, error) {
+
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resp, err := s.do("open", "HEAD", name, s.Prefix+name, nil, nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &s3File{
+		storage: s,
+		path:    name,
+		info:    s3FileInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime, etag: resp.Header.Get("ETag")},
+	}, nil
+}
+
+// The subset of the ListObjectsV2 response used to read dirs.
+type s3ListBucketResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Lists the objects and dirs directly under the dir in order by name.
+func (s *S3Storage) ReadDir(name string) ([]fs.DirEntry, error) {
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	prefix := s.Prefix
+
+	if name != "." {
+		prefix += name + "/"
+	}
+
+	entries := make([]fs.DirEntry, 0)
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+
+	for {
+		resp, err := s.do("readdir", "GET", name, "", query, nil, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(io.LimitReader(resp.Body, 16*MB)).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+
+		for _, commonPrefix := range result.CommonPrefixes {
+			dirName := strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/")
+			entries = append(entries, fs.FileInfoToDirEntry(&s3FileInfo{name: dirName, dir: true}))
+		}
+
+		for _, object := range result.Contents {
+
+			// Skip markers for empty dirs created by some clients.
+			if object.Key == prefix {
+				continue
+			}
+
+			entries = append(entries, fs.FileInfoToDirEntry(&s3FileInfo{
+				name:    strings.TrimPrefix(object.Key, prefix),
+				size:    object.Size,
+				modTime: object.LastModified,
+			}))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	slices.SortFunc(entries, func(a fs.DirEntry, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return entries, nil
+}
+
+// Uploads the object. Objects are only visible once completely uploaded.
+func (s *S3Storage) WriteFile(name string, content io.ReadSeeker, permissions fs.FileMode) error {
+
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err := s.do("write", "PUT", name, s.Prefix+name, nil, content, header)
+
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (s *S3Storage) Remove(name string) error {
+
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resp, err := s.do("remove", "DELETE", name, s.Prefix+name, nil, nil, nil)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/stiemannkj1/auto-update-example/common"
+)
+
+// The kinds of storage which Settings.Storage may select.
+const (
+	// Versions in Settings.PokemonVersionDir
+	StorageFile string = "file"
+	// Versions in an S3-compatible bucket
+	StorageS3 string = "s3"
+	// Versions in the read-only zip archive Settings.StorageArchive
+	StorageZip string = "zip"
+	// Versions in the read-only embedded dir built into the server
+	StorageEmbed string = "embed"
+)
+
+// Writes to storage which can only be read.
+var errReadOnlyStorage = errors.New("the version storage is read-only")
+
+// Versions which are built into the server with StorageEmbed. Copy versions
+// into the embedded dir in the version dir layout before building the
+// server.
+//
+//go:embed all:embedded
+var embeddedVersions embed.FS
+
+// Where the executables, signatures, and rollouts of each version are stored
+// in the version dir layout. Names are slash-separated paths relative to the
+// root such as "1.0.0/pokemon". Missing files are errors which match
+// fs.ErrNotExist.
+type Storage interface {
+	fs.ReadDirFS
+	// Writes the content so that readers never find it partially written,
+	// creating dirs as needed. Read-only storage returns errReadOnlyStorage.
+	WriteFile(name string, content io.ReadSeeker, permissions fs.FileMode) error
+	// Removes the file. Removing a missing file succeeds.
+	Remove(name string) error
+	// Describes where the versions are stored for logs.
+	String() string
+}
+
+// Creates the storage selected in the settings.
+func newStorage(settings *Settings) (Storage, error) {
+
+	switch settings.Storage {
+	case "", StorageFile:
+		return NewFileStorage(settings.PokemonVersionDir), nil
+	case StorageS3:
+		return NewS3Storage(settings.S3Endpoint, settings.S3Bucket, settings.S3Prefix, settings.S3Region,
+			os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	case StorageZip:
+		archive, err := zip.OpenReader(settings.StorageArchive)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The archive stays open for the life of the server.
+		return &ArchiveStorage{Name: "zip:" + settings.StorageArchive, FS: archive}, nil
+	case StorageEmbed:
+		versions, err := fs.Sub(embeddedVersions, "embedded")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ArchiveStorage{Name: "embed:embedded", FS: versions}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage \"%s\"; expected %s, %s, %s, or %s", settings.Storage, StorageFile, StorageS3, StorageZip, StorageEmbed)
+	}
+}
+
+// Versions stored in a dir on the local filesystem.
+type FileStorage struct {
+	Dir string
+}
+
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{Dir: dir}
+}
+
+func (s *FileStorage) String() string {
+	return s.Dir
+}
+
+func (s *FileStorage) Open(name string) (fs.File, error) {
+	return os.DirFS(s.Dir).Open(name)
+}
+
+func (s *FileStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(os.DirFS(s.Dir), name)
+}
+
+// Gets the local path of the name or an error if the name is invalid.
+func (s *FileStorage) path(op string, name string) (string, error) {
+
+	if !fs.ValidPath(name) || name == "." {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return filepath.Join(s.Dir, filepath.FromSlash(name)), nil
+}
+
+func (s *FileStorage) WriteFile(name string, content io.ReadSeeker, permissions fs.FileMode) error {
+
+	filePath, err := s.path("write", name)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filePath)
+	_, statErr := os.Stat(dir)
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// Remove a dir created for a failed write so that the server doesn't warn
+	// about a version without an executable.
+	if err = common.CopyFileAtomic(filePath, content, permissions); err != nil && os.IsNotExist(statErr) {
+		os.Remove(dir)
+	}
+
+	return err
+}
+
+// Removes the file and its dir once the dir is empty.
+func (s *FileStorage) Remove(name string) error {
+
+	filePath, err := s.path("remove", name)
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if path.Dir(name) != "." {
+		os.Remove(filepath.Dir(filePath))
+	}
+
+	return nil
+}
+
+// Versions stored in a read-only archive such as a zip file or embedded dir.
+// Hidden files such as .gitkeep are ignored. Use the Lock when reading and
+// writing the Contents otherwise access will not be thread-safe.
+type ArchiveStorage struct {
+	Name string
+	FS   fs.FS
+	// The content of each file which can't seek keyed by name
+	Contents map[string][]byte
+	Lock     sync.Mutex
+}
+
+func (s *ArchiveStorage) String() string {
+	return s.Name
+}
+
+// Opens the file. Files in zip archives can't seek, so each one is read into
+// memory the first time it's opened to serve range requests. Archives never
+// change, so its content is kept for later opens.
+func (s *ArchiveStorage) Open(name string) (fs.File, error) {
+
+	file, err := s.FS.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, seekable := file.(io.Seeker); seekable {
+		return file, nil
+	}
+
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		return file, err
+	}
+
+	defer file.Close()
+
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	content, cached := s.Contents[name]
+
+	if !cached {
+
+		if content, err = io.ReadAll(file); err != nil {
+			return nil, err
+		}
+
+		if s.Contents == nil {
+			s.Contents = map[string][]byte{}
+		}
+
+		s.Contents[name] = content
+	}
+
+	return &archiveFile{File: file, Reader: bytes.NewReader(content)}, nil
+}
+
+// A file read into memory so that it can seek.
+type archiveFile struct {
+	fs.File
+	Reader *bytes.Reader
+}
+
+func (f *archiveFile) Read(buffer []byte) (int, error) {
+	return f.Reader.Read(buffer)
+}
+
+func (f *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	return f.Reader.Seek(offset, whence)
+}
+
+func (s *ArchiveStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+
+	entries, err := fs.ReadDir(s.FS, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]fs.DirEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			visible = append(visible, entry)
+		}
+	}
+
+	return visible, nil
+}
+
+func (s *ArchiveStorage) WriteFile(name string, content io.ReadSeeker, permissions fs.FileMode) error {
+	return errReadOnlyStorage
+}
+
+func (s *ArchiveStorage) Remove(name string) error {
+	return errReadOnlyStorage
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Writes a zip archive with a pokemon executable for each version.
+func writeTestArchive(t *testing.T, versions ...string) string {
+
+	archivePath := filepath.Join(t.TempDir(), "versions.zip")
+	file, err := os.Create(archivePath)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	defer file.Close()
+	archive := zip.NewWriter(file)
+
+	for _, name := range append([]string{".gitkeep"}, versions...) {
+
+		if !strings.HasPrefix(name, ".") {
+			name = path.Join(name, Pokemon)
+		}
+
+		writer, err := archive.Create(name)
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		writer.Write([]byte(path.Dir(name)))
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	return archivePath
+}
+
+func TestFileStorageWritesAndRemovesFiles(t *testing.T) {
+
+	storage := NewFileStorage(t.TempDir())
+
+	if err := storage.WriteFile("1.0.0/pokemon", strings.NewReader("1.0.0"), 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if info, err := os.Stat(filepath.Join(storage.Dir, "1.0.0", Pokemon)); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("Expected an executable but found %v: %v", info, err)
+	}
+
+	if content, err := fs.ReadFile(storage, "1.0.0/pokemon"); err != nil || string(content) != "1.0.0" {
+		t.Errorf("Expected 1.0.0 but found %s: %v", content, err)
+	}
+
+	for _, name := range []string{".", "../pokemon", "/1.0.0/pokemon"} {
+		if err := storage.WriteFile(name, strings.NewReader(""), 0o644); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Expected %v for %s but found %v", fs.ErrInvalid, name, err)
+		}
+	}
+
+	if err := storage.Remove("1.0.0/pokemon"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The empty version dir is removed with its last file.
+	if _, err := os.Stat(filepath.Join(storage.Dir, "1.0.0")); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty version dir to be removed but found %v", err)
+	}
+
+	if err := storage.Remove("1.0.0/pokemon"); err != nil {
+		t.Errorf("Expected removing a missing file to succeed but found %v", err)
+	}
+}
+
+func TestArchiveStorageIsReadOnly(t *testing.T) {
+
+	storage, err := newStorage(&Settings{Storage: StorageZip, StorageArchive: writeTestArchive(t, "1.0.0")})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err := storage.WriteFile("2.0.0/pokemon", strings.NewReader("2.0.0"), 0o755); !errors.Is(err, errReadOnlyStorage) {
+		t.Errorf("Expected %v but found %v", errReadOnlyStorage, err)
+	}
+
+	if err := storage.Remove("1.0.0/pokemon"); !errors.Is(err, errReadOnlyStorage) {
+		t.Errorf("Expected %v but found %v", errReadOnlyStorage, err)
+	}
+
+	if _, err := uploadVersion(storage, LayoutVersion, NewLedger(""), "2.0.0", strings.NewReader("2.0.0"), sha512Hex("2.0.0"), nil); !errors.Is(err, errReadOnlyStorage) {
+		t.Errorf("Expected %v but found %v", errReadOnlyStorage, err)
+	}
+
+	// The embedded dir only has hidden files unless versions are copied into
+	// it before building.
+	if storage, err = newStorage(&Settings{Storage: StorageEmbed}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if entries, err := storage.ReadDir("."); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no embedded versions but found %v: %v", entries, err)
+	}
+
+	if _, err := newStorage(&Settings{Storage: "ftp"}); err == nil {
+		t.Errorf("Expected an error for unknown storage")
+	}
+}
+
+func TestStoragesServeVersions(t *testing.T) {
+
+	s3Storage, _ := newFakeS3(t)
+	settings, _ := newTestVersions(t)
+
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+
+		writeTestVersion(t, settings, version, version)
+
+		if err := s3Storage.WriteFile(path.Join(version, Pokemon), strings.NewReader(version), 0o755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	zipStorage, err := newStorage(&Settings{Storage: StorageZip, StorageArchive: writeTestArchive(t, "1.0.0", "2.0.0")})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	storages := []Storage{NewFileStorage(settings.PokemonVersionDir), s3Storage, zipStorage}
+
+	for _, storage := range storages {
+		t.Run(storage.String(), func(t *testing.T) {
+
+			versions := &VersionsCache{Ledger: NewLedger(""), Storage: storage}
+
+			if _, err := updateVersions(newTestLogger(), settings, versions, NewMetrics()); err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			if offered := offeredVersions(versions); !slices.Equal(offered, []string{"1.0.0", "2.0.0"}) {
+				t.Errorf("Expected [1.0.0 2.0.0] but found %v", offered)
+			}
+
+			if metadata, exists := getMetadata(versions, "2.0.0"); !exists || metadata.Sha512 != sha512Hex("2.0.0") {
+				t.Errorf("Expected the hash of 2.0.0 but found %+v", metadata)
+			}
+
+			recorder := httptest.NewRecorder()
+			http.ServeFileFS(recorder, httptest.NewRequest("GET", "/v1.0/downloads/pokemon?version=2.0.0", nil), storage, getFileName(versions, "2.0.0"))
+
+			if recorder.Code != http.StatusOK || recorder.Body.String() != "2.0.0" {
+				t.Errorf("Expected 2.0.0 but found %d %s", recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestArchiveStorageReadsEachFileOnce(t *testing.T) {
+
+	storage, err := newStorage(&Settings{Storage: StorageZip, StorageArchive: writeTestArchive(t, "1.0.0", "2.0.0")})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	archive := storage.(*ArchiveStorage)
+
+	for range 2 {
+
+		file, err := archive.Open("2.0.0/pokemon")
+
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		// Files are still seekable for range requests.
+		if _, err = file.(io.Seeker).Seek(4, io.SeekStart); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if rest, err := io.ReadAll(file); err != nil || string(rest) != "0" {
+			t.Errorf("Expected 0 but found %s: %v", rest, err)
+		}
+
+		file.Close()
+	}
+
+	if len(archive.Contents) != 1 || string(archive.Contents["2.0.0/pokemon"]) != "2.0.0" {
+		t.Errorf("Expected only 2.0.0 to be kept in memory but found %v", archive.Contents)
+	}
+}
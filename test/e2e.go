@@ -143,7 +143,7 @@ func main() {
 		"go",
 		"build",
 		"-ldflags",
-		"-X 'main.Version=2.0.0' -X 'main.UpdateUrl=http://localhost:8080' -X 'main.AvailablePokemon=pikachu,charmander,squirtle,bulbasaur'",
+		"-X 'main.Version=2.0.0' -X 'main.UpdateUrl=http://localhost:8080'",
 		"-o",
 		filepath.FromSlash("./test/demo/version/2.0.0/pokemon"),
 		filepath.FromSlash("./pokemon"),
@@ -163,12 +163,31 @@ func main() {
 		"go",
 		"build",
 		"-ldflags",
-		"-X 'main.Version=10.0.0' -X 'main.UpdateUrl=http://localhost:8080' -X 'main.AvailablePokemon=pikachu,raichu,charmander,charmeleon,squirtle,wartortle,bulbasaur,ivysaur'",
+		"-X 'main.Version=10.0.0' -X 'main.UpdateUrl=http://localhost:8080'",
 		"-o",
 		filepath.FromSlash("./test/demo/version/10.0.0/pokemon"),
 		filepath.FromSlash("./pokemon"),
 	)
 
+	// Build CLI v20.0.0 and roll it out to the beta channel only.
+	_, _ = runCommand(
+		timeoutSecs,
+		nil,
+		"go",
+		"build",
+		"-ldflags",
+		"-X 'main.Version=20.0.0' -X 'main.UpdateUrl=http://localhost:8080'",
+		"-o",
+		filepath.FromSlash("./test/demo/version/20.0.0/pokemon"),
+		filepath.FromSlash("./pokemon"),
+	)
+
+	rolloutPath := filepath.FromSlash("./test/demo/version/20.0.0/rollout.json")
+
+	if err = os.WriteFile(rolloutPath, []byte("{\"Channel\": \"beta\"}\n"), 0o644); err != nil {
+		panic(fmt.Sprintf("Failed to write \"%s\":\n%v", rolloutPath, err))
+	}
+
 	// Build the server.
 	_, _ = runCommand(timeoutSecs, nil, "go", "build", "-o", exe("./test/demo/server"), filepath.FromSlash("./server"))
 
@@ -180,31 +199,56 @@ func main() {
 	err = nil
 	start := time.Now().UnixMilli()
 
-	for (time.Now().UnixMilli() - start) > timeoutSecs {
+	for (time.Now().UnixMilli() - start) < timeoutSecs*1000 {
 		var resp *http.Response
 		resp, err = http.Get("http://localhost:8080/healthcheck")
 
-		if err == nil && resp.StatusCode == 200 {
-			break
-		}
+		if err == nil {
+			resp.Body.Close()
 
-		resp.Body.Close()
+			if resp.StatusCode == 200 {
+				break
+			}
+		}
 
 		// Otherwise retry.
+		time.Sleep(100 * time.Millisecond)
 	}
 
 	if err != nil {
 		panic(fmt.Sprintf("Failed to start server in %d seconds:\n%v", timeoutSecs, err))
 	}
 
-	// Attempt to run CLI v2.0.0 with a pokemon from v10.0.0. If the command
-	// fails, fail the test.
-	stdout, stderr := runCommand(timeoutSecs, []string{}, exe("./test/demo/pokemon"), "raichu")
+	// Run the CLI with its own cache so that the developer's cached versions,
+	// state, and client ID don't change the results.
+	cacheDir, err := os.MkdirTemp("", "pokemon-e2e-cache-")
+
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create cache dir:\n%v", err))
+	}
+
+	defer os.RemoveAll(cacheDir)
+
+	// Attempt to run CLI v2.0.0 with charizard which is only available since
+	// v3.0.0, so the CLI must update to v10.0.0 before greeting. If the
+	// command fails, fail the test.
+	stdout, stderr := runCommand(timeoutSecs, []string{}, exe("./test/demo/pokemon"), "--cache-dir", cacheDir, "charizard")
 	// TODO this fails in docker even though a manual test runs correctly.
 
-	// If stdout doesn't show a greeting from raichu, fail the test.
-	if !strings.Contains(strings.ToLower(stdout), "raichu") {
-		panic(fmt.Sprintf("Test failed. \"%s\" not found in stdout.\nStdout:\n%s\nStderr:\n%s\n", "raichu", stdout, stderr))
+	// If stdout doesn't show a greeting from charizard, fail the test.
+	if !strings.Contains(strings.ToLower(stdout), "charizard") {
+		panic(fmt.Sprintf("Test failed. \"%s\" not found in stdout.\nStdout:\n%s\nStderr:\n%s\n", "charizard", stdout, stderr))
+	}
+
+	// Only clients following the beta channel are offered v20.0.0.
+	for _, channelArgs := range [][]string{{}, {"--channel", "beta"}} {
+
+		beta := len(channelArgs) > 0
+		stdout, stderr = runCommand(timeoutSecs, []string{}, exe("./test/demo/pokemon"), append(channelArgs, "--cache-dir", cacheDir, "update", "list")...)
+
+		if offered := strings.Contains(stdout, "20.0.0"); offered != beta {
+			panic(fmt.Sprintf("Test failed. Expected 20.0.0 offered with %v to be %t.\nStdout:\n%s\nStderr:\n%s\n", channelArgs, beta, stdout, stderr))
+		}
 	}
 
 	// The server detected versions from the file system and exposed them via the API.